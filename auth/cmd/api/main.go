@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"net/http"
 	"os"
@@ -15,12 +16,24 @@ import (
 	"github.com/yoshapihoff/bricks/auth/internal/config"
 	"github.com/yoshapihoff/bricks/auth/internal/db"
 	httpHandler "github.com/yoshapihoff/bricks/auth/internal/handler/http"
+	"github.com/yoshapihoff/bricks/auth/internal/janitor"
+	"github.com/yoshapihoff/bricks/auth/internal/kafka"
 	"github.com/yoshapihoff/bricks/auth/internal/kafka/producers"
+	"github.com/yoshapihoff/bricks/auth/internal/oauth"
+	"github.com/yoshapihoff/bricks/auth/internal/outbox"
+	"github.com/yoshapihoff/bricks/auth/internal/ratelimit"
 	repo "github.com/yoshapihoff/bricks/auth/internal/repository"
 	"github.com/yoshapihoff/bricks/auth/internal/service"
+	"github.com/yoshapihoff/bricks/auth/internal/valkey"
+	"github.com/yoshapihoff/bricks/auth/internal/webauthn"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
@@ -34,25 +47,213 @@ func main() {
 	}
 	defer dbConn.Close()
 
+	// Initialize Valkey
+	valkeyClient, err := valkey.Init(cfg.Valkey)
+	if err != nil {
+		log.Fatalf("Failed to initialize Valkey: %v", err)
+	}
+	defer valkeyClient.Close()
+
 	// Create repositories
 	userRepo := repo.NewUserRepository(dbConn)
+	outboxRepo := repo.NewOutboxRepository(dbConn)
+	refreshTokenRepo := repo.NewRefreshTokenRepository(dbConn)
+	emailChangeTokenRepo := repo.NewEmailChangeTokenRepository(dbConn)
+	emailVerificationTokenRepo := repo.NewEmailVerificationTokenRepository(dbConn)
+	mfaChallengeRepo := repo.NewMFAChallengeRepository(dbConn)
+	magicLinkTokenRepo := repo.NewMagicLinkTokenRepository(dbConn)
+	sessionRepo := repo.NewSessionRepository(dbConn)
+	roleRepo := repo.NewRoleRepository(dbConn)
+	transactor := repo.NewTransactor(dbConn)
+
+	// Initialize signing keys, HS256 keeps using the shared secret
+	var keyManager *auth.KeyManager
+	if cfg.JWT.Algorithm != auth.AlgHS256 {
+		keyManager, err = auth.NewKeyManager(repo.NewSigningKeyRepository(dbConn), auth.KeyManagerConfig{
+			Algorithm:        cfg.JWT.Algorithm,
+			RotationInterval: cfg.JWT.KeyRotationInterval,
+			Overlap:          max(cfg.JWT.KeyOverlap, cfg.JWT.Expiration),
+			RefreshInterval:  time.Minute,
+			EncryptionKey:    cfg.JWT.KeyEncryptionKey,
+		})
+		if err != nil {
+			log.Fatalf("Failed to initialize signing keys: %v", err)
+		}
+		if err := keyManager.Refresh(context.Background()); err != nil {
+			log.Fatalf("Failed to load signing keys: %v", err)
+		}
+	}
 
 	// Initialize JWT service
 	jwtSvc := auth.NewJWTService(auth.JWTConfig{
 		Secret:     cfg.JWT.Secret,
 		Expiration: cfg.JWT.Expiration,
-	})
+	}, auth.NewValkeyRevocationStore(valkeyClient, cfg.JWT.Expiration), keyManager)
+
+	// Initialize email outbox producers
+	forgotPasswordEmailProducer := producers.NewForgotPasswordEmailProducer(outboxRepo, cfg.ForgotPasswordEmailSendingTopic)
+	emailChangeEmailProducer := producers.NewEmailChangeEmailProducer(outboxRepo, cfg.EmailChangeEmailSendingTopic)
+	verifyEmailProducer := producers.NewVerifyEmailProducer(outboxRepo, cfg.EmailVerificationEmailSendingTopic)
+	magicLinkEmailProducer := producers.NewMagicLinkEmailProducer(outboxRepo, cfg.MagicLinkEmailSendingTopic)
 
 	// Initialize services
-	userSvc := service.NewUserService(userRepo, jwtSvc)
-	passwordResetTokenSvc := service.NewPasswordResetTokenService(repo.NewPasswordResetTokenRepository(dbConn), userSvc)
+	// Failed logins are counted per email in Valkey, so unknown emails are delayed and locked like known ones
+	userSvc := service.NewUserService(userRepo, roleRepo, jwtSvc, cfg.RequireVerifiedEmail, service.LockoutPolicy{
+		Threshold: cfg.Lockout.Threshold,
+		Duration:  cfg.Lockout.Duration,
+		DelayBase: cfg.Lockout.DelayBase,
+		DelayMax:  cfg.Lockout.DelayMax,
+	}, ratelimit.NewFallbackFailureCounter(ratelimit.NewValkeyFailureCounter(valkeyClient), ratelimit.NewMemoryFailureCounter()))
+	// Limits are shared through Valkey and kept per replica while it is unreachable
+	attemptLimiter := service.NewAttemptLimiter(
+		ratelimit.NewFallbackLimiter(ratelimit.NewValkeyLimiter(valkeyClient), ratelimit.NewMemoryLimiter()),
+		map[string]service.AttemptPolicy{
+			service.ActionLogin: {
+				PerIP:      cfg.RateLimit.LoginPerIP,
+				PerAccount: cfg.RateLimit.LoginPerAccount,
+				Window:     cfg.RateLimit.Window,
+			},
+			service.ActionLoginMFA: {
+				PerIP:      cfg.RateLimit.LoginMFAPerIP,
+				PerAccount: cfg.RateLimit.LoginMFAPerChallenge,
+				Window:     cfg.RateLimit.Window,
+			},
+			service.ActionForgotPassword: {
+				PerIP:      cfg.RateLimit.ForgotPasswordPerIP,
+				PerAccount: cfg.RateLimit.ForgotPasswordPerAccount,
+				Window:     cfg.RateLimit.Window,
+			},
+			service.ActionMagicLink: {
+				PerIP:      cfg.RateLimit.MagicLinkPerIP,
+				PerAccount: cfg.RateLimit.MagicLinkPerAccount,
+				Window:     cfg.RateLimit.Window,
+			},
+		},
+	)
+	refreshTokenSvc := service.NewRefreshTokenService(
+		refreshTokenRepo,
+		sessionRepo,
+		transactor,
+		cfg.JWT.RefreshTokenExpiration,
+	)
+	sessionSvc := service.NewSessionService(sessionRepo, refreshTokenRepo, transactor)
+	passwordResetTokenSvc := service.NewPasswordResetTokenService(
+		repo.NewPasswordResetTokenRepository(dbConn),
+		userRepo,
+		userSvc,
+		transactor,
+		forgotPasswordEmailProducer,
+	)
+	mfaSvc := service.NewMFAService(
+		repo.NewMFARepository(dbConn),
+		mfaChallengeRepo,
+		userRepo,
+		transactor,
+		cfg.MFA.Issuer,
+		cfg.MFA.ChallengeExpiration,
+	)
+	emailChangeSvc := service.NewEmailChangeService(
+		emailChangeTokenRepo,
+		userRepo,
+		mfaSvc,
+		transactor,
+		emailChangeEmailProducer,
+		cfg.EmailChangeTokenExpiration,
+	)
+	emailVerificationSvc := service.NewEmailVerificationService(
+		emailVerificationTokenRepo,
+		userRepo,
+		transactor,
+		verifyEmailProducer,
+		cfg.EmailVerificationTokenExpiration,
+	)
+	magicLinkSvc := service.NewMagicLinkService(
+		magicLinkTokenRepo,
+		userRepo,
+		transactor,
+		magicLinkEmailProducer,
+		cfg.MagicLinkTokenExpiration,
+	)
+	passkeySvc := service.NewPasskeyService(
+		repo.NewPasskeyRepository(dbConn),
+		userRepo,
+		webauthn.NewRelyingParty(webauthn.Config{
+			RPID:         cfg.WebAuthn.RPID,
+			RPName:       cfg.WebAuthn.RPName,
+			Origins:      cfg.WebAuthn.Origins,
+			ChallengeTTL: cfg.WebAuthn.ChallengeTTL,
+		}, webauthn.NewValkeyChallengeStore(valkeyClient)),
+	)
+
+	// Initialize OAuth providers
+	oauthProviders, err := newOAuthProviders(context.Background(), cfg.OAuth)
+	if err != nil {
+		log.Fatalf("Failed to initialize OAuth providers: %v", err)
+	}
+	oauthManager := oauth.NewManager(oauth.NewValkeyStateStore(valkeyClient), cfg.OAuth.StateTTL, oauthProviders...)
+	roleSvc := service.NewRoleService(roleRepo, userRepo, transactor)
+	oauthSvc := service.NewOAuthService(userRepo, repo.NewUserIdentityRepository(dbConn), transactor)
 
-	// Initialize forgot password email Kafka producer
-	forgotPasswordEmailProducer, err := producers.NewForgotPasswordEmailProducer(cfg)
+	// Initialize Kafka producer
+	srProducer, err := kafka.NewProducer(cfg.Kafka.KafkaUrl, cfg.Kafka.SchemaRegistryUrl, kafka.DefaultProducerConfig())
 	if err != nil {
-		log.Fatalf("Failed to initialize forgot password email Kafka producer: %v", err)
+		log.Fatalf("Failed to initialize Kafka producer: %v", err)
 	}
-	defer forgotPasswordEmailProducer.Close()
+	defer srProducer.Close()
+
+	// Run outbox relay in a goroutine, it shares the background context with key rotation
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	outboxRelay := outbox.NewRelay(transactor, outboxRepo, srProducer, outbox.RelayConfig{
+		Interval:        cfg.Outbox.RelayInterval,
+		BatchSize:       cfg.Outbox.RelayBatchSize,
+		MaxAttempts:     cfg.Outbox.MaxAttempts,
+		RetryBackoff:    cfg.Outbox.RetryBackoff,
+		MaxRetryBackoff: cfg.Outbox.MaxRetryBackoff,
+		Lease:           cfg.Outbox.Lease,
+	})
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		outboxRelay.Run(backgroundCtx)
+	}()
+
+	// Rotate signing keys in a goroutine
+	if keyManager != nil {
+		go keyManager.Run(backgroundCtx)
+	}
+
+	// Purge expired rows in a goroutine, the advisory lock keeps it to one replica
+	janitorConfig := janitor.DefaultConfig()
+	janitorConfig.Interval = cfg.Janitor.Interval
+	dbJanitor := janitor.New(dbConn, janitorConfig,
+		janitor.Task{Name: "password_reset_tokens", Run: func(ctx context.Context) error {
+			return passwordResetTokenSvc.ClearFromOld(ctx, time.Now().Add(-cfg.PasswordResetTokenExpiration))
+		}},
+		janitor.Task{Name: "magic_link_tokens", Run: func(ctx context.Context) error {
+			return magicLinkTokenRepo.ClearFromOld(ctx, time.Now().Add(-cfg.MagicLinkTokenExpiration))
+		}},
+		janitor.Task{Name: "email_verification_tokens", Run: func(ctx context.Context) error {
+			return emailVerificationTokenRepo.DeleteExpired(ctx, time.Now())
+		}},
+		janitor.Task{Name: "email_change_tokens", Run: func(ctx context.Context) error {
+			return emailChangeTokenRepo.DeleteExpired(ctx, time.Now())
+		}},
+		janitor.Task{Name: "mfa_challenges", Run: func(ctx context.Context) error {
+			return mfaChallengeRepo.DeleteExpired(ctx, time.Now())
+		}},
+		janitor.Task{Name: "refresh_tokens", Run: func(ctx context.Context) error {
+			return refreshTokenRepo.DeleteExpired(ctx, time.Now())
+		}},
+		// A session that has not refreshed within the refresh token lifetime cannot be resumed
+		janitor.Task{Name: "sessions", Run: func(ctx context.Context) error {
+			return sessionRepo.DeleteInactive(ctx, time.Now().Add(-cfg.JWT.RefreshTokenExpiration))
+		}},
+		janitor.Task{Name: "outbox", Run: func(ctx context.Context) error {
+			return outboxRepo.DeleteSentBefore(ctx, time.Now().Add(-cfg.Janitor.OutboxRetention))
+		}},
+	)
+	go dbJanitor.Run(backgroundCtx)
 
 	// Create HTTP server
 	r := mux.NewRouter()
@@ -62,15 +263,29 @@ func main() {
 		userSvc,
 		jwtSvc,
 		passwordResetTokenSvc,
+		refreshTokenSvc,
+		sessionSvc,
+		oauthSvc,
+		oauthManager,
+		emailChangeSvc,
+		emailVerificationSvc,
+		mfaSvc,
+		passkeySvc,
+		magicLinkSvc,
+		roleSvc,
+		attemptLimiter,
 		cfg.PasswordResetTokenExpiration,
-		forgotPasswordEmailProducer,
 	)
 	handler.RegisterRoutes(r)
 
-	// Health check endpoint
+	// Health check endpoint, it reports the last janitor run
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "OK",
+			"janitor": dbJanitor.Status(),
+		})
 	}).Methods("GET")
 
 	// Start server
@@ -104,6 +319,53 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("Could not gracefully shutdown the server: %v\n", err)
 	}
+	// Tokens created after answering still have to reach the outbox before the relay stops
+	handler.Wait()
+
+	stopBackground()
+	<-relayDone
 
 	log.Println("Server stopped")
 }
+
+// newOAuthProviders creates the providers that have a client ID configured
+func newOAuthProviders(ctx context.Context, cfg config.OAuthConfig) ([]oauth.Provider, error) {
+	redirectURL := func(provider string) string {
+		return cfg.RedirectBaseURL + "/auth/oauth/" + provider + "/callback"
+	}
+
+	var providers []oauth.Provider
+	if cfg.Google.ClientID != "" {
+		google, err := oauth.NewGoogleProvider(ctx, oauth.ProviderConfig{
+			ClientID:     cfg.Google.ClientID,
+			ClientSecret: cfg.Google.ClientSecret,
+			RedirectURL:  redirectURL("google"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, google)
+	}
+	if cfg.GitHub.ClientID != "" {
+		providers = append(providers, oauth.NewGitHubProvider(oauth.ProviderConfig{
+			ClientID:     cfg.GitHub.ClientID,
+			ClientSecret: cfg.GitHub.ClientSecret,
+			RedirectURL:  redirectURL("github"),
+		}))
+	}
+	if cfg.OIDC.ClientID != "" {
+		provider, err := oauth.NewOIDCProvider(ctx, oauth.ProviderConfig{
+			Name:         cfg.OIDCName,
+			IssuerURL:    cfg.OIDCIssuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  redirectURL(cfg.OIDCName),
+		})
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+
+	return providers, nil
+}
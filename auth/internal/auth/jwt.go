@@ -2,56 +2,61 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/pkg/authz"
 )
 
 var (
-	ErrInvalidToken = errors.New("invalid token")
-	ErrExpiredToken = errors.New("token has expired")
+	ErrInvalidToken = authz.ErrInvalidToken
+	ErrExpiredToken = authz.ErrExpiredToken
+	ErrRevokedToken = errors.New("token has been revoked")
 )
 
-type Claims struct {
-	UserID uuid.UUID `json:"user_id"`
-	Email  string    `json:"email"`
-	jwt.RegisteredClaims
-}
-
 type JWTConfig struct {
 	Secret     string
 	Expiration time.Duration
 }
 
 type JWTService interface {
-	GenerateToken(userID uuid.UUID, email string) (string, error)
-	ValidateToken(tokenString string) (*Claims, error)
+	GenerateToken(userID uuid.UUID, email string, sessionID uuid.UUID, grants authz.Grants) (string, error)
+	ValidateToken(ctx context.Context, tokenString string) (*authz.Claims, error)
 	Middleware() func(next http.Handler) http.Handler
 }
 
 type DefaultJWTService struct {
-	config JWTConfig
+	config      JWTConfig
+	revocations RevocationStore
+	// keys is nil when tokens are signed with the shared HS256 secret
+	keys *KeyManager
 }
 
-func NewJWTService(config JWTConfig) *DefaultJWTService {
+func NewJWTService(config JWTConfig, revocations RevocationStore, keys *KeyManager) *DefaultJWTService {
 	return &DefaultJWTService{
-		config: config,
+		config:      config,
+		revocations: revocations,
+		keys:        keys,
 	}
 }
 
-// GenerateToken creates a new JWT token for the given user
-func (s *DefaultJWTService) GenerateToken(userID uuid.UUID, email string) (string, error) {
+// GenerateToken creates a new JWT token for the given user session carrying the user's grants
+func (s *DefaultJWTService) GenerateToken(userID uuid.UUID, email string, sessionID uuid.UUID, grants authz.Grants) (string, error) {
 	expirationTime := time.Now().Add(s.config.Expiration)
 
-	claims := &Claims{
-		UserID: userID,
-		Email:  email,
+	claims := &authz.Claims{
+		UserID:      userID,
+		Email:       email,
+		SessionID:   sessionID,
+		Roles:       grants.Roles,
+		Permissions: grants.Permissions,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
@@ -59,20 +64,47 @@ func (s *DefaultJWTService) GenerateToken(userID uuid.UUID, email string) (strin
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.config.Secret))
+	if s.keys == nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString([]byte(s.config.Secret))
+	}
+
+	key, err := s.keys.signingKey()
+	if err != nil {
+		return "", err
+	}
+	method, err := signingMethod(key.algorithm)
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.private)
 }
 
-// ValidateToken validates the JWT token and returns the claims
-func (s *DefaultJWTService) ValidateToken(tokenString string) (*Claims, error) {
+// ValidateToken validates the JWT token, checks it has not been revoked and returns the claims
+func (s *DefaultJWTService) ValidateToken(ctx context.Context, tokenString string) (*authz.Claims, error) {
 	keyFunc := func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		if s.keys == nil {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			}
+			return []byte(s.config.Secret), nil
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		key, err := s.keys.verificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		// The key pins the algorithm so a token cannot pick a weaker one
+		if token.Method.Alg() != key.algorithm {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(s.config.Secret), nil
+		return key.private.Public(), nil
 	}
 
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, keyFunc)
+	token, err := jwt.ParseWithClaims(tokenString, &authz.Claims{}, keyFunc)
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
 			return nil, ErrExpiredToken
@@ -80,39 +112,50 @@ func (s *DefaultJWTService) ValidateToken(tokenString string) (*Claims, error) {
 		return nil, ErrInvalidToken
 	}
 
-	claims, ok := token.Claims.(*Claims)
+	claims, ok := token.Claims.(*authz.Claims)
 	if !ok || !token.Valid {
 		return nil, ErrInvalidToken
 	}
 
+	if s.revocations != nil {
+		revoked, err := s.revocations.IsRevoked(ctx, claims)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrRevokedToken
+		}
+	}
+
 	return claims, nil
 }
 
-// Middleware returns a JWT authentication middleware
-func (s *DefaultJWTService) Middleware() func(next http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				http.Error(w, "authorization header is required", http.StatusUnauthorized)
-				return
-			}
+// JWKSHandler serves the public keys used to verify tokens
+func (s *DefaultJWTService) JWKSHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		set := authz.JWKSet{Keys: []authz.JWK{}}
+		if s.keys != nil {
+			set = s.keys.JWKS()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(JWKSMaxAge.Seconds())))
+		if err := json.NewEncoder(w).Encode(set); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
 
-			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-			if tokenString == authHeader {
-				http.Error(w, "invalid authorization header format", http.StatusUnauthorized)
-				return
-			}
+// RevokeToken rejects the token described by claims until it expires
+func (s *DefaultJWTService) RevokeToken(ctx context.Context, claims *authz.Claims) error {
+	return s.revocations.RevokeToken(ctx, claims.ID, claims.ExpiresAt.Time)
+}
 
-			claims, err := s.ValidateToken(tokenString)
-			if err != nil {
-				http.Error(w, "invalid token", http.StatusUnauthorized)
-				return
-			}
+// RevokeUserTokens rejects every token issued to the user so far
+func (s *DefaultJWTService) RevokeUserTokens(ctx context.Context, userID uuid.UUID) error {
+	return s.revocations.RevokeUser(ctx, userID, time.Now())
+}
 
-			// Add user info to context
-			ctx := context.WithValue(r.Context(), "userID", claims.UserID)
-			next.ServeHTTP(w, r.WithContext(ctx))
-		})
-	}
+// Middleware returns a JWT authentication middleware that puts the token's claims in the context
+func (s *DefaultJWTService) Middleware() func(next http.Handler) http.Handler {
+	return authz.Middleware(s)
 }
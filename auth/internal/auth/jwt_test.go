@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/pkg/authz"
+)
+
+func TestTokensVerifyAgainstPublishedJWKS(t *testing.T) {
+	for _, algorithm := range []string{AlgRS256, AlgES256, AlgEdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			config := testKeyManagerConfig()
+			config.Algorithm = algorithm
+			keys, err := NewKeyManager(&memoryKeyStore{}, config)
+			if err != nil {
+				t.Fatalf("new key manager: %v", err)
+			}
+			if err := keys.Refresh(context.Background()); err != nil {
+				t.Fatalf("refresh: %v", err)
+			}
+			jwtSvc := NewJWTService(JWTConfig{Expiration: time.Minute}, nil, keys)
+			server := httptest.NewServer(jwtSvc.JWKSHandler())
+			t.Cleanup(server.Close)
+
+			userID, sessionID := uuid.New(), uuid.New()
+			token, err := jwtSvc.GenerateToken(userID, "user@example.com", sessionID, authz.Grants{
+				Roles:       []string{"admin"},
+				Permissions: []string{PermissionReadRoles},
+			})
+			if err != nil {
+				t.Fatalf("generate token: %v", err)
+			}
+
+			verifier := authz.NewJWKSVerifier(authz.JWKSConfig{URL: server.URL, Issuer: "auth-service"})
+			claims, err := verifier.ValidateToken(context.Background(), token)
+			if err != nil {
+				t.Fatalf("ValidateToken() error = %v", err)
+			}
+			if claims.UserID != userID || claims.SessionID != sessionID || !claims.HasPermission(PermissionReadRoles) {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+}
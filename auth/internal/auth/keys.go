@@ -0,0 +1,366 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+
+	"github.com/yoshapihoff/bricks/auth/pkg/authz"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+
+	rsaKeyBits = 2048
+
+	// JWKSMaxAge is how long clients may cache the JWKS
+	JWKSMaxAge = 5 * time.Minute
+)
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrUnknownKey           = errors.New("unknown signing key")
+	ErrInvalidEncryptionKey = errors.New("key encryption key must be 32 bytes")
+	ErrSealedKeyTooShort    = errors.New("sealed signing key is too short")
+)
+
+// KeyStore persists signing keys so every replica signs and verifies with the same set
+type KeyStore interface {
+	// CreateUnlessScheduled inserts key unless a key of the same algorithm activates after `after` and no later
+	// than key.ActivatesAt, it reports whether key was inserted. Only one replica may insert at a time.
+	CreateUnlessScheduled(ctx context.Context, key *dto.SigningKey, after time.Time) (bool, error)
+	// FindActive returns the keys that have not expired at now, latest activation first
+	FindActive(ctx context.Context, now time.Time) ([]*dto.SigningKey, error)
+}
+
+type KeyManagerConfig struct {
+	Algorithm string
+	// RotationInterval is how long a key is used for signing
+	RotationInterval time.Duration
+	// Overlap is how long a key keeps verifying tokens after it stops signing,
+	// it must be at least the access token lifetime
+	Overlap time.Duration
+	// RefreshInterval is how often keys are reloaded from the store
+	RefreshInterval time.Duration
+	// EncryptionKey is the AES-256 key encryption key that seals private keys at rest
+	EncryptionKey []byte
+}
+
+type signingKey struct {
+	id          string
+	algorithm   string
+	private     crypto.Signer
+	activatesAt time.Time
+}
+
+// KeyManager keeps the asymmetric signing keys and rotates them on schedule. The next key is published in
+// the JWKS before it starts signing, so verifiers that cache the JWKS know it by the time they see its tokens.
+type KeyManager struct {
+	store  KeyStore
+	config KeyManagerConfig
+	kek    cipher.AEAD
+
+	mu      sync.RWMutex
+	keys    map[string]*signingKey
+	current *signingKey
+}
+
+func NewKeyManager(store KeyStore, config KeyManagerConfig) (*KeyManager, error) {
+	if _, err := signingMethod(config.Algorithm); err != nil || config.Algorithm == AlgHS256 {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, config.Algorithm)
+	}
+	if len(config.EncryptionKey) != 32 {
+		return nil, ErrInvalidEncryptionKey
+	}
+	block, err := aes.NewCipher(config.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyManager{
+		store:  store,
+		config: config,
+		kek:    kek,
+		keys:   make(map[string]*signingKey),
+	}, nil
+}
+
+// Run refreshes and rotates keys every refresh interval until ctx is cancelled
+func (m *KeyManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Refresh(ctx); err != nil {
+				log.Printf("Failed to refresh signing keys: %v", err)
+			}
+		}
+	}
+}
+
+// Refresh reloads the active keys. Once the current key nears the end of its rotation interval it schedules
+// the next one to activate when the current one retires, at least one refresh and JWKS cache period ahead.
+func (m *KeyManager) Refresh(ctx context.Context) error {
+	now := time.Now()
+	keys, current, next, err := m.load(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case current == nil:
+		// Nothing is signing, so no verifier can be holding a token it lacks the key for
+		err = m.generate(ctx, now, now.Add(-m.config.RotationInterval), now)
+	case next == nil && !now.Before(current.activatesAt.Add(m.config.RotationInterval-m.publishLead())):
+		err = m.generate(ctx, now, current.activatesAt, current.activatesAt.Add(m.config.RotationInterval))
+	default:
+		m.set(keys, current)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Reload rather than use the generated key, another replica may have scheduled one first
+	keys, current, _, err = m.load(ctx, now)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return ErrUnknownKey
+	}
+	m.set(keys, current)
+	return nil
+}
+
+// publishLead is how long a key is published before it signs: one refresh for every replica to load it
+// plus one JWKS cache period for verifiers to fetch it
+func (m *KeyManager) publishLead() time.Duration {
+	return m.config.RefreshInterval + JWKSMaxAge
+}
+
+// load returns every active key, the key signing at now and the key scheduled to sign next
+func (m *KeyManager) load(ctx context.Context, now time.Time) (map[string]*signingKey, *signingKey, *signingKey, error) {
+	stored, err := m.store.FindActive(ctx, now)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	keys := make(map[string]*signingKey, len(stored))
+	var current, next *signingKey
+	for _, s := range stored {
+		key, err := m.parseSigningKey(s)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("parse signing key %s: %w", s.ID, err)
+		}
+		keys[key.id] = key
+		if key.algorithm != m.config.Algorithm {
+			continue
+		}
+		// Keys are ordered by activation, latest first
+		switch {
+		case now.Before(key.activatesAt):
+			next = key
+		case current == nil && now.Before(key.activatesAt.Add(m.config.RotationInterval)):
+			current = key
+		}
+	}
+	return keys, current, next, nil
+}
+
+func (m *KeyManager) set(keys map[string]*signingKey, current *signingKey) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keys = keys
+	m.current = current
+}
+
+// generate stores a new key activating at activatesAt unless a key activating after `after` is already scheduled
+func (m *KeyManager) generate(ctx context.Context, now, after, activatesAt time.Time) error {
+	var (
+		private crypto.Signer
+		err     error
+	)
+	switch m.config.Algorithm {
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, m.config.Algorithm)
+	}
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+
+	id := uuid.NewString()
+	sealed, err := m.seal(id, der)
+	if err != nil {
+		return err
+	}
+
+	created, err := m.store.CreateUnlessScheduled(ctx, &dto.SigningKey{
+		ID:          id,
+		Algorithm:   m.config.Algorithm,
+		PrivateKey:  sealed,
+		Encrypted:   true,
+		CreatedAt:   now,
+		ActivatesAt: activatesAt,
+		ExpiresAt:   activatesAt.Add(m.config.RotationInterval + m.config.Overlap),
+	}, after)
+	if err != nil {
+		return err
+	}
+	if created {
+		log.Printf("Generated signing key %s, it signs from %s", id, activatesAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// seal encrypts a private key with the key encryption key, the key ID is bound as additional data
+// so a sealed key cannot be moved to another row
+func (m *KeyManager) seal(id string, der []byte) ([]byte, error) {
+	nonce := make([]byte, m.kek.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return m.kek.Seal(nonce, nonce, der, []byte(id)), nil
+}
+
+func (m *KeyManager) open(key *dto.SigningKey) ([]byte, error) {
+	if !key.Encrypted {
+		return key.PrivateKey, nil
+	}
+	if len(key.PrivateKey) < m.kek.NonceSize() {
+		return nil, ErrSealedKeyTooShort
+	}
+	nonce, sealed := key.PrivateKey[:m.kek.NonceSize()], key.PrivateKey[m.kek.NonceSize():]
+	return m.kek.Open(nil, nonce, sealed, []byte(key.ID))
+}
+
+func (m *KeyManager) signingKey() (*signingKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.current == nil {
+		return nil, ErrUnknownKey
+	}
+	return m.current, nil
+}
+
+func (m *KeyManager) verificationKey(kid string) (*signingKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, ok := m.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+	}
+	return key, nil
+}
+
+// JWKS returns the public half of every active key, including the one scheduled to sign next
+func (m *KeyManager) JWKS() authz.JWKSet {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	set := authz.JWKSet{Keys: make([]authz.JWK, 0, len(m.keys))}
+	for _, key := range m.keys {
+		jwk := authz.JWK{
+			KeyID:     key.id,
+			Use:       "sig",
+			Algorithm: key.algorithm,
+		}
+		switch public := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.KeyType = "EC"
+			jwk.Curve = public.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, size)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, size)))
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func (m *KeyManager) parseSigningKey(key *dto.SigningKey) (*signingKey, error) {
+	der, err := m.open(key)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedAlgorithm, parsed)
+	}
+	return &signingKey{
+		id:          key.ID,
+		algorithm:   key.Algorithm,
+		private:     private,
+		activatesAt: key.ActivatesAt,
+	}, nil
+}
+
+func signingMethod(algorithm string) (jwt.SigningMethod, error) {
+	switch algorithm {
+	case AlgHS256:
+		return jwt.SigningMethodHS256, nil
+	case AlgRS256:
+		return jwt.SigningMethodRS256, nil
+	case AlgES256:
+		return jwt.SigningMethodES256, nil
+	case AlgEdDSA:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
+}
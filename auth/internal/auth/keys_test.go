@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+)
+
+// memoryKeyStore mirrors the signing key repository, its mutex stands in for the advisory lock
+type memoryKeyStore struct {
+	mu   sync.Mutex
+	keys []*dto.SigningKey
+}
+
+func (s *memoryKeyStore) CreateUnlessScheduled(ctx context.Context, key *dto.SigningKey, after time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range s.keys {
+		if k.Algorithm == key.Algorithm && k.ActivatesAt.After(after) && !k.ActivatesAt.After(key.ActivatesAt) {
+			return false, nil
+		}
+	}
+	s.keys = append(s.keys, key)
+	return true, nil
+}
+
+func (s *memoryKeyStore) FindActive(ctx context.Context, now time.Time) ([]*dto.SigningKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var active []*dto.SigningKey
+	for _, k := range s.keys {
+		if k.ExpiresAt.After(now) {
+			active = append(active, k)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].ActivatesAt.After(active[j].ActivatesAt) })
+	return active, nil
+}
+
+func (s *memoryKeyStore) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.keys)
+}
+
+func testKeyManagerConfig() KeyManagerConfig {
+	return KeyManagerConfig{
+		Algorithm:        AlgEdDSA,
+		RotationInterval: 24 * time.Hour,
+		Overlap:          time.Hour,
+		RefreshInterval:  time.Minute,
+		EncryptionKey:    []byte("0123456789abcdef0123456789abcdef"),
+	}
+}
+
+func newTestKeyManager(t *testing.T, store KeyStore) *KeyManager {
+	t.Helper()
+
+	m, err := NewKeyManager(store, testKeyManagerConfig())
+	if err != nil {
+		t.Fatalf("new key manager: %v", err)
+	}
+	return m
+}
+
+func TestNewKeyManagerRequiresEncryptionKey(t *testing.T) {
+	config := testKeyManagerConfig()
+	config.EncryptionKey = nil
+	if _, err := NewKeyManager(&memoryKeyStore{}, config); err != ErrInvalidEncryptionKey {
+		t.Errorf("error = %v, want %v", err, ErrInvalidEncryptionKey)
+	}
+}
+
+func TestKeyManagerBootstrapsEncryptedKey(t *testing.T) {
+	store := &memoryKeyStore{}
+	m := newTestKeyManager(t, store)
+
+	if err := m.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	if store.count() != 1 {
+		t.Fatalf("stored %d keys, want 1", store.count())
+	}
+	stored := store.keys[0]
+	if !stored.Encrypted {
+		t.Error("key is stored unencrypted")
+	}
+	if _, err := x509.ParsePKCS8PrivateKey(stored.PrivateKey); err == nil {
+		t.Error("stored private key parses as plaintext PKCS #8")
+	}
+	current, err := m.signingKey()
+	if err != nil || current.id != stored.ID {
+		t.Errorf("signing key = %v, %v, want %s", current, err, stored.ID)
+	}
+}
+
+func TestKeyManagerRejectsSealedKeyMovedToAnotherRow(t *testing.T) {
+	store := &memoryKeyStore{}
+	m := newTestKeyManager(t, store)
+	if err := m.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	store.keys[0].ID = "another-id"
+	if err := m.Refresh(context.Background()); err == nil {
+		t.Error("refresh accepted a sealed key under another ID")
+	}
+}
+
+func TestKeyManagerPublishesNextKeyBeforeSigningWithIt(t *testing.T) {
+	config := testKeyManagerConfig()
+	store := &memoryKeyStore{}
+	m := newTestKeyManager(t, store)
+	if err := m.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	current := store.keys[0]
+
+	// Not due yet, the current key signs for most of its rotation interval alone
+	if err := m.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if store.count() != 1 {
+		t.Fatalf("stored %d keys before rotation was due, want 1", store.count())
+	}
+
+	// Move the current key into the publish lead before its retirement
+	current.ActivatesAt = time.Now().Add(-config.RotationInterval + m.publishLead()/2)
+	if err := m.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if store.count() != 2 {
+		t.Fatalf("stored %d keys, want the next key scheduled", store.count())
+	}
+	next := store.keys[1]
+	if want := current.ActivatesAt.Add(config.RotationInterval); !next.ActivatesAt.Equal(want) {
+		t.Errorf("next key activates at %s, want %s", next.ActivatesAt, want)
+	}
+	if lead := time.Until(next.ActivatesAt); lead < m.config.RefreshInterval {
+		t.Errorf("next key is published only %s before it signs", lead)
+	}
+
+	signing, err := m.signingKey()
+	if err != nil || signing.id != current.ID {
+		t.Errorf("signing key = %v, %v, want the current key %s", signing, err, current.ID)
+	}
+	published := make(map[string]bool)
+	for _, jwk := range m.JWKS().Keys {
+		published[jwk.KeyID] = true
+	}
+	if !published[current.ID] || !published[next.ID] {
+		t.Errorf("JWKS = %v, want both the current and the next key", published)
+	}
+
+	// Once the current key retires the next one signs
+	current.ActivatesAt = current.ActivatesAt.Add(-m.publishLead())
+	next.ActivatesAt = time.Now().Add(-time.Second)
+	if err := m.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if signing, err := m.signingKey(); err != nil || signing.id != next.ID {
+		t.Errorf("signing key = %v, %v, want the next key %s", signing, err, next.ID)
+	}
+}
+
+func TestKeyManagerReplicasScheduleOneNextKey(t *testing.T) {
+	config := testKeyManagerConfig()
+	store := &memoryKeyStore{}
+	first := newTestKeyManager(t, store)
+	if err := first.Refresh(context.Background()); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	store.keys[0].ActivatesAt = time.Now().Add(-config.RotationInterval + first.publishLead()/2)
+
+	replicas := []*KeyManager{first, newTestKeyManager(t, store), newTestKeyManager(t, store)}
+	var wg sync.WaitGroup
+	for _, replica := range replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := replica.Refresh(context.Background()); err != nil {
+				t.Errorf("refresh: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if store.count() != 2 {
+		t.Errorf("stored %d keys, want exactly one next key", store.count())
+	}
+}
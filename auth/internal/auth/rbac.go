@@ -0,0 +1,7 @@
+package auth
+
+// Permissions the auth service itself checks, the admin role is seeded with them
+const (
+	PermissionReadRoles  = "roles:read"
+	PermissionWriteRoles = "roles:write"
+)
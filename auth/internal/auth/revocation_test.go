@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"github.com/yoshapihoff/bricks/auth/pkg/authz"
+)
+
+func claimsIssuedAt(userID uuid.UUID, jti string, issuedAt time.Time) *authz.Claims {
+	return &authz.Claims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       jti,
+			IssuedAt: jwt.NewNumericDate(issuedAt),
+		},
+	}
+}
+
+func TestMemoryRevocationStoreRevokeUserCutoff(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryRevocationStore()
+	userID := uuid.New()
+	cutoff := time.Now()
+
+	if err := store.RevokeUser(ctx, userID, cutoff); err != nil {
+		t.Fatalf("revoke user: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		claims *authz.Claims
+		want   bool
+	}{
+		{name: "issued before the cutoff", claims: claimsIssuedAt(userID, "a", cutoff.Add(-time.Minute)), want: true},
+		{name: "issued a second before the cutoff", claims: claimsIssuedAt(userID, "b", cutoff.Add(-time.Second)), want: true},
+		{name: "issued in the cutoff second", claims: claimsIssuedAt(userID, "e", cutoff), want: false},
+		{name: "issued after the cutoff", claims: claimsIssuedAt(userID, "c", cutoff.Add(2*time.Second)), want: false},
+		{name: "without issued at", claims: &authz.Claims{UserID: userID}, want: true},
+		{name: "another user", claims: claimsIssuedAt(uuid.New(), "d", cutoff.Add(-time.Minute)), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			revoked, err := store.IsRevoked(ctx, tt.claims)
+			if err != nil {
+				t.Fatalf("is revoked: %v", err)
+			}
+			if revoked != tt.want {
+				t.Errorf("revoked = %v, want %v", revoked, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryRevocationStoreRevokeTokenUntilExpiry(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryRevocationStore()
+	userID := uuid.New()
+	issuedAt := time.Now()
+
+	if err := store.RevokeToken(ctx, "revoked", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("revoke token: %v", err)
+	}
+	if err := store.RevokeToken(ctx, "expired", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("revoke token: %v", err)
+	}
+
+	if revoked, _ := store.IsRevoked(ctx, claimsIssuedAt(userID, "revoked", issuedAt)); !revoked {
+		t.Error("revoked token is accepted")
+	}
+	if revoked, _ := store.IsRevoked(ctx, claimsIssuedAt(userID, "other", issuedAt)); revoked {
+		t.Error("token of the same user with another jti is rejected")
+	}
+	if revoked, _ := store.IsRevoked(ctx, claimsIssuedAt(userID, "expired", issuedAt)); revoked {
+		t.Error("revocation outlived the token expiry")
+	}
+
+	store.mu.Lock()
+	_, kept := store.tokens["expired"]
+	store.mu.Unlock()
+	if kept {
+		t.Error("expired revocation was not pruned")
+	}
+}
+
+func TestTokenIssuedRightAfterRevokingUserTokensIsValid(t *testing.T) {
+	ctx := context.Background()
+	jwtSvc := NewJWTService(JWTConfig{Secret: "secret", Expiration: time.Minute}, NewMemoryRevocationStore(), nil)
+	userID := uuid.New()
+
+	if err := jwtSvc.RevokeUserTokens(ctx, userID); err != nil {
+		t.Fatalf("revoke user tokens: %v", err)
+	}
+	// The token is issued within the same second as the revocation
+	token, err := jwtSvc.GenerateToken(userID, "user@example.com", uuid.New(), authz.Grants{})
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	if _, err := jwtSvc.ValidateToken(ctx, token); err != nil {
+		t.Errorf("validate token issued after the revocation: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/yoshapihoff/bricks/auth/pkg/authz"
+)
+
+const (
+	revokedTokenKeyPrefix = "auth:revoked:token:"
+	revokedUserKeyPrefix  = "auth:revoked:user:"
+)
+
+// ValkeyRevocationStore keeps revocations in Valkey so they are shared by every replica
+type ValkeyRevocationStore struct {
+	client *redis.Client
+	// userTTL must cover the lifetime of an access token
+	userTTL time.Duration
+}
+
+func NewValkeyRevocationStore(client *redis.Client, userTTL time.Duration) *ValkeyRevocationStore {
+	return &ValkeyRevocationStore{
+		client:  client,
+		userTTL: userTTL,
+	}
+}
+
+func (s *ValkeyRevocationStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.client.Set(ctx, revokedTokenKeyPrefix+jti, 1, ttl).Err()
+}
+
+func (s *ValkeyRevocationStore) RevokeUser(ctx context.Context, userID uuid.UUID, issuedBefore time.Time) error {
+	return s.client.Set(ctx, revokedUserKeyPrefix+userID.String(), issuedBefore.Unix(), s.userTTL).Err()
+}
+
+func (s *ValkeyRevocationStore) IsRevoked(ctx context.Context, claims *authz.Claims) (bool, error) {
+	tokenRevoked, err := s.client.Exists(ctx, revokedTokenKeyPrefix+claims.ID).Result()
+	if err != nil {
+		return false, err
+	}
+	if tokenRevoked > 0 {
+		return true, nil
+	}
+
+	value, err := s.client.Get(ctx, revokedUserKeyPrefix+claims.UserID.String()).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return false, nil
+		}
+		return false, err
+	}
+	issuedBefore, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return false, err
+	}
+
+	return isIssuedBefore(claims, time.Unix(issuedBefore, 0)), nil
+}
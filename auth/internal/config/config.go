@@ -1,13 +1,31 @@
 package config
 
 import (
+	"encoding/base64"
+	"errors"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
+var (
+	ErrInvalidOutboxRelayInterval  = errors.New("OUTBOX_RELAY_INTERVAL must be positive")
+	ErrInvalidOutboxRelayBatchSize = errors.New("OUTBOX_RELAY_BATCH_SIZE must be positive")
+	ErrInvalidOutboxMaxAttempts    = errors.New("OUTBOX_MAX_ATTEMPTS must be positive")
+	ErrInvalidOutboxRetryBackoff   = errors.New("OUTBOX_RETRY_BACKOFF must be positive and not above OUTBOX_MAX_RETRY_BACKOFF")
+	ErrInvalidOutboxLease          = errors.New("OUTBOX_LEASE must be positive")
+	ErrInvalidJanitorInterval      = errors.New("JANITOR_INTERVAL must be positive")
+	ErrInvalidJWTAlgorithm         = errors.New("JWT_ALGORITHM must be one of HS256, RS256, ES256 or EdDSA")
+	ErrInvalidJWTKeyEncryptionKey  = errors.New("JWT_KEY_ENCRYPTION_KEY must be a base64 encoded 32 byte key unless JWT_ALGORITHM is HS256")
+)
+
+// defaultJWTAlgorithm signs with JWT_SECRET and needs no key encryption key
+const defaultJWTAlgorithm = "HS256"
+
 type DBConfig struct {
 	Host     string
 	Port     string
@@ -18,8 +36,15 @@ type DBConfig struct {
 }
 
 type JWTConfig struct {
-	Secret     string
-	Expiration time.Duration
+	Secret                 string
+	Expiration             time.Duration
+	RefreshTokenExpiration time.Duration
+	// Algorithm is one of HS256, RS256, ES256 or EdDSA, it defaults to HS256
+	Algorithm           string
+	KeyRotationInterval time.Duration
+	KeyOverlap          time.Duration
+	// KeyEncryptionKey seals the rotating private keys at rest, it is required unless Algorithm is HS256
+	KeyEncryptionKey []byte
 }
 
 type ServerConfig struct {
@@ -33,14 +58,105 @@ type KafkaConfig struct {
 	SchemaRegistryUrl string
 }
 
+type ValkeyConfig struct {
+	Addr     string
+	Password string
+	DB       int
+}
+
+type OAuthClientConfig struct {
+	ClientID     string
+	ClientSecret string
+}
+
+type OAuthConfig struct {
+	// RedirectBaseURL is the public URL of this service, callbacks are served under /auth/oauth/{provider}/callback
+	RedirectBaseURL string
+	StateTTL        time.Duration
+	Google          OAuthClientConfig
+	GitHub          OAuthClientConfig
+	// OIDC configures a generic provider found through OpenID Connect discovery
+	OIDC       OAuthClientConfig
+	OIDCName   string
+	OIDCIssuer string
+}
+
+type OutboxConfig struct {
+	RelayInterval  time.Duration
+	RelayBatchSize int
+	// MaxAttempts is the number of failed publishes after which a message is marked dead
+	MaxAttempts     int
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// Lease is how long a claimed batch is held back from other relays, it bounds the wait for the broker
+	Lease time.Duration
+}
+
+type RateLimitConfig struct {
+	Window                   time.Duration
+	LoginPerIP               int
+	LoginPerAccount          int
+	LoginMFAPerIP            int
+	LoginMFAPerChallenge     int
+	ForgotPasswordPerIP      int
+	ForgotPasswordPerAccount int
+	MagicLinkPerIP           int
+	MagicLinkPerAccount      int
+}
+
+type LockoutConfig struct {
+	// Threshold is the number of consecutive failed logins that lock the account, zero disables lockout
+	Threshold int
+	Duration  time.Duration
+	DelayBase time.Duration
+	DelayMax  time.Duration
+}
+
+type MFAConfig struct {
+	// Issuer names this service in authenticator apps
+	Issuer              string
+	ChallengeExpiration time.Duration
+}
+
+type WebAuthnConfig struct {
+	// RPID is the domain passkeys are bound to, it must be the origins' host or a parent of it
+	RPID   string
+	RPName string
+	// Origins lists the web origins passkey ceremonies may run on
+	Origins      []string
+	ChallengeTTL time.Duration
+}
+
+type JanitorConfig struct {
+	Interval time.Duration
+	// OutboxRetention is how long sent outbox messages are kept
+	OutboxRetention time.Duration
+}
+
 type Config struct {
-	DB                              DBConfig
-	JWT                             JWTConfig
-	Server                          ServerConfig
-	Kafka                           KafkaConfig
-	AppPort                         string
-	PasswordResetTokenExpiration    time.Duration
-	ForgotPasswordEmailSendingTopic string
+	DB                               DBConfig
+	JWT                              JWTConfig
+	Server                           ServerConfig
+	Kafka                            KafkaConfig
+	Valkey                           ValkeyConfig
+	OAuth                            OAuthConfig
+	Outbox                           OutboxConfig
+	Janitor                          JanitorConfig
+	RateLimit                        RateLimitConfig
+	Lockout                          LockoutConfig
+	MFA                              MFAConfig
+	WebAuthn                         WebAuthnConfig
+	AppPort                          string
+	PasswordResetTokenExpiration     time.Duration
+	EmailChangeTokenExpiration       time.Duration
+	EmailVerificationTokenExpiration time.Duration
+	MagicLinkTokenExpiration         time.Duration
+	// RequireVerifiedEmail blocks login until the user verifies their email
+	RequireVerifiedEmail               bool
+	ForgotPasswordEmailSendingTopic    string
+	EmailChangeEmailSendingTopic       string
+	EmailVerificationEmailSendingTopic string
+	MagicLinkEmailSendingTopic         string
 }
 
 func Load() (*Config, error) {
@@ -52,10 +168,176 @@ func Load() (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	jwtKeyRotationInterval, err := time.ParseDuration(getEnv("JWT_KEY_ROTATION_INTERVAL"))
+	if err != nil {
+		return nil, err
+	}
+	jwtKeyOverlap, err := time.ParseDuration(getEnv("JWT_KEY_OVERLAP"))
+	if err != nil {
+		return nil, err
+	}
+	jwtAlgorithm := getEnv("JWT_ALGORITHM")
+	if jwtAlgorithm == "" {
+		jwtAlgorithm = defaultJWTAlgorithm
+	}
+	jwtKeyEncryptionKey, err := base64.StdEncoding.DecodeString(getEnv("JWT_KEY_ENCRYPTION_KEY"))
+	if err != nil {
+		return nil, ErrInvalidJWTKeyEncryptionKey
+	}
+	// The rotating keys are sealed with AES-256, so anything but HS256 refuses to start without the key
+	switch jwtAlgorithm {
+	case defaultJWTAlgorithm:
+	case "RS256", "ES256", "EdDSA":
+		if len(jwtKeyEncryptionKey) != 32 {
+			return nil, ErrInvalidJWTKeyEncryptionKey
+		}
+	default:
+		return nil, ErrInvalidJWTAlgorithm
+	}
+	refreshTokenExpiration, err := time.ParseDuration(getEnv("REFRESH_TOKEN_EXPIRATION"))
+	if err != nil {
+		return nil, err
+	}
 	passwordResetTokenExpiration, err := time.ParseDuration(getEnv("PASSWORD_RESET_TOKEN_EXPIRATION"))
 	if err != nil {
 		return nil, err
 	}
+	emailChangeTokenExpiration, err := time.ParseDuration(getEnv("EMAIL_CHANGE_TOKEN_EXPIRATION"))
+	if err != nil {
+		return nil, err
+	}
+	emailVerificationTokenExpiration, err := time.ParseDuration(getEnv("EMAIL_VERIFICATION_TOKEN_EXPIRATION"))
+	if err != nil {
+		return nil, err
+	}
+	magicLinkTokenExpiration, err := time.ParseDuration(getEnv("MAGIC_LINK_TOKEN_EXPIRATION"))
+	if err != nil {
+		return nil, err
+	}
+	requireVerifiedEmail, err := strconv.ParseBool(getEnv("REQUIRE_VERIFIED_EMAIL"))
+	if err != nil {
+		return nil, err
+	}
+	janitorInterval, err := time.ParseDuration(getEnv("JANITOR_INTERVAL"))
+	if err != nil {
+		return nil, err
+	}
+	outboxRetention, err := time.ParseDuration(getEnv("OUTBOX_RETENTION"))
+	if err != nil {
+		return nil, err
+	}
+	rateLimitWindow, err := time.ParseDuration(getEnv("RATE_LIMIT_WINDOW"))
+	if err != nil {
+		return nil, err
+	}
+	loginRateLimitPerIP, err := strconv.Atoi(getEnv("LOGIN_RATE_LIMIT_PER_IP"))
+	if err != nil {
+		return nil, err
+	}
+	loginRateLimitPerAccount, err := strconv.Atoi(getEnv("LOGIN_RATE_LIMIT_PER_ACCOUNT"))
+	if err != nil {
+		return nil, err
+	}
+	loginMFARateLimitPerIP, err := strconv.Atoi(getEnv("LOGIN_MFA_RATE_LIMIT_PER_IP"))
+	if err != nil {
+		return nil, err
+	}
+	loginMFARateLimitPerChallenge, err := strconv.Atoi(getEnv("LOGIN_MFA_RATE_LIMIT_PER_CHALLENGE"))
+	if err != nil {
+		return nil, err
+	}
+	forgotPasswordRateLimitPerIP, err := strconv.Atoi(getEnv("FORGOT_PASSWORD_RATE_LIMIT_PER_IP"))
+	if err != nil {
+		return nil, err
+	}
+	forgotPasswordRateLimitPerAccount, err := strconv.Atoi(getEnv("FORGOT_PASSWORD_RATE_LIMIT_PER_ACCOUNT"))
+	if err != nil {
+		return nil, err
+	}
+	magicLinkRateLimitPerIP, err := strconv.Atoi(getEnv("MAGIC_LINK_RATE_LIMIT_PER_IP"))
+	if err != nil {
+		return nil, err
+	}
+	magicLinkRateLimitPerAccount, err := strconv.Atoi(getEnv("MAGIC_LINK_RATE_LIMIT_PER_ACCOUNT"))
+	if err != nil {
+		return nil, err
+	}
+	lockoutThreshold, err := strconv.Atoi(getEnv("LOGIN_LOCKOUT_THRESHOLD"))
+	if err != nil {
+		return nil, err
+	}
+	lockoutDuration, err := time.ParseDuration(getEnv("LOGIN_LOCKOUT_DURATION"))
+	if err != nil {
+		return nil, err
+	}
+	loginDelayBase, err := time.ParseDuration(getEnv("LOGIN_DELAY_BASE"))
+	if err != nil {
+		return nil, err
+	}
+	loginDelayMax, err := time.ParseDuration(getEnv("LOGIN_DELAY_MAX"))
+	if err != nil {
+		return nil, err
+	}
+	mfaChallengeExpiration, err := time.ParseDuration(getEnv("MFA_CHALLENGE_EXPIRATION"))
+	if err != nil {
+		return nil, err
+	}
+	webAuthnChallengeTTL, err := time.ParseDuration(getEnv("WEBAUTHN_CHALLENGE_TTL"))
+	if err != nil {
+		return nil, err
+	}
+	valkeyDB, err := strconv.Atoi(getEnv("VALKEY_DB"))
+	if err != nil {
+		return nil, err
+	}
+	oauthStateTTL, err := time.ParseDuration(getEnv("OAUTH_STATE_TTL"))
+	if err != nil {
+		return nil, err
+	}
+	outboxRelayInterval, err := time.ParseDuration(getEnv("OUTBOX_RELAY_INTERVAL"))
+	if err != nil {
+		return nil, err
+	}
+	outboxRelayBatchSize, err := strconv.Atoi(getEnv("OUTBOX_RELAY_BATCH_SIZE"))
+	if err != nil {
+		return nil, err
+	}
+	outboxMaxAttempts, err := strconv.Atoi(getEnv("OUTBOX_MAX_ATTEMPTS"))
+	if err != nil {
+		return nil, err
+	}
+	outboxRetryBackoff, err := time.ParseDuration(getEnv("OUTBOX_RETRY_BACKOFF"))
+	if err != nil {
+		return nil, err
+	}
+	outboxMaxRetryBackoff, err := time.ParseDuration(getEnv("OUTBOX_MAX_RETRY_BACKOFF"))
+	if err != nil {
+		return nil, err
+	}
+	outboxLease, err := time.ParseDuration(getEnv("OUTBOX_LEASE"))
+	if err != nil {
+		return nil, err
+	}
+	// The relay ticker panics on a non-positive interval and a zero batch never stops draining
+	if outboxRelayInterval <= 0 {
+		return nil, ErrInvalidOutboxRelayInterval
+	}
+	if outboxRelayBatchSize <= 0 {
+		return nil, ErrInvalidOutboxRelayBatchSize
+	}
+	if outboxMaxAttempts <= 0 {
+		return nil, ErrInvalidOutboxMaxAttempts
+	}
+	if outboxRetryBackoff <= 0 || outboxRetryBackoff > outboxMaxRetryBackoff {
+		return nil, ErrInvalidOutboxRetryBackoff
+	}
+	if outboxLease <= 0 {
+		return nil, ErrInvalidOutboxLease
+	}
+	// The janitor ticker panics on a non-positive interval just like the relay's
+	if janitorInterval <= 0 {
+		return nil, ErrInvalidJanitorInterval
+	}
 
 	return &Config{
 		DB: DBConfig{
@@ -67,8 +349,13 @@ func Load() (*Config, error) {
 			SSLMode:  getEnv("DB_SSLMODE"),
 		},
 		JWT: JWTConfig{
-			Secret:     getEnv("JWT_SECRET"),
-			Expiration: jwtExpiration,
+			Secret:                 getEnv("JWT_SECRET"),
+			Expiration:             jwtExpiration,
+			RefreshTokenExpiration: refreshTokenExpiration,
+			Algorithm:              jwtAlgorithm,
+			KeyRotationInterval:    jwtKeyRotationInterval,
+			KeyOverlap:             jwtKeyOverlap,
+			KeyEncryptionKey:       jwtKeyEncryptionKey,
 		},
 		Server: ServerConfig{
 			ReadTimeout:  10 * time.Second,
@@ -79,9 +366,78 @@ func Load() (*Config, error) {
 			KafkaUrl:          getEnv("KAFKA_URL"),
 			SchemaRegistryUrl: getEnv("SCHEMA_REGISTRY_URL"),
 		},
-		AppPort:                         getEnv("PORT"),
-		PasswordResetTokenExpiration:    passwordResetTokenExpiration,
-		ForgotPasswordEmailSendingTopic: getEnv("FORGOT_PASSWORD_EMAIL_SENDING_TOPIC"),
+		Valkey: ValkeyConfig{
+			Addr:     getEnv("VALKEY_ADDR"),
+			Password: getEnv("VALKEY_PASSWORD"),
+			DB:       valkeyDB,
+		},
+		OAuth: OAuthConfig{
+			RedirectBaseURL: getEnv("OAUTH_REDIRECT_BASE_URL"),
+			StateTTL:        oauthStateTTL,
+			Google: OAuthClientConfig{
+				ClientID:     getEnv("GOOGLE_CLIENT_ID"),
+				ClientSecret: getEnv("GOOGLE_CLIENT_SECRET"),
+			},
+			GitHub: OAuthClientConfig{
+				ClientID:     getEnv("GITHUB_CLIENT_ID"),
+				ClientSecret: getEnv("GITHUB_CLIENT_SECRET"),
+			},
+			OIDC: OAuthClientConfig{
+				ClientID:     getEnv("OIDC_CLIENT_ID"),
+				ClientSecret: getEnv("OIDC_CLIENT_SECRET"),
+			},
+			OIDCName:   getEnv("OIDC_PROVIDER_NAME"),
+			OIDCIssuer: getEnv("OIDC_ISSUER_URL"),
+		},
+		Outbox: OutboxConfig{
+			RelayInterval:   outboxRelayInterval,
+			RelayBatchSize:  outboxRelayBatchSize,
+			MaxAttempts:     outboxMaxAttempts,
+			RetryBackoff:    outboxRetryBackoff,
+			MaxRetryBackoff: outboxMaxRetryBackoff,
+			Lease:           outboxLease,
+		},
+		Janitor: JanitorConfig{
+			Interval:        janitorInterval,
+			OutboxRetention: outboxRetention,
+		},
+		RateLimit: RateLimitConfig{
+			Window:                   rateLimitWindow,
+			LoginPerIP:               loginRateLimitPerIP,
+			LoginPerAccount:          loginRateLimitPerAccount,
+			LoginMFAPerIP:            loginMFARateLimitPerIP,
+			LoginMFAPerChallenge:     loginMFARateLimitPerChallenge,
+			ForgotPasswordPerIP:      forgotPasswordRateLimitPerIP,
+			ForgotPasswordPerAccount: forgotPasswordRateLimitPerAccount,
+			MagicLinkPerIP:           magicLinkRateLimitPerIP,
+			MagicLinkPerAccount:      magicLinkRateLimitPerAccount,
+		},
+		Lockout: LockoutConfig{
+			Threshold: lockoutThreshold,
+			Duration:  lockoutDuration,
+			DelayBase: loginDelayBase,
+			DelayMax:  loginDelayMax,
+		},
+		MFA: MFAConfig{
+			Issuer:              getEnv("MFA_ISSUER"),
+			ChallengeExpiration: mfaChallengeExpiration,
+		},
+		WebAuthn: WebAuthnConfig{
+			RPID:         getEnv("WEBAUTHN_RP_ID"),
+			RPName:       getEnv("WEBAUTHN_RP_NAME"),
+			Origins:      strings.Split(getEnv("WEBAUTHN_ORIGINS"), ","),
+			ChallengeTTL: webAuthnChallengeTTL,
+		},
+		AppPort:                            getEnv("PORT"),
+		PasswordResetTokenExpiration:       passwordResetTokenExpiration,
+		EmailChangeTokenExpiration:         emailChangeTokenExpiration,
+		EmailVerificationTokenExpiration:   emailVerificationTokenExpiration,
+		MagicLinkTokenExpiration:           magicLinkTokenExpiration,
+		RequireVerifiedEmail:               requireVerifiedEmail,
+		ForgotPasswordEmailSendingTopic:    getEnv("FORGOT_PASSWORD_EMAIL_SENDING_TOPIC"),
+		EmailChangeEmailSendingTopic:       getEnv("EMAIL_CHANGE_EMAIL_SENDING_TOPIC"),
+		EmailVerificationEmailSendingTopic: getEnv("EMAIL_VERIFICATION_EMAIL_SENDING_TOPIC"),
+		MagicLinkEmailSendingTopic:         getEnv("MAGIC_LINK_EMAIL_SENDING_TOPIC"),
 	}, nil
 }
 
@@ -3,26 +3,45 @@ package db
 import (
 	"context"
 	"database/sql"
+	"log"
 
 	"github.com/yoshapihoff/bricks/auth/internal/config"
-	postgresRepo "github.com/yoshapihoff/bricks/auth/internal/repository"
 )
 
-// Init initializes the database connection and returns a *sql.DB instance
+// Init initializes the database connection, applies pending migrations and returns a *sql.DB instance
 func Init(cfg config.DBConfig) (*sql.DB, error) {
-	db, err := sql.Open("pgx", cfg.GetDSN())
+	db, err := Open(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	// Test the database connection
-	if err := db.Ping(); err != nil {
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	applied, err := migrator.Up(context.Background())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if applied > 0 {
+		log.Printf("Applied %d database migrations", applied)
+	}
+
+	return db, nil
+}
+
+// Open connects to the database without touching the schema
+func Open(cfg config.DBConfig) (*sql.DB, error) {
+	db, err := sql.Open("pgx", cfg.GetDSN())
+	if err != nil {
 		return nil, err
 	}
 
-	// Create tables if they don't exist
-	userRepo := postgresRepo.NewUserRepository(db)
-	if err := userRepo.CreateTables(context.Background()); err != nil {
+	// Test the database connection
+	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, err
 	}
 
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS signing_keys (
+	id VARCHAR(64) PRIMARY KEY,
+	algorithm VARCHAR(16) NOT NULL,
+	private_key BYTEA NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS outbox (
+	id UUID PRIMARY KEY,
+	topic VARCHAR(255) NOT NULL,
+	message_type VARCHAR(255) NOT NULL,
+	payload BYTEA NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	created_at TIMESTAMP NOT NULL,
+	sent_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox(created_at) WHERE sent_at IS NULL;
@@ -0,0 +1,11 @@
+-- Older versions stored plaintext tokens, they are short lived so they are dropped rather than converted
+DROP TABLE IF EXISTS password_reset_tokens;
+
+CREATE TABLE password_reset_tokens (
+	id UUID PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	token_hash VARCHAR(64) UNIQUE NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_password_reset_tokens_created_at ON password_reset_tokens(created_at);
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS sessions (
+	id UUID PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	device VARCHAR(255) NOT NULL,
+	user_agent VARCHAR(512) NOT NULL,
+	ip VARCHAR(45) NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	last_seen_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+
+-- A session is a refresh token family, families started before sessions existed keep working
+INSERT INTO sessions (id, user_id, device, user_agent, ip, created_at, last_seen_at)
+SELECT family_id, user_id, 'Unknown device', '', '', MIN(created_at), MAX(created_at)
+FROM refresh_tokens
+WHERE revoked_at IS NULL
+GROUP BY family_id, user_id
+ON CONFLICT (id) DO NOTHING;
@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS roles (
+	name VARCHAR(64) PRIMARY KEY,
+	description VARCHAR(255) NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+	name VARCHAR(64) PRIMARY KEY,
+	description VARCHAR(255) NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+	role_name VARCHAR(64) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+	permission_name VARCHAR(64) NOT NULL REFERENCES permissions(name) ON DELETE CASCADE,
+	PRIMARY KEY (role_name, permission_name)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	role_name VARCHAR(64) NOT NULL REFERENCES roles(name) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (user_id, role_name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_roles_role_name ON user_roles(role_name);
+
+-- The admin role manages roles, the first admin is granted by hand:
+-- INSERT INTO user_roles (user_id, role_name, created_at) VALUES ('<user id>', 'admin', NOW());
+INSERT INTO permissions (name, description, created_at) VALUES
+	('roles:read', 'List roles, permissions and role assignments', NOW()),
+	('roles:write', 'Manage roles and assign them to users', NOW())
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO roles (name, description, created_at) VALUES
+	('admin', 'Manages roles and permissions', NOW())
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role_name, permission_name) VALUES
+	('admin', 'roles:read'),
+	('admin', 'roles:write')
+ON CONFLICT DO NOTHING;
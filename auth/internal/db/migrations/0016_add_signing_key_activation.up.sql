@@ -0,0 +1,7 @@
+-- A key is published from created_at but only signs from activates_at, so verifiers can fetch it first
+ALTER TABLE signing_keys ADD COLUMN IF NOT EXISTS activates_at TIMESTAMP;
+UPDATE signing_keys SET activates_at = created_at WHERE activates_at IS NULL;
+ALTER TABLE signing_keys ALTER COLUMN activates_at SET NOT NULL;
+
+-- Encrypted keys are sealed with the key encryption key, rows written before it was introduced are not
+ALTER TABLE signing_keys ADD COLUMN IF NOT EXISTS encrypted BOOLEAN NOT NULL DEFAULT FALSE;
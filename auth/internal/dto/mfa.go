@@ -0,0 +1,27 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserTOTP is the TOTP enrollment of a user, it only counts as a second factor once confirmed
+type UserTOTP struct {
+	UserID uuid.UUID `json:"user_id"`
+	Secret string    `json:"-"`
+	// LastUsedStep is the time step of the last accepted code, codes from it or earlier are rejected
+	LastUsedStep int64      `json:"-"`
+	ConfirmedAt  *time.Time `json:"confirmed_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// MFAChallenge is the pending second step of a login that passed the password check
+type MFAChallenge struct {
+	ID        uuid.UUID `json:"id"`
+	UserID    uuid.UUID `json:"user_id"`
+	TokenHash string    `json:"-"`
+	Attempts  int       `json:"attempts"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,22 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type OutboxMessage struct {
+	ID          uuid.UUID  `json:"id"`
+	Topic       string     `json:"topic"`
+	MessageType string     `json:"message_type"`
+	Payload     []byte     `json:"payload"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error"`
+	CreatedAt   time.Time  `json:"created_at"`
+	SentAt      *time.Time `json:"sent_at"`
+	// NextAttemptAt holds a failed message back until its backoff elapsed
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	// DeadAt is set once the relay gave up on the message
+	DeadAt *time.Time `json:"dead_at"`
+}
@@ -7,7 +7,10 @@ import (
 )
 
 type PasswordResetToken struct {
-	Token     uuid.UUID `json:"token"`
-	UserID    uuid.UUID `json:"user_id"`
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+	// Token is the plaintext token, it is only set when the token is created so it can be emailed
+	Token     string    `json:"-"`
+	TokenHash string    `json:"-"`
 	CreatedAt time.Time `json:"created_at"`
 }
@@ -0,0 +1,17 @@
+package dto
+
+import (
+	"time"
+)
+
+type SigningKey struct {
+	ID        string `json:"id"`
+	Algorithm string `json:"algorithm"`
+	// PrivateKey is the PKCS #8 DER encoded private key, sealed with the key encryption key when Encrypted is set
+	PrivateKey []byte    `json:"-"`
+	Encrypted  bool      `json:"encrypted"`
+	CreatedAt  time.Time `json:"created_at"`
+	// ActivatesAt is when the key starts signing, it is published in the JWKS from CreatedAt
+	ActivatesAt time.Time `json:"activates_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
@@ -6,10 +6,20 @@ import (
 	"github.com/google/uuid"
 )
 
+// Profile holds the user editable fields of a user
+type Profile struct {
+	Name      string `json:"name"`
+	Locale    string `json:"locale"`
+	Timezone  string `json:"timezone"`
+	AvatarURL string `json:"avatar_url"`
+}
+
 type User struct {
 	ID           uuid.UUID `json:"id"`
 	Email        string    `json:"email"`
 	PasswordHash string    `json:"-"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	Profile
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
 }
@@ -2,17 +2,25 @@ package http
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"github.com/yoshapihoff/bricks/auth/internal/auth"
 	"github.com/yoshapihoff/bricks/auth/internal/dto"
-	"github.com/yoshapihoff/bricks/auth/internal/kafka/producers"
+	"github.com/yoshapihoff/bricks/auth/internal/oauth"
 	"github.com/yoshapihoff/bricks/auth/internal/service"
+	"github.com/yoshapihoff/bricks/auth/internal/webauthn"
+	"github.com/yoshapihoff/bricks/auth/pkg/authz"
 )
 
 type ErrorResponse struct {
@@ -33,18 +41,94 @@ type ForgotPasswordRequest struct {
 	Email string `json:"email" validate:"required,email"`
 }
 
+type MagicLinkRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ConsumeMagicLinkRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,min=8"`
+}
+
 type LoginRequest struct {
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required"`
 }
 
 type LoginResponse struct {
-	Token string    `json:"token"`
-	User  *dto.User `json:"user"`
+	Token        string    `json:"token,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	User         *dto.User `json:"user"`
+}
+
+// MFAChallengeResponse is returned by a login that still has to pass the second factor
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type LoginMFARequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+type ConfirmTOTPRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// DisableMFARequest omits the password for users who only sign in through a provider
+type DisableMFARequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code" validate:"required"`
 }
 
+type TOTPEnrollmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type FinishPasskeyRegistrationRequest struct {
+	Name       string                         `json:"name"`
+	Credential *webauthn.RegistrationResponse `json:"credential" validate:"required"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// UpdateProfileRequest only changes the fields present in the body
 type UpdateProfileRequest struct {
-	Name string `json:"name" validate:"required"`
+	Name      *string `json:"name"`
+	Locale    *string `json:"locale"`
+	Timezone  *string `json:"timezone"`
+	AvatarURL *string `json:"avatar_url"`
+}
+
+type ResendVerificationEmailRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// ChangeEmailRequest carries a code instead of the password for users who only sign in through a provider
+type ChangeEmailRequest struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"token" validate:"required"`
 }
 
 type ChangePasswordRequest struct {
@@ -52,53 +136,167 @@ type ChangePasswordRequest struct {
 	NewPassword string `json:"new_password" validate:"required,min=8"`
 }
 
+type CreateRoleRequest struct {
+	Name        string   `json:"name" validate:"required"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type SetRolePermissionsRequest struct {
+	Permissions []string `json:"permissions"`
+}
+
+type CreatePermissionRequest struct {
+	Name        string `json:"name" validate:"required"`
+	Description string `json:"description"`
+}
+
+type UserRolesResponse struct {
+	Roles []string `json:"roles"`
+}
+
 type OAuthStartResponse struct {
 	URL string `json:"url"`
 }
 
-type OAuthCallbackResponse struct {
-	Token string    `json:"token"`
-	User  *dto.User `json:"user"`
-}
+const (
+	oauthStateCookie = "oauth_state"
+	// backgroundTimeout bounds the work handlers do after answering
+	backgroundTimeout = 30 * time.Second
+)
 
 type AuthHandler struct {
 	userService                  service.UserService
 	jwtSvc                       *auth.DefaultJWTService
 	passwordResetTokenSvc        service.PasswordResetTokenService
+	refreshTokenSvc              service.RefreshTokenService
+	sessionSvc                   service.SessionService
+	oauthSvc                     service.OAuthService
+	oauthManager                 *oauth.Manager
+	emailChangeSvc               service.EmailChangeService
+	emailVerificationSvc         service.EmailVerificationService
+	mfaSvc                       service.MFAService
+	passkeySvc                   service.PasskeyService
+	magicLinkSvc                 service.MagicLinkService
+	roleSvc                      service.RoleService
+	attemptLimiter               service.AttemptLimiter
 	passwordResetTokenExpiration time.Duration
-	forgotPasswordEmailProducer  *producers.ForgotPasswordEmailProducer
+	// background tracks the work handlers hand off after answering, Wait blocks on it
+	background sync.WaitGroup
 }
 
 func NewAuthHandler(
 	userService service.UserService,
 	jwtSvc *auth.DefaultJWTService,
 	passwordResetTokenSvc service.PasswordResetTokenService,
+	refreshTokenSvc service.RefreshTokenService,
+	sessionSvc service.SessionService,
+	oauthSvc service.OAuthService,
+	oauthManager *oauth.Manager,
+	emailChangeSvc service.EmailChangeService,
+	emailVerificationSvc service.EmailVerificationService,
+	mfaSvc service.MFAService,
+	passkeySvc service.PasskeyService,
+	magicLinkSvc service.MagicLinkService,
+	roleSvc service.RoleService,
+	attemptLimiter service.AttemptLimiter,
 	passwordResetTokenExpiration time.Duration,
-	forgotPasswordEmailProducer *producers.ForgotPasswordEmailProducer,
 ) *AuthHandler {
 	return &AuthHandler{
 		userService:                  userService,
 		jwtSvc:                       jwtSvc,
 		passwordResetTokenSvc:        passwordResetTokenSvc,
+		refreshTokenSvc:              refreshTokenSvc,
+		sessionSvc:                   sessionSvc,
+		oauthSvc:                     oauthSvc,
+		oauthManager:                 oauthManager,
+		emailChangeSvc:               emailChangeSvc,
+		emailVerificationSvc:         emailVerificationSvc,
+		mfaSvc:                       mfaSvc,
+		passkeySvc:                   passkeySvc,
+		magicLinkSvc:                 magicLinkSvc,
+		roleSvc:                      roleSvc,
+		attemptLimiter:               attemptLimiter,
 		passwordResetTokenExpiration: passwordResetTokenExpiration,
-		forgotPasswordEmailProducer:  forgotPasswordEmailProducer,
 	}
 }
 
+// runInBackground runs fn after the request without being cancelled with it, failures are only logged
+func (h *AuthHandler) runInBackground(ctx context.Context, name string, fn func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), backgroundTimeout)
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		defer cancel()
+		if err := fn(ctx); err != nil {
+			log.Printf("Failed to create %s: %v", name, err)
+		}
+	}()
+}
+
+// Wait blocks until the work handed off by handlers is done, call it once the server stopped taking requests
+func (h *AuthHandler) Wait() {
+	h.background.Wait()
+}
+
 func (h *AuthHandler) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/.well-known/jwks.json", h.jwtSvc.JWKSHandler()).Methods("GET")
+
 	authRouter := router.PathPrefix("/auth").Subrouter()
 
 	// Public routes
 	authRouter.HandleFunc("/register", h.handleRegister).Methods("POST")
 	authRouter.HandleFunc("/login", h.handleLogin).Methods("POST")
+	authRouter.HandleFunc("/login/mfa", h.handleLoginMFA).Methods("POST")
+	authRouter.HandleFunc("/magic-link", h.handleMagicLink).Methods("POST")
+	authRouter.HandleFunc("/magic-link/consume", h.handleConsumeMagicLink).Methods("POST")
+	authRouter.HandleFunc("/passkey/login/begin", h.handleBeginPasskeyLogin).Methods("POST")
+	authRouter.HandleFunc("/passkey/login/finish", h.handleFinishPasskeyLogin).Methods("POST")
+	authRouter.HandleFunc("/refresh", h.handleRefresh).Methods("POST")
 	authRouter.HandleFunc("/forgot-password", h.handleForgotPassword).Methods("POST")
-	authRouter.HandleFunc("/receive-password-reset-token/{token}", h.handleReceivePasswordResetToken).Methods("GET")
+	authRouter.HandleFunc("/reset-password", h.handleResetPassword).Methods("POST")
+	authRouter.HandleFunc("/verify-email/{token}", h.handleVerifyEmail).Methods("GET")
+	authRouter.HandleFunc("/resend-verification-email", h.handleResendVerificationEmail).Methods("POST")
+	authRouter.HandleFunc("/confirm-email-change", h.handleConfirmEmailChange).Methods("POST")
+	authRouter.HandleFunc("/oauth/{provider}/start", h.handleOAuthStart).Methods("GET")
+	authRouter.HandleFunc("/oauth/{provider}/callback", h.handleOAuthCallback).Methods("GET")
 
 	// Protected routes
+	sessionRouter := authRouter.NewRoute().Subrouter()
+	sessionRouter.Use(h.authMiddleware)
+	sessionRouter.HandleFunc("/logout", h.handleLogout).Methods("POST")
+	sessionRouter.HandleFunc("/logout-all", h.handleLogoutAll).Methods("POST")
+
 	protected := authRouter.PathPrefix("/me").Subrouter()
 	protected.Use(h.authMiddleware)
 	protected.HandleFunc("", h.handleGetProfile).Methods("GET")
+	protected.HandleFunc("", h.handleUpdateProfile).Methods("PATCH")
 	protected.HandleFunc("/password", h.handleChangePassword).Methods("PUT")
+	protected.HandleFunc("/email", h.handleChangeEmail).Methods("POST")
+	protected.HandleFunc("/mfa/totp", h.handleEnrollTOTP).Methods("POST")
+	protected.HandleFunc("/mfa/totp/confirm", h.handleConfirmTOTP).Methods("POST")
+	protected.HandleFunc("/mfa", h.handleDisableMFA).Methods("DELETE")
+	protected.HandleFunc("/passkeys", h.handleListPasskeys).Methods("GET")
+	protected.HandleFunc("/passkeys/register/begin", h.handleBeginPasskeyRegistration).Methods("POST")
+	protected.HandleFunc("/passkeys/register/finish", h.handleFinishPasskeyRegistration).Methods("POST")
+	protected.HandleFunc("/passkeys/{id}", h.handleDeletePasskey).Methods("DELETE")
+	protected.HandleFunc("/sessions", h.handleListSessions).Methods("GET")
+	protected.HandleFunc("/sessions/{id}", h.handleRevokeSession).Methods("DELETE")
+
+	// Role management, the permissions come with the admin role
+	readRoles := authz.RequirePermission(auth.PermissionReadRoles)
+	writeRoles := authz.RequirePermission(auth.PermissionWriteRoles)
+	admin := authRouter.NewRoute().Subrouter()
+	admin.Use(h.authMiddleware)
+	admin.Handle("/roles", readRoles(http.HandlerFunc(h.handleListRoles))).Methods("GET")
+	admin.Handle("/roles", writeRoles(http.HandlerFunc(h.handleCreateRole))).Methods("POST")
+	admin.Handle("/roles/{name}/permissions", writeRoles(http.HandlerFunc(h.handleSetRolePermissions))).Methods("PUT")
+	admin.Handle("/roles/{name}", writeRoles(http.HandlerFunc(h.handleDeleteRole))).Methods("DELETE")
+	admin.Handle("/permissions", readRoles(http.HandlerFunc(h.handleListPermissions))).Methods("GET")
+	admin.Handle("/permissions", writeRoles(http.HandlerFunc(h.handleCreatePermission))).Methods("POST")
+	admin.Handle("/users/{id}/roles", readRoles(http.HandlerFunc(h.handleGetUserRoles))).Methods("GET")
+	admin.Handle("/users/{id}/roles/{role}", writeRoles(http.HandlerFunc(h.handleAssignRole))).Methods("PUT")
+	admin.Handle("/users/{id}/roles/{role}", writeRoles(http.HandlerFunc(h.handleUnassignRole))).Methods("DELETE")
 }
 
 func (h *AuthHandler) handleRegister(w http.ResponseWriter, r *http.Request) {
@@ -108,24 +306,50 @@ func (h *AuthHandler) handleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Register the user
+	// Both outcomes answer the same, the mailbox owner learns which one it was
 	user, err := h.userService.Register(r.Context(), req.Email, req.Password, req.Name)
-	if err != nil {
+	switch {
+	case errors.Is(err, service.ErrEmailExists):
+		if err := h.emailVerificationSvc.NotifyAccountExists(r.Context(), req.Email); err != nil {
+			log.Printf("Failed to send account exists email: %v", err)
+		}
+	case err != nil:
 		handleError(w, err)
 		return
+	default:
+		// The user can ask for a new verification email if this fails
+		if err := h.emailVerificationSvc.Send(r.Context(), user); err != nil {
+			log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
+		}
 	}
 
-	// Generate JWT token
-	token, err := h.jwtSvc.GenerateToken(user.ID, user.Email)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *AuthHandler) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	user, err := h.emailVerificationSvc.Verify(r.Context(), mux.Vars(r)["token"])
 	if err != nil {
 		handleError(w, err)
 		return
 	}
 
-	h.respondWithJSON(w, http.StatusCreated, &LoginResponse{
-		Token: token,
-		User:  user,
-	})
+	h.respondWithJSON(w, http.StatusOK, user)
+}
+
+func (h *AuthHandler) handleResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	var req ResendVerificationEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// The response does not reveal whether the address is registered
+	if err := h.emailVerificationSvc.Resend(r.Context(), req.Email); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
 }
 
 func (h *AuthHandler) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
@@ -135,168 +359,955 @@ func (h *AuthHandler) handleForgotPassword(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Generate password reset token
-	token, err := h.passwordResetTokenSvc.Create(r.Context(), req.Email)
+	if err := h.attemptLimiter.Check(r.Context(), service.ActionForgotPassword, clientIP(r), req.Email); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	// Generate password reset token after answering, the email is sent by the outbox relay.
+	// Unknown addresses skip the token, doing it in the background keeps that out of the response time.
+	h.runInBackground(r.Context(), "password reset token", func(ctx context.Context) error {
+		if _, err := h.passwordResetTokenSvc.Create(ctx, req.Email); err != nil && !errors.Is(err, service.ErrUserNotFound) {
+			return err
+		}
+		return nil
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *AuthHandler) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := h.passwordResetTokenSvc.ResetPassword(r.Context(), req.Token, req.NewPassword, h.passwordResetTokenExpiration)
 	if err != nil {
 		handleError(w, err)
 		return
 	}
 
-	// Send reset password email
-	if _, err := h.forgotPasswordEmailProducer.ProduceForgotPasswordEmail(req.Email, token.Token.String()); err != nil {
+	// Whoever knew the old password may hold a session, so every session is ended
+	if err := h.jwtSvc.RevokeUserTokens(r.Context(), userID); err != nil {
+		handleError(w, err)
+		return
+	}
+	if err := h.sessionSvc.RevokeAll(r.Context(), userID); err != nil {
 		handleError(w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func (h *AuthHandler) handleReceivePasswordResetToken(w http.ResponseWriter, r *http.Request) {
-	forgotPasswordToken := mux.Vars(r)["token"]
-	tokenUUID, err := uuid.Parse(forgotPasswordToken)
-	if err != nil {
-		http.Error(w, "invalid token", http.StatusBadRequest)
+func (h *AuthHandler) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	userId, err := h.passwordResetTokenSvc.ReceiveUserIdByToken(r.Context(), tokenUUID, h.passwordResetTokenExpiration)
-	if err != nil {
-		http.Error(w, "invalid token", http.StatusBadRequest)
+	if err := h.attemptLimiter.Check(r.Context(), service.ActionLogin, clientIP(r), req.Email); err != nil {
+		handleError(w, err)
 		return
 	}
 
-	token, err := h.userService.LoginByID(r.Context(), userId)
+	user, err := h.userService.Authenticate(r.Context(), req.Email, req.Password)
 	if err != nil {
 		handleError(w, err)
 		return
 	}
 
-	user, err := h.userService.ValidateToken(r.Context(), token)
+	if h.challengeMFA(w, r, user) {
+		return
+	}
+
+	sessionID := uuid.New()
+	token, err := h.userService.IssueToken(r.Context(), user, sessionID)
 	if err != nil {
 		handleError(w, err)
 		return
 	}
 
-	h.respondWithJSON(w, http.StatusOK, &LoginResponse{
-		Token: token,
-		User:  user,
-	})
+	h.respondWithTokens(w, r, http.StatusOK, sessionID, token, user)
 }
 
-func (h *AuthHandler) handleLogin(w http.ResponseWriter, r *http.Request) {
-	var req LoginRequest
+func (h *AuthHandler) handleLoginMFA(w http.ResponseWriter, r *http.Request) {
+	var req LoginMFARequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	token, err := h.userService.Login(r.Context(), req.Email, req.Password)
+	// The challenge is limited by its hash, the token itself stays out of the limiter's keys
+	if err := h.attemptLimiter.Check(r.Context(), service.ActionLoginMFA, clientIP(r), auth.HashOpaqueToken(req.MFAToken)); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	user, err := h.mfaSvc.VerifyChallenge(r.Context(), req.MFAToken, req.Code)
 	if err != nil {
 		handleError(w, err)
 		return
 	}
 
-	user, err := h.userService.ValidateToken(r.Context(), token)
+	sessionID := uuid.New()
+	token, err := h.userService.IssueToken(r.Context(), user, sessionID)
 	if err != nil {
 		handleError(w, err)
 		return
 	}
 
-	h.respondWithJSON(w, http.StatusOK, &LoginResponse{
-		Token: token,
-		User:  user,
+	h.respondWithTokens(w, r, http.StatusOK, sessionID, token, user)
+}
+
+func (h *AuthHandler) handleMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req MagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.attemptLimiter.Check(r.Context(), service.ActionMagicLink, clientIP(r), req.Email); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	// The link is created after answering, so neither the response nor its timing reveals whether the
+	// address is registered. The email is sent by the outbox relay.
+	h.runInBackground(r.Context(), "magic link", func(ctx context.Context) error {
+		return h.magicLinkSvc.Send(ctx, req.Email)
 	})
+
+	w.WriteHeader(http.StatusAccepted)
 }
 
-func (h *AuthHandler) handleGetProfile(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value("userID").(uuid.UUID)
-	if !ok {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+func (h *AuthHandler) handleConsumeMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req ConsumeMagicLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	user, err := h.userService.GetProfile(r.Context(), userID)
+	user, err := h.magicLinkSvc.Consume(r.Context(), req.Token)
 	if err != nil {
 		handleError(w, err)
 		return
 	}
 
-	h.respondWithJSON(w, http.StatusOK, user)
+	// The link only proves the mailbox, so a second factor is still asked for
+	if h.challengeMFA(w, r, user) {
+		return
+	}
+
+	sessionID := uuid.New()
+	token, err := h.userService.IssueToken(r.Context(), user, sessionID)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	h.respondWithTokens(w, r, http.StatusOK, sessionID, token, user)
 }
 
-func (h *AuthHandler) handleChangePassword(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value("userID").(uuid.UUID)
-	if !ok {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+func (h *AuthHandler) handleBeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	options, err := h.passkeySvc.BeginLogin(r.Context())
+	if err != nil {
+		handleError(w, err)
 		return
 	}
 
-	var req ChangePasswordRequest
+	h.respondWithJSON(w, http.StatusOK, options)
+}
+
+func (h *AuthHandler) handleFinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	var req webauthn.LoginResponse
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	if err := h.userService.UpdatePassword(r.Context(), userID, req.OldPassword, req.NewPassword); err != nil {
+	user, err := h.passkeySvc.FinishLogin(r.Context(), &req)
+	if err != nil {
 		handleError(w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent)
+	// A user verified passkey is already two factors, so there is no MFA challenge
+	sessionID := uuid.New()
+	token, err := h.userService.IssueToken(r.Context(), user, sessionID)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	h.respondWithTokens(w, r, http.StatusOK, sessionID, token, user)
 }
 
-func (h *AuthHandler) authMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "authorization header is required", http.StatusUnauthorized)
-			return
+func (h *AuthHandler) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID, sessionID, refreshToken, err := h.refreshTokenSvc.Rotate(r.Context(), req.RefreshToken)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	if err := h.sessionSvc.Touch(r.Context(), userID, sessionID); err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) || errors.Is(err, service.ErrSessionRevoked) {
+			err = service.ErrInvalidRefreshToken
 		}
+		handleError(w, err)
+		return
+	}
+
+	token, err := h.userService.LoginByID(r.Context(), userID, sessionID)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	user, err := h.userService.GetProfile(r.Context(), userID)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, &LoginResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		User:         user,
+	})
+}
+
+func (h *AuthHandler) handleLogout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := authz.ClaimsFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
 
-		tokenString := authHeader[len("Bearer "):]
-		if tokenString == "" {
-			http.Error(w, "invalid authorization header format", http.StatusUnauthorized)
+	// The refresh token is optional, the session already takes its own refresh tokens with it
+	var req LogoutRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
 			return
 		}
+	}
 
-		user, err := h.userService.ValidateToken(r.Context(), tokenString)
-		if err != nil {
-			http.Error(w, "invalid token", http.StatusUnauthorized)
+	if err := h.jwtSvc.RevokeToken(r.Context(), claims); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	if err := h.sessionSvc.Revoke(r.Context(), claims.UserID, claims.SessionID); err != nil && !errors.Is(err, service.ErrSessionNotFound) {
+		handleError(w, err)
+		return
+	}
+
+	if req.RefreshToken != "" {
+		if err := h.refreshTokenSvc.Revoke(r.Context(), claims.UserID, req.RefreshToken); err != nil {
+			handleError(w, err)
 			return
 		}
+	}
 
-		// Add user to context
-		ctx := context.WithValue(r.Context(), "userID", user.ID)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func (h *AuthHandler) respondWithJSON(w http.ResponseWriter, status int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
+func (h *AuthHandler) handleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
 
-	if data != nil {
-		err := json.NewEncoder(w).Encode(data)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	if err := h.jwtSvc.RevokeUserTokens(r.Context(), userID); err != nil {
+		handleError(w, err)
+		return
 	}
+
+	if err := h.sessionSvc.RevokeAll(r.Context(), userID); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func handleError(w http.ResponseWriter, err error) {
-	status := http.StatusInternalServerError
+func (h *AuthHandler) handleOAuthStart(w http.ResponseWriter, r *http.Request) {
+	provider := mux.Vars(r)["provider"]
 
-	switch {
-	case errors.Is(err, service.ErrUserNotFound):
-		status = http.StatusNotFound
-	case errors.Is(err, service.ErrEmailExists):
-		status = http.StatusConflict
-	case errors.Is(err, service.ErrInvalidPassword):
-		status = http.StatusUnauthorized
-	case errors.Is(err, service.ErrInvalidEmail):
-		status = http.StatusUnauthorized
-	case errors.Is(err, service.ErrWeakPassword):
-		status = http.StatusBadRequest
+	url, state, err := h.oauthManager.Start(r.Context(), provider)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	// Binds the callback to the browser that started the flow
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     "/auth/oauth/" + provider,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	h.respondWithJSON(w, http.StatusOK, &OAuthStartResponse{URL: url})
+}
+
+func (h *AuthHandler) handleOAuthCallback(w http.ResponseWriter, r *http.Request) {
+	provider := mux.Vars(r)["provider"]
+
+	if errParam := r.URL.Query().Get("error"); errParam != "" {
+		http.Error(w, errParam, http.StatusBadRequest)
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		handleError(w, oauth.ErrInvalidState)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   oauthStateCookie,
+		Path:   "/auth/oauth/" + provider,
+		MaxAge: -1,
+	})
+
+	identity, err := h.oauthManager.Finish(r.Context(), provider, state, r.URL.Query().Get("code"))
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	user, err := h.oauthSvc.LoginWithIdentity(r.Context(), provider, identity)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	if h.challengeMFA(w, r, user) {
+		return
+	}
+
+	// The provider verified the email, so the verification requirement is already met
+	sessionID := uuid.New()
+	token, err := h.userService.IssueToken(r.Context(), user, sessionID)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	h.respondWithTokens(w, r, http.StatusOK, sessionID, token, user)
+}
+
+func (h *AuthHandler) handleGetProfile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.userService.GetProfile(r.Context(), userID)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, user)
+}
+
+func (h *AuthHandler) handleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userService.UpdateProfile(r.Context(), userID, &service.ProfileUpdate{
+		Name:      req.Name,
+		Locale:    req.Locale,
+		Timezone:  req.Timezone,
+		AvatarURL: req.AvatarURL,
+	})
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, user)
+}
+
+func (h *AuthHandler) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.userService.UpdatePassword(r.Context(), userID, req.OldPassword, req.NewPassword); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthHandler) handleChangeEmail(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req ChangeEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.emailChangeSvc.Request(r.Context(), userID, req.Email, req.Password, req.Code); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *AuthHandler) handleConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var req ConfirmEmailChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.emailChangeSvc.Confirm(r.Context(), req.Token)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, user)
+}
+
+func (h *AuthHandler) handleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	secret, uri, err := h.mfaSvc.EnrollTOTP(r.Context(), userID)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, &TOTPEnrollmentResponse{Secret: secret, URI: uri})
+}
+
+func (h *AuthHandler) handleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req ConfirmTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.mfaSvc.ConfirmTOTP(r.Context(), userID, req.Code)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, &RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (h *AuthHandler) handleDisableMFA(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req DisableMFARequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.mfaSvc.Disable(r.Context(), userID, req.Password, req.Code); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthHandler) handleListPasskeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	passkeys, err := h.passkeySvc.List(r.Context(), userID)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, passkeys)
+}
+
+func (h *AuthHandler) handleBeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	options, err := h.passkeySvc.BeginRegistration(r.Context(), userID)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, options)
+}
+
+func (h *AuthHandler) handleFinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req FinishPasskeyRegistrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Credential == nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	passkey, err := h.passkeySvc.FinishRegistration(r.Context(), userID, req.Name, req.Credential)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusCreated, passkey)
+}
+
+func (h *AuthHandler) handleDeletePasskey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		handleError(w, service.ErrPasskeyNotFound)
+		return
+	}
+
+	if err := h.passkeySvc.Delete(r.Context(), userID, id); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthHandler) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	claims, ok := authz.ClaimsFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := h.sessionSvc.List(r.Context(), claims.UserID)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+	for _, session := range sessions {
+		session.Current = session.ID == claims.SessionID
+	}
+
+	h.respondWithJSON(w, http.StatusOK, sessions)
+}
+
+func (h *AuthHandler) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := userIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		handleError(w, service.ErrSessionNotFound)
+		return
+	}
+
+	if err := h.sessionSvc.Revoke(r.Context(), userID, id); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthHandler) handleListRoles(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.roleSvc.ListRoles(r.Context())
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, roles)
+}
+
+func (h *AuthHandler) handleCreateRole(w http.ResponseWriter, r *http.Request) {
+	var req CreateRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	role, err := h.roleSvc.CreateRole(r.Context(), req.Name, req.Description, req.Permissions)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusCreated, role)
+}
+
+func (h *AuthHandler) handleSetRolePermissions(w http.ResponseWriter, r *http.Request) {
+	var req SetRolePermissionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Holders of the role see the change once their access tokens are refreshed
+	role, err := h.roleSvc.SetRolePermissions(r.Context(), mux.Vars(r)["name"], req.Permissions)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, role)
+}
+
+func (h *AuthHandler) handleDeleteRole(w http.ResponseWriter, r *http.Request) {
+	if err := h.roleSvc.DeleteRole(r.Context(), mux.Vars(r)["name"]); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthHandler) handleListPermissions(w http.ResponseWriter, r *http.Request) {
+	permissions, err := h.roleSvc.ListPermissions(r.Context())
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, permissions)
+}
+
+func (h *AuthHandler) handleCreatePermission(w http.ResponseWriter, r *http.Request) {
+	var req CreatePermissionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	permission, err := h.roleSvc.CreatePermission(r.Context(), req.Name, req.Description)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusCreated, permission)
+}
+
+func (h *AuthHandler) handleGetUserRoles(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		handleError(w, service.ErrUserNotFound)
+		return
+	}
+
+	roles, err := h.roleSvc.UserRoles(r.Context(), userID)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, &UserRolesResponse{Roles: roles})
+}
+
+func (h *AuthHandler) handleAssignRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		handleError(w, service.ErrUserNotFound)
+		return
+	}
+
+	if err := h.roleSvc.AssignRole(r.Context(), userID, mux.Vars(r)["role"]); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	// Revoked access tokens make the user's clients refresh and pick up the new grants
+	if err := h.jwtSvc.RevokeUserTokens(r.Context(), userID); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthHandler) handleUnassignRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		handleError(w, service.ErrUserNotFound)
+		return
+	}
+
+	if err := h.roleSvc.UnassignRole(r.Context(), userID, mux.Vars(r)["role"]); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	// Tokens still carrying the role stop working, the refreshed ones come without it
+	if err := h.jwtSvc.RevokeUserTokens(r.Context(), userID); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AuthHandler) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString, err := authz.BearerToken(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := h.jwtSvc.ValidateToken(r.Context(), tokenString)
+		if err != nil {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		user, err := h.userService.GetProfile(r.Context(), claims.UserID)
+		if err != nil {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		// A revoked session takes its access tokens with it before they expire
+		if err := h.sessionSvc.Touch(r.Context(), user.ID, claims.SessionID); err != nil {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(authz.NewContext(r.Context(), claims)))
+	})
+}
+
+// userIDFromContext returns the ID of the user authMiddleware authenticated
+func userIDFromContext(ctx context.Context) (uuid.UUID, bool) {
+	claims, ok := authz.ClaimsFromContext(ctx)
+	if !ok {
+		return uuid.Nil, false
+	}
+	return claims.UserID, true
+}
+
+// challengeMFA answers with an MFA challenge when the user has a second factor, it reports whether it wrote the response
+func (h *AuthHandler) challengeMFA(w http.ResponseWriter, r *http.Request, user *dto.User) bool {
+	enabled, err := h.mfaSvc.Enabled(r.Context(), user.ID)
+	if err != nil {
+		handleError(w, err)
+		return true
+	}
+	if !enabled {
+		return false
+	}
+
+	mfaToken, err := h.mfaSvc.Challenge(r.Context(), user.ID)
+	if err != nil {
+		handleError(w, err)
+		return true
+	}
+
+	h.respondWithJSON(w, http.StatusOK, &MFAChallengeResponse{MFARequired: true, MFAToken: mfaToken})
+	return true
+}
+
+// respondWithTokens starts the session the access token was issued for and writes the login response
+func (h *AuthHandler) respondWithTokens(w http.ResponseWriter, r *http.Request, status int, sessionID uuid.UUID, token string, user *dto.User) {
+	if err := h.sessionSvc.Start(r.Context(), &dto.Session{
+		ID:        sessionID,
+		UserID:    user.ID,
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	}); err != nil {
+		handleError(w, err)
+		return
+	}
+
+	refreshToken, err := h.refreshTokenSvc.Issue(r.Context(), user.ID, sessionID)
+	if err != nil {
+		handleError(w, err)
+		return
+	}
+
+	h.respondWithJSON(w, status, &LoginResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		User:         user,
+	})
+}
+
+func (h *AuthHandler) respondWithJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if data != nil {
+		err := json.NewEncoder(w).Encode(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// clientIP returns the address of the peer, proxies in front of the service must not be shared by clients
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func handleError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+
+	var retryErr *service.RetryAfterError
+	if errors.As(err, &retryErr) {
+		// Retry-After is in whole seconds, rounding up keeps clients from retrying too early
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryErr.RetryAfter.Seconds()))))
+	}
+
+	switch {
+	case errors.Is(err, service.ErrUserNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, service.ErrEmailExists):
+		status = http.StatusConflict
+	case errors.Is(err, service.ErrInvalidPassword),
+		errors.Is(err, service.ErrInvalidCredentials):
+		status = http.StatusUnauthorized
+	case errors.Is(err, service.ErrInvalidEmail),
+		errors.Is(err, service.ErrWeakPassword),
+		errors.Is(err, service.ErrInvalidName),
+		errors.Is(err, service.ErrInvalidLocale),
+		errors.Is(err, service.ErrInvalidTimezone),
+		errors.Is(err, service.ErrInvalidAvatarURL),
+		errors.Is(err, service.ErrSameEmail):
+		status = http.StatusBadRequest
+	case errors.Is(err, service.ErrEmailChangeTokenNotFound),
+		errors.Is(err, service.ErrEmailVerificationTokenNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, service.ErrEmailChangeTokenExpired),
+		errors.Is(err, service.ErrEmailVerificationTokenExpired):
+		status = http.StatusGone
+	case errors.Is(err, service.ErrEmailNotVerified):
+		status = http.StatusForbidden
+	case errors.Is(err, service.ErrTooManyAttempts):
+		status = http.StatusTooManyRequests
+	case errors.Is(err, service.ErrAccountLocked):
+		status = http.StatusLocked
+	case errors.Is(err, service.ErrPasswordResetTokenNotFound),
+		errors.Is(err, service.ErrPasswordResetTokenExpired):
+		status = http.StatusBadRequest
+	case errors.Is(err, service.ErrMFAAlreadyEnabled),
+		errors.Is(err, service.ErrMFANotEnabled):
+		status = http.StatusConflict
+	case errors.Is(err, service.ErrInvalidMFACode),
+		errors.Is(err, service.ErrMFAChallengeNotFound),
+		errors.Is(err, service.ErrMFAChallengeExpired):
+		status = http.StatusUnauthorized
+	case errors.Is(err, service.ErrMagicLinkTokenNotFound),
+		errors.Is(err, service.ErrMagicLinkTokenExpired):
+		status = http.StatusUnauthorized
+	case errors.Is(err, service.ErrPasskeyNotFound),
+		errors.Is(err, service.ErrSessionNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, service.ErrSessionRevoked):
+		status = http.StatusUnauthorized
+	case errors.Is(err, service.ErrRoleNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, service.ErrRoleExists),
+		errors.Is(err, service.ErrPermissionExists),
+		errors.Is(err, service.ErrProtectedRole),
+		errors.Is(err, service.ErrLastAdmin):
+		status = http.StatusConflict
+	case errors.Is(err, service.ErrPermissionNotFound),
+		errors.Is(err, service.ErrInvalidRoleName),
+		errors.Is(err, service.ErrInvalidPermissionName),
+		errors.Is(err, service.ErrInvalidDescription):
+		status = http.StatusBadRequest
+	case errors.Is(err, webauthn.ErrInvalidChallenge),
+		errors.Is(err, webauthn.ErrInvalidCredential):
+		status = http.StatusUnauthorized
+	case errors.Is(err, service.ErrInvalidRefreshToken),
+		errors.Is(err, service.ErrRefreshTokenExpired),
+		errors.Is(err, service.ErrRefreshTokenReused):
+		status = http.StatusUnauthorized
+	case errors.Is(err, oauth.ErrUnknownProvider):
+		status = http.StatusNotFound
+	case errors.Is(err, oauth.ErrInvalidState),
+		errors.Is(err, oauth.ErrInvalidNonce),
+		errors.Is(err, service.ErrUnverifiedExternalEmail):
+		status = http.StatusUnauthorized
 	}
 
 	http.Error(w, err.Error(), status)
@@ -0,0 +1,63 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+	"github.com/yoshapihoff/bricks/auth/internal/service"
+)
+
+type allowAllLimiter struct{}
+
+func (allowAllLimiter) Check(ctx context.Context, action, ip, account string) error {
+	return nil
+}
+
+// blockingResetService holds Create until release is closed and reports the context it ran with
+type blockingResetService struct {
+	service.PasswordResetTokenService
+	release chan struct{}
+	ctxErr  chan error
+}
+
+func (s *blockingResetService) Create(ctx context.Context, userEmail string) (*dto.PasswordResetToken, error) {
+	<-s.release
+	s.ctxErr <- ctx.Err()
+	return nil, service.ErrUserNotFound
+}
+
+func TestForgotPasswordAnswersBeforeCreatingTheToken(t *testing.T) {
+	resetSvc := &blockingResetService{release: make(chan struct{}), ctxErr: make(chan error, 1)}
+	h := &AuthHandler{passwordResetTokenSvc: resetSvc, attemptLimiter: allowAllLimiter{}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodPost, "/auth/forgot-password", strings.NewReader(`{"email":"user@example.com"}`)).WithContext(ctx)
+	rec := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.handleForgotPassword(rec, req)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handler waited for the token to be created")
+	}
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusAccepted)
+	}
+
+	// The request ending does not cancel the work it handed off
+	cancel()
+	close(resetSvc.release)
+	h.Wait()
+	if err := <-resetSvc.ctxErr; err != nil {
+		t.Errorf("token created with a cancelled context: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package janitor
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+// defaultLockID is the Postgres advisory lock key that elects the replica running the janitor
+const defaultLockID int64 = 0x61757468_6a616e69
+
+// Task purges one kind of ephemeral rows
+type Task struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+type Config struct {
+	Interval time.Duration
+	LockID   int64
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Interval: time.Hour,
+		LockID:   defaultLockID,
+	}
+}
+
+// Status describes the last janitor run on this replica
+type Status struct {
+	LastRunAt     *time.Time `json:"last_run_at"`
+	LastSuccessAt *time.Time `json:"last_success_at"`
+	// Skipped is true when another replica held the lock during the last run
+	Skipped bool              `json:"skipped"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// Janitor periodically runs its tasks on whichever replica holds the advisory lock
+type Janitor struct {
+	db     *sql.DB
+	tasks  []Task
+	config Config
+
+	mu     sync.RWMutex
+	status Status
+}
+
+func New(db *sql.DB, config Config, tasks ...Task) *Janitor {
+	return &Janitor{
+		db:     db,
+		tasks:  tasks,
+		config: config,
+	}
+}
+
+// Run runs the tasks once right away and then every interval until ctx is cancelled
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Janitor run failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce runs every task if no other replica is running them. A failing task does not stop the others.
+func (j *Janitor) RunOnce(ctx context.Context) error {
+	// Session level advisory locks belong to a connection, so the lock and unlock must share one
+	conn, err := j.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, j.config.LockID).Scan(&locked); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if !locked {
+		j.setStatus(func(s *Status) {
+			s.LastRunAt = &now
+			s.Skipped = true
+			s.Errors = nil
+		})
+		return nil
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, j.config.LockID); err != nil {
+			log.Printf("Failed to release janitor lock: %v", err)
+		}
+	}()
+
+	errs := make(map[string]string)
+	for _, task := range j.tasks {
+		if err := task.Run(ctx); err != nil {
+			log.Printf("Janitor task %s failed: %v", task.Name, err)
+			errs[task.Name] = err.Error()
+		}
+	}
+
+	j.setStatus(func(s *Status) {
+		s.LastRunAt = &now
+		s.Skipped = false
+		s.Errors = nil
+		if len(errs) > 0 {
+			s.Errors = errs
+			return
+		}
+		s.LastSuccessAt = &now
+	})
+	return nil
+}
+
+// Status returns a snapshot of the last run
+func (j *Janitor) Status() Status {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	return j.status
+}
+
+func (j *Janitor) setStatus(update func(s *Status)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	update(&j.status)
+}
@@ -1,32 +1,161 @@
 package kafka
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/confluentinc/confluent-kafka-go/schemaregistry"
 	"github.com/confluentinc/confluent-kafka-go/schemaregistry/serde"
 	"github.com/confluentinc/confluent-kafka-go/schemaregistry/serde/protobuf"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 const (
 	defaultSessionTimeout = 6000
-	noTimeout             = -1
+	pollTimeout           = 100 * time.Millisecond
+
+	defaultMaxRetries            = 3
+	defaultInitialBackoff        = 100 * time.Millisecond
+	defaultMaxBackoff            = 10 * time.Second
+	defaultDeadLetterTopicSuffix = ".dlq"
+
+	HeaderError             = "x-error"
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
 )
 
+var (
+	ErrUnexpectedMessage = errors.New("unexpected message type")
+	ErrNoHandlers        = errors.New("no handlers registered")
+	ErrAlreadyRegistered = errors.New("topic already has a handler")
+	ErrUnregisteredTopic = errors.New("no handler registered for topic")
+)
+
+// Result tells the consumer what to do with a message once the handler returns
+type Result int
+
+const (
+	// Ack commits the message
+	Ack Result = iota
+	// Retry runs the handler again after a backoff
+	Retry
+	// DeadLetter publishes the message to the dead-letter topic and commits it
+	DeadLetter
+)
+
+// HandlerError carries the result the handler wants for a failed message
+type HandlerError struct {
+	Result Result
+	Err    error
+}
+
+func (e *HandlerError) Error() string {
+	if e.Err == nil {
+		return "handler failed"
+	}
+	return e.Err.Error()
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// RetryError asks the consumer to retry the message
+func RetryError(err error) error {
+	return &HandlerError{Result: Retry, Err: err}
+}
+
+// DeadLetterError asks the consumer to dead-letter the message without retrying
+func DeadLetterError(err error) error {
+	return &HandlerError{Result: DeadLetter, Err: err}
+}
+
+// ResultOf maps a handler error to a Result, plain errors are retried
+func ResultOf(err error) Result {
+	if err == nil {
+		return Ack
+	}
+	var handlerErr *HandlerError
+	if errors.As(err, &handlerErr) {
+		return handlerErr.Result
+	}
+	return Retry
+}
+
+// Metadata describes where a consumed message came from
+type Metadata struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Headers   []kafka.Header
+	Timestamp time.Time
+}
+
+// MessageHandler processes a single deserialized message
+type MessageHandler func(ctx context.Context, message proto.Message, metadata Metadata) error
+
+type registration struct {
+	messageType protoreflect.MessageType
+	handler     MessageHandler
+}
+
+type ConsumerConfig struct {
+	// MaxRetries is the number of times a message is retried before it is dead-lettered
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// DeadLetterTopicSuffix is appended to the source topic to build the dead-letter topic
+	DeadLetterTopicSuffix string
+}
+
+func DefaultConsumerConfig() ConsumerConfig {
+	return ConsumerConfig{
+		MaxRetries:            defaultMaxRetries,
+		InitialBackoff:        defaultInitialBackoff,
+		MaxBackoff:            defaultMaxBackoff,
+		DeadLetterTopicSuffix: defaultDeadLetterTopicSuffix,
+	}
+}
+
+// withDefaults fills the fields left empty, an empty suffix would dead-letter onto the source topic
+func (c ConsumerConfig) withDefaults() ConsumerConfig {
+	if c.DeadLetterTopicSuffix == "" {
+		c.DeadLetterTopicSuffix = defaultDeadLetterTopicSuffix
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = defaultInitialBackoff
+	}
+	if c.MaxBackoff < c.InitialBackoff {
+		c.MaxBackoff = max(defaultMaxBackoff, c.InitialBackoff)
+	}
+	return c
+}
+
 type SRConsumer interface {
-	Run(messageType protoreflect.MessageType, topic string) error
+	// Register binds a topic to the message type it carries and the handler that processes it
+	Register(topic string, messageType protoreflect.MessageType, handler MessageHandler) error
+	// Run subscribes to every registered topic and dispatches messages until ctx is cancelled
+	Run(ctx context.Context) error
 	Close()
 }
 
 type srConsumer struct {
 	consumer     *kafka.Consumer
+	dlqProducer  *kafka.Producer
 	deserializer *protobuf.Deserializer
+	config       ConsumerConfig
+	handlers     map[string]registration
 }
 
-func NewConsumer(kafkaURL, srURL string, groupID string) (SRConsumer, error) {
+func NewConsumer(kafkaURL, srURL string, groupID string, config ConsumerConfig) (SRConsumer, error) {
 	c, err := kafka.NewConsumer(&kafka.ConfigMap{
 		"bootstrap.servers":  kafkaURL,
 		"group.id":           groupID,
@@ -37,6 +166,11 @@ func NewConsumer(kafkaURL, srURL string, groupID string) (SRConsumer, error) {
 		return nil, err
 	}
 
+	p, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": kafkaURL})
+	if err != nil {
+		return nil, err
+	}
+
 	sr, err := schemaregistry.NewClient(schemaregistry.NewConfig(srURL))
 	if err != nil {
 		return nil, err
@@ -48,44 +182,148 @@ func NewConsumer(kafkaURL, srURL string, groupID string) (SRConsumer, error) {
 	}
 	return &srConsumer{
 		consumer:     c,
+		dlqProducer:  p,
 		deserializer: d,
+		config:       config.withDefaults(),
+		handlers:     make(map[string]registration),
 	}, nil
 }
 
-func (c *srConsumer) RegisterMessage(messageType protoreflect.MessageType) error {
+func (c *srConsumer) Register(topic string, messageType protoreflect.MessageType, handler MessageHandler) error {
+	if _, ok := c.handlers[topic]; ok {
+		return fmt.Errorf("%w: %s", ErrAlreadyRegistered, topic)
+	}
+	name := messageType.Descriptor().FullName()
+	if _, err := c.deserializer.ProtoRegistry.FindMessageByName(name); err != nil {
+		if err := c.deserializer.ProtoRegistry.RegisterMessage(messageType); err != nil {
+			return err
+		}
+	}
+	c.handlers[topic] = registration{
+		messageType: messageType,
+		handler:     handler,
+	}
 	return nil
 }
 
-func (c *srConsumer) Run(messageType protoreflect.MessageType, topic string) error {
-	if err := c.consumer.SubscribeTopics([]string{topic}, nil); err != nil {
-		return err
+func (c *srConsumer) Run(ctx context.Context) error {
+	if len(c.handlers) == 0 {
+		return ErrNoHandlers
 	}
-	if err := c.deserializer.ProtoRegistry.RegisterMessage(messageType); err != nil {
+	topics := make([]string, 0, len(c.handlers))
+	for topic := range c.handlers {
+		topics = append(topics, topic)
+	}
+	if err := c.consumer.SubscribeTopics(topics, nil); err != nil {
 		return err
 	}
+	defer c.consumer.Unsubscribe()
+
 	for {
-		kafkaMsg, err := c.consumer.ReadMessage(noTimeout)
+		if ctx.Err() != nil {
+			return nil
+		}
+		kafkaMsg, err := c.consumer.ReadMessage(pollTimeout)
 		if err != nil {
+			var kafkaErr kafka.Error
+			if errors.As(err, &kafkaErr) && kafkaErr.Code() == kafka.ErrTimedOut {
+				continue
+			}
 			return err
 		}
-		msg, err := c.deserializer.Deserialize(topic, kafkaMsg.Value)
-		if err != nil {
+		if err := c.handleMessage(ctx, kafkaMsg); err != nil {
+			if ctx.Err() != nil {
+				// Leave the message uncommitted so it is redelivered after restart
+				return nil
+			}
 			return err
 		}
-		c.handleMessage(msg, int64(kafkaMsg.TopicPartition.Offset))
 		if _, err = c.consumer.CommitMessage(kafkaMsg); err != nil {
 			return err
 		}
 	}
 }
 
-func (c *srConsumer) handleMessage(message interface{}, offset int64) {
-	fmt.Printf("message %v with offset %d\n", message, offset)
+// handleMessage runs the handler with retries and dead-letters the message when it cannot be processed.
+// The returned error is only non-nil when the message could not be dead-lettered either.
+func (c *srConsumer) handleMessage(ctx context.Context, kafkaMsg *kafka.Message) error {
+	topic := *kafkaMsg.TopicPartition.Topic
+	reg, ok := c.handlers[topic]
+	if !ok {
+		return c.deadLetter(kafkaMsg, fmt.Errorf("%w: %s", ErrUnregisteredTopic, topic))
+	}
+	msg, err := c.deserializer.Deserialize(topic, kafkaMsg.Value)
+	if err != nil {
+		return c.deadLetter(kafkaMsg, err)
+	}
+	protoMsg, ok := msg.(proto.Message)
+	if !ok || protoMsg.ProtoReflect().Descriptor().FullName() != reg.messageType.Descriptor().FullName() {
+		return c.deadLetter(kafkaMsg, ErrUnexpectedMessage)
+	}
+
+	metadata := Metadata{
+		Topic:     topic,
+		Partition: kafkaMsg.TopicPartition.Partition,
+		Offset:    int64(kafkaMsg.TopicPartition.Offset),
+		Key:       kafkaMsg.Key,
+		Headers:   kafkaMsg.Headers,
+		Timestamp: kafkaMsg.Timestamp,
+	}
+
+	backoff := c.config.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		err := reg.handler(ctx, protoMsg, metadata)
+		switch ResultOf(err) {
+		case Ack:
+			return nil
+		case DeadLetter:
+			return c.deadLetter(kafkaMsg, err)
+		}
+
+		if attempt >= c.config.MaxRetries {
+			return c.deadLetter(kafkaMsg, fmt.Errorf("retries exhausted: %w", err))
+		}
+		log.Printf("Retrying message at %v in %s: %v", kafkaMsg.TopicPartition, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, c.config.MaxBackoff)
+	}
+}
+
+// deadLetter publishes the original payload to the dead-letter topic and waits for the broker ack
+func (c *srConsumer) deadLetter(kafkaMsg *kafka.Message, cause error) error {
+	topic := *kafkaMsg.TopicPartition.Topic + c.config.DeadLetterTopicSuffix
+	log.Printf("Dead-lettering message at %v to %s: %v", kafkaMsg.TopicPartition, topic, cause)
+
+	deliveryChan := make(chan kafka.Event, 1)
+	if err := c.dlqProducer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            kafkaMsg.Key,
+		Value:          kafkaMsg.Value,
+		Headers: append(kafkaMsg.Headers,
+			kafka.Header{Key: HeaderError, Value: []byte(cause.Error())},
+			kafka.Header{Key: HeaderOriginalTopic, Value: []byte(*kafkaMsg.TopicPartition.Topic)},
+			kafka.Header{Key: HeaderOriginalPartition, Value: []byte(strconv.Itoa(int(kafkaMsg.TopicPartition.Partition)))},
+			kafka.Header{Key: HeaderOriginalOffset, Value: []byte(strconv.FormatInt(int64(kafkaMsg.TopicPartition.Offset), 10))},
+		),
+	}, deliveryChan); err != nil {
+		return err
+	}
+
+	e := <-deliveryChan
+	if m, ok := e.(*kafka.Message); ok {
+		return m.TopicPartition.Error
+	}
+	return fmt.Errorf("unexpected delivery event: %v", e)
 }
 
 func (c *srConsumer) Close() {
 	if err := c.consumer.Close(); err != nil {
 		log.Fatal(err)
 	}
+	c.dlqProducer.Close()
 	c.deserializer.Close()
 }
@@ -0,0 +1,204 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/confluentinc/confluent-kafka-go/schemaregistry"
+	"github.com/confluentinc/confluent-kafka-go/schemaregistry/serde"
+	"github.com/confluentinc/confluent-kafka-go/schemaregistry/serde/protobuf"
+	sendEmail "github.com/yoshapihoff/bricks/auth/pkg/sendEmail.v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestConsumerConfigWithDefaultsKeepsDeadLettersOffTheSourceTopic(t *testing.T) {
+	config := ConsumerConfig{MaxRetries: 1}.withDefaults()
+	if config.DeadLetterTopicSuffix != defaultDeadLetterTopicSuffix {
+		t.Errorf("DeadLetterTopicSuffix = %q, want %q", config.DeadLetterTopicSuffix, defaultDeadLetterTopicSuffix)
+	}
+	if config.InitialBackoff != defaultInitialBackoff || config.MaxBackoff != defaultMaxBackoff {
+		t.Errorf("backoff = %s..%s, want %s..%s", config.InitialBackoff, config.MaxBackoff, defaultInitialBackoff, defaultMaxBackoff)
+	}
+
+	custom := ConsumerConfig{DeadLetterTopicSuffix: ".dead"}.withDefaults()
+	if custom.DeadLetterTopicSuffix != ".dead" {
+		t.Errorf("DeadLetterTopicSuffix = %q, want .dead", custom.DeadLetterTopicSuffix)
+	}
+}
+
+func TestHandlerErrorWithoutCause(t *testing.T) {
+	err := DeadLetterError(nil)
+	if err.Error() == "" {
+		t.Error("empty error message")
+	}
+	if ResultOf(err) != DeadLetter {
+		t.Errorf("ResultOf = %v, want DeadLetter", ResultOf(err))
+	}
+	if errors.Unwrap(err) != nil {
+		t.Errorf("Unwrap = %v, want nil", errors.Unwrap(err))
+	}
+}
+
+// newTestConsumer returns a consumer backed by a mock schema registry and a mock cluster for dead letters,
+// along with a function that encodes messages the way the producer does
+func newTestConsumer(t *testing.T, config ConsumerConfig) (*srConsumer, func(topic string, msg proto.Message) *kafka.Message) {
+	t.Helper()
+
+	sr, err := schemaregistry.NewClient(schemaregistry.NewConfig("mock://"))
+	if err != nil {
+		t.Fatalf("schema registry: %v", err)
+	}
+	serializer, err := protobuf.NewSerializer(sr, serde.ValueSerde, protobuf.NewSerializerConfig())
+	if err != nil {
+		t.Fatalf("serializer: %v", err)
+	}
+	deserializer, err := protobuf.NewDeserializer(sr, serde.ValueSerde, protobuf.NewDeserializerConfig())
+	if err != nil {
+		t.Fatalf("deserializer: %v", err)
+	}
+	dlqProducer, err := kafka.NewProducer(&kafka.ConfigMap{"test.mock.num.brokers": 1})
+	if err != nil {
+		t.Fatalf("producer: %v", err)
+	}
+	t.Cleanup(dlqProducer.Close)
+
+	c := &srConsumer{
+		dlqProducer:  dlqProducer,
+		deserializer: deserializer,
+		config:       config.withDefaults(),
+		handlers:     make(map[string]registration),
+	}
+	encode := func(topic string, msg proto.Message) *kafka.Message {
+		payload, err := serializer.Serialize(topic, msg)
+		if err != nil {
+			t.Fatalf("serialize: %v", err)
+		}
+		return &kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 2, Offset: 42},
+			Key:            []byte("key"),
+			Value:          payload,
+		}
+	}
+	return c, encode
+}
+
+func TestHandleMessageDispatchesTypedMessagesByTopic(t *testing.T) {
+	c, encode := newTestConsumer(t, DefaultConsumerConfig())
+
+	var (
+		gotEmail    *sendEmail.SendEmail
+		gotMetadata Metadata
+		gotString   *wrapperspb.StringValue
+	)
+	if err := c.Register("emails", (&sendEmail.SendEmail{}).ProtoReflect().Type(), func(ctx context.Context, message proto.Message, metadata Metadata) error {
+		gotEmail, _ = message.(*sendEmail.SendEmail)
+		gotMetadata = metadata
+		return nil
+	}); err != nil {
+		t.Fatalf("register emails: %v", err)
+	}
+	if err := c.Register("strings", (&wrapperspb.StringValue{}).ProtoReflect().Type(), func(ctx context.Context, message proto.Message, metadata Metadata) error {
+		gotString, _ = message.(*wrapperspb.StringValue)
+		return nil
+	}); err != nil {
+		t.Fatalf("register strings: %v", err)
+	}
+	if err := c.Register("emails", (&sendEmail.SendEmail{}).ProtoReflect().Type(), nil); !errors.Is(err, ErrAlreadyRegistered) {
+		t.Errorf("second registration: error = %v, want %v", err, ErrAlreadyRegistered)
+	}
+
+	if err := c.handleMessage(context.Background(), encode("emails", &sendEmail.SendEmail{To: []string{"user@example.com"}, Template: "verify-email"})); err != nil {
+		t.Fatalf("handle email: %v", err)
+	}
+	if err := c.handleMessage(context.Background(), encode("strings", wrapperspb.String("hello"))); err != nil {
+		t.Fatalf("handle string: %v", err)
+	}
+
+	if gotEmail == nil || gotEmail.GetTemplate() != "verify-email" || gotEmail.GetTo()[0] != "user@example.com" {
+		t.Errorf("email handler got %v", gotEmail)
+	}
+	if gotMetadata.Topic != "emails" || gotMetadata.Partition != 2 || gotMetadata.Offset != 42 || string(gotMetadata.Key) != "key" {
+		t.Errorf("metadata = %+v", gotMetadata)
+	}
+	if gotString.GetValue() != "hello" {
+		t.Errorf("string handler got %v", gotString)
+	}
+}
+
+func TestHandleMessageStopsRetryingWhenCancelled(t *testing.T) {
+	c, encode := newTestConsumer(t, ConsumerConfig{MaxRetries: 5, InitialBackoff: time.Hour, MaxBackoff: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	if err := c.Register("emails", (&sendEmail.SendEmail{}).ProtoReflect().Type(), func(ctx context.Context, message proto.Message, metadata Metadata) error {
+		calls++
+		// The broker goes away while the message waits for its retry
+		cancel()
+		return RetryError(errors.New("mail server unavailable"))
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.handleMessage(ctx, encode("emails", &sendEmail.SendEmail{}))
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handleMessage kept waiting for the backoff after cancellation")
+	}
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+}
+
+func TestHandleMessageRetriesThenAcks(t *testing.T) {
+	c, encode := newTestConsumer(t, ConsumerConfig{MaxRetries: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+
+	calls := 0
+	if err := c.Register("emails", (&sendEmail.SendEmail{}).ProtoReflect().Type(), func(ctx context.Context, message proto.Message, metadata Metadata) error {
+		calls++
+		if calls < 3 {
+			return errors.New("temporary failure")
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	if err := c.handleMessage(context.Background(), encode("emails", &sendEmail.SendEmail{})); err != nil {
+		t.Fatalf("handleMessage() error = %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("handler ran %d times, want 3", calls)
+	}
+}
+
+func TestHandleMessageDeadLettersTheWrongType(t *testing.T) {
+	c, encode := newTestConsumer(t, DefaultConsumerConfig())
+
+	if err := c.Register("emails", (&sendEmail.SendEmail{}).ProtoReflect().Type(), func(ctx context.Context, message proto.Message, metadata Metadata) error {
+		t.Error("handler called with a message of another type")
+		return nil
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := c.Register("strings", (&wrapperspb.StringValue{}).ProtoReflect().Type(), func(ctx context.Context, message proto.Message, metadata Metadata) error {
+		return nil
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	// A string published to the email topic is dead-lettered instead of reaching the email handler
+	if err := c.handleMessage(context.Background(), encode("emails", wrapperspb.String("not an email"))); err != nil {
+		t.Fatalf("handleMessage() error = %v, want the message dead-lettered", err)
+	}
+}
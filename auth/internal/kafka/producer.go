@@ -1,6 +1,14 @@
 package kafka
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/confluentinc/confluent-kafka-go/schemaregistry"
 	"github.com/confluentinc/confluent-kafka-go/schemaregistry/serde"
@@ -10,20 +18,81 @@ import (
 
 const (
 	nullOffset = -1
+
+	defaultLinger       = 5 * time.Millisecond
+	defaultBatchSize    = 10000
+	defaultFlushTimeout = 10 * time.Second
 )
 
+// ErrNoDeliveryReport is returned for a batched record whose delivery report never arrived
+var ErrNoDeliveryReport = errors.New("no delivery report")
+
+// DeliveryErrorHandler is called from the delivery goroutine for every asynchronously produced message that failed
+type DeliveryErrorHandler func(topic string, err error)
+
+type ProducerConfig struct {
+	// Linger is how long the client waits to batch messages before sending them
+	Linger time.Duration
+	// BatchSize is the maximum number of messages sent in one batch
+	BatchSize int
+	// FlushTimeout bounds how long Close waits for outstanding messages
+	FlushTimeout    time.Duration
+	OnDeliveryError DeliveryErrorHandler
+}
+
+func DefaultProducerConfig() ProducerConfig {
+	return ProducerConfig{
+		Linger:       defaultLinger,
+		BatchSize:    defaultBatchSize,
+		FlushTimeout: defaultFlushTimeout,
+		OnDeliveryError: func(topic string, err error) {
+			log.Printf("Failed to deliver message to %s: %v", topic, err)
+		},
+	}
+}
+
+// ProducerStats counts the outcome of asynchronously produced messages
+type ProducerStats struct {
+	Delivered uint64
+	Failed    uint64
+}
+
+// Record is a message bound for a topic
+type Record struct {
+	Message proto.Message
+	Topic   string
+}
+
 type SRProducer interface {
+	// ProduceMessage blocks until the broker acks the message and returns its offset
 	ProduceMessage(msg proto.Message, topic string) (int64, error)
+	// ProduceMessageAsync enqueues the message and returns without waiting for the broker,
+	// failures reach OnDeliveryError and both outcomes are counted in Stats
+	ProduceMessageAsync(msg proto.Message, topic string) error
+	// ProduceBatch enqueues every record at once and blocks until the broker acked or rejected each of them,
+	// or ctx is done. The returned slice holds the delivery error of every record, in order, records still
+	// waiting for their report when ctx is done get ErrNoDeliveryReport.
+	ProduceBatch(ctx context.Context, records []Record) []error
+	Stats() ProducerStats
+	// Close waits up to FlushTimeout for outstanding messages before closing the producer
 	Close()
 }
 
 type srProducer struct {
 	producer   *kafka.Producer
 	serializer serde.Serializer
+	config     ProducerConfig
+	delivered  atomic.Uint64
+	failed     atomic.Uint64
+	wg         sync.WaitGroup
 }
 
-func NewProducer(kafkaURL, srURL string) (SRProducer, error) {
-	p, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": kafkaURL})
+func NewProducer(kafkaURL, srURL string, config ProducerConfig) (SRProducer, error) {
+	p, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers":  kafkaURL,
+		"linger.ms":          int(config.Linger.Milliseconds()),
+		"batch.num.messages": config.BatchSize,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -35,36 +104,147 @@ func NewProducer(kafkaURL, srURL string) (SRProducer, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &srProducer{
+	sp := &srProducer{
 		producer:   p,
 		serializer: s,
-	}, nil
+		config:     config,
+	}
+	sp.wg.Add(1)
+	go sp.handleDeliveryReports(p.Events())
+	return sp, nil
 }
 
 func (p *srProducer) ProduceMessage(msg proto.Message, topic string) (int64, error) {
-	kafkaChan := make(chan kafka.Event)
-	defer close(kafkaChan)
 	payload, err := p.serializer.Serialize(topic, msg)
 	if err != nil {
 		return nullOffset, err
 	}
+	deliveryChan := make(chan kafka.Event, 1)
 	if err = p.producer.Produce(&kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &topic},
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
 		Value:          payload,
-	}, kafkaChan); err != nil {
+	}, deliveryChan); err != nil {
 		return nullOffset, err
 	}
-	e := <-kafkaChan
+	return deliveryOffset(<-deliveryChan)
+}
+
+func (p *srProducer) ProduceBatch(ctx context.Context, records []Record) []error {
+	errs := make([]error, len(records))
+	// Every record gets its report on the shared channel, the opaque carries its index
+	deliveryChan := make(chan kafka.Event, len(records))
+	pending := 0
+	for i, record := range records {
+		payload, err := p.serializer.Serialize(record.Topic, record.Message)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if err := p.producer.Produce(&kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &record.Topic, Partition: kafka.PartitionAny},
+			Value:          payload,
+			Opaque:         i,
+		}, deliveryChan); err != nil {
+			errs[i] = err
+			continue
+		}
+		errs[i] = ErrNoDeliveryReport
+		pending++
+	}
+
+	// The channel is buffered for every record, reports arriving after we stopped waiting do not block
+	for ; pending > 0; pending-- {
+		var e kafka.Event
+		select {
+		case <-ctx.Done():
+			return errs
+		case e = <-deliveryChan:
+		}
+		m, ok := e.(*kafka.Message)
+		if !ok {
+			// Only messages are sent to a delivery channel, anything else means the report is lost
+			log.Printf("Unexpected delivery event: %v", e)
+			continue
+		}
+		i, ok := m.Opaque.(int)
+		if !ok || i < 0 || i >= len(errs) {
+			continue
+		}
+		_, errs[i] = deliveryOffset(m)
+	}
+	return errs
+}
+
+func (p *srProducer) ProduceMessageAsync(msg proto.Message, topic string) error {
+	payload, err := p.serializer.Serialize(topic, msg)
+	if err != nil {
+		return err
+	}
+	// A nil delivery channel routes the report to producer.Events()
+	return p.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Value:          payload,
+	}, nil)
+}
+
+func (p *srProducer) Stats() ProducerStats {
+	return ProducerStats{
+		Delivered: p.delivered.Load(),
+		Failed:    p.failed.Load(),
+	}
+}
+
+// handleDeliveryReports drains producer.Events() until the producer is closed. It carries the reports of
+// asynchronously produced messages and client level errors, the other paths use their own delivery channels.
+func (p *srProducer) handleDeliveryReports(events <-chan kafka.Event) {
+	defer p.wg.Done()
+	for e := range events {
+		switch ev := e.(type) {
+		case *kafka.Message:
+			if ev.TopicPartition.Error != nil {
+				p.failed.Add(1)
+				p.reportError(topicOf(ev), ev.TopicPartition.Error)
+				continue
+			}
+			p.delivered.Add(1)
+		case kafka.Error:
+			p.reportError("", ev)
+		}
+	}
+}
+
+func (p *srProducer) reportError(topic string, err error) {
+	if p.config.OnDeliveryError != nil {
+		p.config.OnDeliveryError(topic, err)
+	}
+}
+
+func (p *srProducer) Close() {
+	if remaining := p.producer.Flush(int(p.config.FlushTimeout.Milliseconds())); remaining > 0 {
+		log.Printf("Kafka producer closed with %d undelivered messages", remaining)
+	}
+	p.serializer.Close()
+	p.producer.Close()
+	p.wg.Wait()
+}
+
+// deliveryOffset returns the offset of a delivered message or the reason it was not delivered
+func deliveryOffset(e kafka.Event) (int64, error) {
 	switch ev := e.(type) {
 	case *kafka.Message:
+		if ev.TopicPartition.Error != nil {
+			return nullOffset, ev.TopicPartition.Error
+		}
 		return int64(ev.TopicPartition.Offset), nil
 	case kafka.Error:
-		return nullOffset, err
+		return nullOffset, ev
 	}
-	return nullOffset, nil
+	return nullOffset, fmt.Errorf("unexpected delivery event: %v", e)
 }
 
-func (p *srProducer) Close() {
-	p.serializer.Close()
-	p.producer.Close()
+func topicOf(msg *kafka.Message) string {
+	if msg.TopicPartition.Topic == nil {
+		return ""
+	}
+	return *msg.TopicPartition.Topic
 }
@@ -0,0 +1,54 @@
+package kafka
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestHandleDeliveryReportsCountsAndReportsFailures(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		failures []string
+	)
+	p := &srProducer{config: ProducerConfig{
+		OnDeliveryError: func(topic string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			failures = append(failures, topic+": "+err.Error())
+		},
+	}}
+
+	topic := "verify-email"
+	events := make(chan kafka.Event, 4)
+	events <- &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Offset: 1}}
+	events <- &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Error: errors.New("message too large")}}
+	events <- &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Offset: 2}}
+	events <- kafka.NewError(kafka.ErrAllBrokersDown, "all brokers down", false)
+	close(events)
+
+	p.wg.Add(1)
+	p.handleDeliveryReports(events)
+
+	if stats := p.Stats(); stats != (ProducerStats{Delivered: 2, Failed: 1}) {
+		t.Errorf("stats = %+v, want 2 delivered and 1 failed", stats)
+	}
+	if len(failures) != 2 || failures[0] != "verify-email: message too large" {
+		t.Errorf("failures = %q, want the failed delivery and the client error", failures)
+	}
+}
+
+func TestDeliveryOffset(t *testing.T) {
+	topic := "verify-email"
+	if offset, err := deliveryOffset(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Offset: 42}}); offset != 42 || err != nil {
+		t.Errorf("delivered: offset = %d, err = %v", offset, err)
+	}
+	if offset, err := deliveryOffset(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Error: errors.New("rejected")}}); offset != nullOffset || err == nil {
+		t.Errorf("rejected: offset = %d, err = %v", offset, err)
+	}
+	if _, err := deliveryOffset(kafka.NewError(kafka.ErrTimedOut, "timed out", false)); err == nil {
+		t.Error("client error returned no error")
+	}
+}
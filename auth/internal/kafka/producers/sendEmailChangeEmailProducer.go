@@ -0,0 +1,63 @@
+package producers
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/yoshapihoff/bricks/auth/internal/outbox"
+	"github.com/yoshapihoff/bricks/auth/internal/repository"
+	sendEmail "github.com/yoshapihoff/bricks/auth/pkg/sendEmail.v1"
+)
+
+// EmailChangeEmailProducer writes email change emails to the outbox, the outbox relay publishes them to Kafka
+type EmailChangeEmailProducer struct {
+	outboxRepo repository.OutboxRepository
+	topic      string
+}
+
+func NewEmailChangeEmailProducer(outboxRepo repository.OutboxRepository, topic string) *EmailChangeEmailProducer {
+	return &EmailChangeEmailProducer{
+		outboxRepo: outboxRepo,
+		topic:      topic,
+	}
+}
+
+// ProduceEmailChangeConfirmation stores the confirmation email for the new address in the outbox as part of tx
+func (p *EmailChangeEmailProducer) ProduceEmailChangeConfirmation(ctx context.Context, tx *sql.Tx, newEmail, emailChangeToken string) error {
+	return p.produce(ctx, tx, &sendEmail.SendEmail{
+		To:       []string{newEmail},
+		Subject:  "Confirm your new email address",
+		Template: "email-change-confirm",
+		Params: map[string]string{
+			"email_change_token": emailChangeToken,
+			"new_email":          newEmail,
+		},
+	})
+}
+
+// ProduceEmailChangeNotice stores the notice for the current address in the outbox as part of tx
+func (p *EmailChangeEmailProducer) ProduceEmailChangeNotice(ctx context.Context, tx *sql.Tx, oldEmail, newEmail string) error {
+	return p.produce(ctx, tx, &sendEmail.SendEmail{
+		To:       []string{oldEmail},
+		Subject:  "Email change requested",
+		Template: "email-change-notice",
+		Params:   map[string]string{"new_email": newEmail},
+	})
+}
+
+// ProduceEmailChangeAddressTaken stores a notice for the owner of an address someone else tried to move to
+func (p *EmailChangeEmailProducer) ProduceEmailChangeAddressTaken(ctx context.Context, tx *sql.Tx, newEmail string) error {
+	return p.produce(ctx, tx, &sendEmail.SendEmail{
+		To:       []string{newEmail},
+		Subject:  "Someone tried to use your email address",
+		Template: "email-change-taken",
+	})
+}
+
+func (p *EmailChangeEmailProducer) produce(ctx context.Context, tx *sql.Tx, sendEmailMsg *sendEmail.SendEmail) error {
+	outboxMsg, err := outbox.NewMessage(p.topic, sendEmailMsg)
+	if err != nil {
+		return err
+	}
+	return p.outboxRepo.WithTx(tx).Create(ctx, outboxMsg)
+}
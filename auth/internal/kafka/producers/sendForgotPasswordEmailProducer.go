@@ -1,37 +1,52 @@
 package producers
 
 import (
-	"github.com/yoshapihoff/bricks/auth/internal/config"
-	"github.com/yoshapihoff/bricks/auth/internal/kafka"
+	"context"
+	"database/sql"
+
+	"github.com/yoshapihoff/bricks/auth/internal/outbox"
+	"github.com/yoshapihoff/bricks/auth/internal/repository"
 	sendEmail "github.com/yoshapihoff/bricks/auth/pkg/sendEmail.v1"
 )
 
+// ForgotPasswordEmailProducer writes forgot password emails to the outbox, the outbox relay publishes them to Kafka
 type ForgotPasswordEmailProducer struct {
-	srProducer kafka.SRProducer
+	outboxRepo repository.OutboxRepository
 	topic      string
 }
 
-func NewForgotPasswordEmailProducer(cfg *config.Config) (*ForgotPasswordEmailProducer, error) {
-	srProducer, err := kafka.NewProducer(cfg.Kafka.KafkaUrl, cfg.Kafka.SchemaRegistryUrl)
-	if err != nil {
-		return nil, err
-	}
+func NewForgotPasswordEmailProducer(outboxRepo repository.OutboxRepository, topic string) *ForgotPasswordEmailProducer {
 	return &ForgotPasswordEmailProducer{
-		srProducer: srProducer,
-		topic:      cfg.ForgotPasswordEmailSendingTopic,
-	}, nil
+		outboxRepo: outboxRepo,
+		topic:      topic,
+	}
 }
 
-func (p *ForgotPasswordEmailProducer) ProduceForgotPasswordEmail(email, resetPasswordToken string) (int64, error) {
+// ProduceForgotPasswordEmail stores the email in the outbox as part of tx
+func (p *ForgotPasswordEmailProducer) ProduceForgotPasswordEmail(ctx context.Context, tx *sql.Tx, email, resetPasswordToken string) error {
 	sendEmailMsg := &sendEmail.SendEmail{
 		To:       []string{email},
 		Subject:  "Forgot Password",
 		Template: "forgot-password",
 		Params:   map[string]string{"reset_password_token": resetPasswordToken},
 	}
-	return p.srProducer.ProduceMessage(sendEmailMsg, p.topic)
+	outboxMsg, err := outbox.NewMessage(p.topic, sendEmailMsg)
+	if err != nil {
+		return err
+	}
+	return p.outboxRepo.WithTx(tx).Create(ctx, outboxMsg)
 }
 
-func (p *ForgotPasswordEmailProducer) Close() {
-	p.srProducer.Close()
+// ProducePasswordResetConfirmation stores the email confirming the password was reset in the outbox as part of tx
+func (p *ForgotPasswordEmailProducer) ProducePasswordResetConfirmation(ctx context.Context, tx *sql.Tx, email string) error {
+	sendEmailMsg := &sendEmail.SendEmail{
+		To:       []string{email},
+		Subject:  "Your password was reset",
+		Template: "password-reset-confirmation",
+	}
+	outboxMsg, err := outbox.NewMessage(p.topic, sendEmailMsg)
+	if err != nil {
+		return err
+	}
+	return p.outboxRepo.WithTx(tx).Create(ctx, outboxMsg)
 }
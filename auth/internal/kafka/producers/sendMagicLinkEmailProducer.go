@@ -0,0 +1,38 @@
+package producers
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/yoshapihoff/bricks/auth/internal/outbox"
+	"github.com/yoshapihoff/bricks/auth/internal/repository"
+	sendEmail "github.com/yoshapihoff/bricks/auth/pkg/sendEmail.v1"
+)
+
+// MagicLinkEmailProducer writes magic link emails to the outbox, the outbox relay publishes them to Kafka
+type MagicLinkEmailProducer struct {
+	outboxRepo repository.OutboxRepository
+	topic      string
+}
+
+func NewMagicLinkEmailProducer(outboxRepo repository.OutboxRepository, topic string) *MagicLinkEmailProducer {
+	return &MagicLinkEmailProducer{
+		outboxRepo: outboxRepo,
+		topic:      topic,
+	}
+}
+
+// ProduceMagicLinkEmail stores the email in the outbox as part of tx
+func (p *MagicLinkEmailProducer) ProduceMagicLinkEmail(ctx context.Context, tx *sql.Tx, email, magicLinkToken string) error {
+	sendEmailMsg := &sendEmail.SendEmail{
+		To:       []string{email},
+		Subject:  "Your sign in link",
+		Template: "magic-link",
+		Params:   map[string]string{"magic_link_token": magicLinkToken},
+	}
+	outboxMsg, err := outbox.NewMessage(p.topic, sendEmailMsg)
+	if err != nil {
+		return err
+	}
+	return p.outboxRepo.WithTx(tx).Create(ctx, outboxMsg)
+}
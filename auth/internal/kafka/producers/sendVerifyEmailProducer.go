@@ -0,0 +1,52 @@
+package producers
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/yoshapihoff/bricks/auth/internal/outbox"
+	"github.com/yoshapihoff/bricks/auth/internal/repository"
+	sendEmail "github.com/yoshapihoff/bricks/auth/pkg/sendEmail.v1"
+)
+
+// VerifyEmailProducer writes email verification emails to the outbox, the outbox relay publishes them to Kafka
+type VerifyEmailProducer struct {
+	outboxRepo repository.OutboxRepository
+	topic      string
+}
+
+func NewVerifyEmailProducer(outboxRepo repository.OutboxRepository, topic string) *VerifyEmailProducer {
+	return &VerifyEmailProducer{
+		outboxRepo: outboxRepo,
+		topic:      topic,
+	}
+}
+
+// ProduceVerifyEmail stores the email in the outbox as part of tx
+func (p *VerifyEmailProducer) ProduceVerifyEmail(ctx context.Context, tx *sql.Tx, email, verifyEmailToken string) error {
+	sendEmailMsg := &sendEmail.SendEmail{
+		To:       []string{email},
+		Subject:  "Verify your email address",
+		Template: "verify-email",
+		Params:   map[string]string{"verify_email_token": verifyEmailToken},
+	}
+	outboxMsg, err := outbox.NewMessage(p.topic, sendEmailMsg)
+	if err != nil {
+		return err
+	}
+	return p.outboxRepo.WithTx(tx).Create(ctx, outboxMsg)
+}
+
+// ProduceAccountExistsEmail stores a notice for a sign up attempt with an address that is already registered
+func (p *VerifyEmailProducer) ProduceAccountExistsEmail(ctx context.Context, tx *sql.Tx, email string) error {
+	sendEmailMsg := &sendEmail.SendEmail{
+		To:       []string{email},
+		Subject:  "You already have an account",
+		Template: "account-exists",
+	}
+	outboxMsg, err := outbox.NewMessage(p.topic, sendEmailMsg)
+	if err != nil {
+		return err
+	}
+	return p.outboxRepo.WithTx(tx).Create(ctx, outboxMsg)
+}
@@ -0,0 +1,85 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const randomValueBytes = 32
+
+// Manager runs the authorization code flow with PKCE, state and nonce for the registered providers
+type Manager struct {
+	providers map[string]Provider
+	states    StateStore
+	stateTTL  time.Duration
+}
+
+func NewManager(states StateStore, stateTTL time.Duration, providers ...Provider) *Manager {
+	m := &Manager{
+		providers: make(map[string]Provider, len(providers)),
+		states:    states,
+		stateTTL:  stateTTL,
+	}
+	for _, provider := range providers {
+		m.providers[provider.Name()] = provider
+	}
+	return m
+}
+
+// Start begins a flow and returns the URL to send the user to along with its state
+func (m *Manager) Start(ctx context.Context, providerName string) (string, string, error) {
+	provider, ok := m.providers[providerName]
+	if !ok {
+		return "", "", fmt.Errorf("%w: %s", ErrUnknownProvider, providerName)
+	}
+
+	state, err := randomValue()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomValue()
+	if err != nil {
+		return "", "", err
+	}
+	flow := &Flow{
+		Provider: providerName,
+		Nonce:    nonce,
+		Verifier: oauth2.GenerateVerifier(),
+	}
+	if err := m.states.Save(ctx, state, flow, m.stateTTL); err != nil {
+		return "", "", err
+	}
+
+	return provider.AuthCodeURL(state, flow.Nonce, flow.Verifier), state, nil
+}
+
+// Finish validates the state and exchanges the code for the user identity
+func (m *Manager) Finish(ctx context.Context, providerName, state, code string) (*Identity, error) {
+	provider, ok := m.providers[providerName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, providerName)
+	}
+
+	flow, err := m.states.Consume(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+	if flow.Provider != providerName {
+		return nil, ErrInvalidState
+	}
+
+	return provider.Exchange(ctx, code, flow.Verifier, flow.Nonce)
+}
+
+func randomValue() (string, error) {
+	b := make([]byte, randomValueBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,147 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yoshapihoff/bricks/auth/internal/oauth/oauthtest"
+)
+
+const testProvider = "test-oidc"
+
+func newTestManager(t *testing.T) (*Manager, *oauthtest.Issuer) {
+	t.Helper()
+
+	issuer := oauthtest.NewIssuer(t)
+	provider, err := NewOIDCProvider(context.Background(), ProviderConfig{
+		Name:         testProvider,
+		IssuerURL:    issuer.URL,
+		ClientID:     oauthtest.ClientID,
+		ClientSecret: oauthtest.ClientSecret,
+		RedirectURL:  "http://localhost/auth/oauth/test-oidc/callback",
+	})
+	if err != nil {
+		t.Fatalf("new oidc provider: %v", err)
+	}
+	return NewManager(NewMemoryStateStore(), time.Minute, provider), issuer
+}
+
+func verifiedGrant() oauthtest.Grant {
+	return oauthtest.Grant{
+		Subject:       "subject-1",
+		Email:         "user@example.com",
+		EmailVerified: true,
+		Name:          "Test User",
+	}
+}
+
+func TestOIDCFlowReturnsIdentity(t *testing.T) {
+	manager, issuer := newTestManager(t)
+
+	authURL, state, err := manager.Start(context.Background(), testProvider)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	code, returnedState := issuer.Authorize(t, authURL, verifiedGrant())
+	if returnedState != state {
+		t.Fatalf("state in authorization URL = %q, want %q", returnedState, state)
+	}
+
+	identity, err := manager.Finish(context.Background(), testProvider, state, code)
+	if err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	want := Identity{Subject: "subject-1", Email: "user@example.com", EmailVerified: true, Name: "Test User"}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+
+	// The state is consumed, replaying the callback fails
+	if _, err := manager.Finish(context.Background(), testProvider, state, code); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("replayed callback error = %v, want %v", err, ErrInvalidState)
+	}
+}
+
+func TestOIDCFlowRejectsTamperedResponses(t *testing.T) {
+	tests := []struct {
+		name string
+		// tamper changes the grant approved at the provider or the state the callback comes back with
+		tamper  func(t *testing.T, grant *oauthtest.Grant, state *string)
+		wantErr error
+		// wantText identifies errors that come from the oauth2 and oidc libraries
+		wantText string
+	}{
+		{
+			name: "PKCE verifier mismatch",
+			tamper: func(t *testing.T, grant *oauthtest.Grant, state *string) {
+				// A code issued for another client's challenge cannot be redeemed with our verifier
+				grant.CodeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+			},
+			wantText: "invalid_grant",
+		},
+		{
+			name: "state mismatch",
+			tamper: func(t *testing.T, grant *oauthtest.Grant, state *string) {
+				*state = "forged-state"
+			},
+			wantErr: ErrInvalidState,
+		},
+		{
+			name: "nonce mismatch",
+			tamper: func(t *testing.T, grant *oauthtest.Grant, state *string) {
+				grant.Nonce = "replayed-nonce"
+			},
+			wantErr: ErrInvalidNonce,
+		},
+		{
+			name: "bad id_token signature",
+			tamper: func(t *testing.T, grant *oauthtest.Grant, state *string) {
+				grant.SigningKey = oauthtest.NewKey(t)
+			},
+			wantText: "failed to verify signature",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, issuer := newTestManager(t)
+			authURL, state, err := manager.Start(context.Background(), testProvider)
+			if err != nil {
+				t.Fatalf("start: %v", err)
+			}
+
+			grant := verifiedGrant()
+			tt.tamper(t, &grant, &state)
+			code, _ := issuer.Authorize(t, authURL, grant)
+
+			identity, err := manager.Finish(context.Background(), testProvider, state, code)
+			if err == nil {
+				t.Fatalf("finish returned identity %+v, want an error", identity)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantText) {
+				t.Errorf("error = %v, want it to mention %q", err, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestOIDCFlowRejectsStateOfAnotherProvider(t *testing.T) {
+	manager, issuer := newTestManager(t)
+	manager.providers["other"] = manager.providers[testProvider]
+
+	authURL, state, err := manager.Start(context.Background(), "other")
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	code, _ := issuer.Authorize(t, authURL, verifiedGrant())
+
+	if _, err := manager.Finish(context.Background(), testProvider, state, code); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("error = %v, want %v", err, ErrInvalidState)
+	}
+}
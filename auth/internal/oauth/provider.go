@@ -0,0 +1,29 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown oauth provider")
+	ErrInvalidState    = errors.New("invalid oauth state")
+	ErrInvalidNonce    = errors.New("invalid oauth nonce")
+	ErrMissingIDToken  = errors.New("missing id token")
+)
+
+// Identity is the user as reported by an external provider
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type Provider interface {
+	Name() string
+	// AuthCodeURL builds the authorization URL with the PKCE challenge derived from verifier
+	AuthCodeURL(state, nonce, verifier string) string
+	// Exchange trades the authorization code for the user identity
+	Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error)
+}
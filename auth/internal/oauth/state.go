@@ -0,0 +1,96 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const stateKeyPrefix = "auth:oauth:state:"
+
+// Flow is the server side half of an authorization request
+type Flow struct {
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// StateStore keeps flows between the start and callback requests
+type StateStore interface {
+	Save(ctx context.Context, state string, flow *Flow, ttl time.Duration) error
+	// Consume returns the flow and removes it so a state can only be used once
+	Consume(ctx context.Context, state string) (*Flow, error)
+}
+
+type ValkeyStateStore struct {
+	client *redis.Client
+}
+
+func NewValkeyStateStore(client *redis.Client) *ValkeyStateStore {
+	return &ValkeyStateStore{client: client}
+}
+
+func (s *ValkeyStateStore) Save(ctx context.Context, state string, flow *Flow, ttl time.Duration) error {
+	data, err := json.Marshal(flow)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, stateKeyPrefix+state, data, ttl).Err()
+}
+
+func (s *ValkeyStateStore) Consume(ctx context.Context, state string) (*Flow, error) {
+	data, err := s.client.GetDel(ctx, stateKeyPrefix+state).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrInvalidState
+		}
+		return nil, err
+	}
+
+	var flow Flow
+	if err := json.Unmarshal(data, &flow); err != nil {
+		return nil, err
+	}
+	return &flow, nil
+}
+
+type memoryFlow struct {
+	flow      Flow
+	expiresAt time.Time
+}
+
+type MemoryStateStore struct {
+	mu    sync.Mutex
+	flows map[string]memoryFlow
+}
+
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{flows: make(map[string]memoryFlow)}
+}
+
+func (s *MemoryStateStore) Save(ctx context.Context, state string, flow *Flow, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.flows[state] = memoryFlow{flow: *flow, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStateStore) Consume(ctx context.Context, state string) (*Flow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.flows[state]
+	if !ok {
+		return nil, ErrInvalidState
+	}
+	delete(s.flows, state)
+	if time.Now().After(stored.expiresAt) {
+		return nil, ErrInvalidState
+	}
+	return &stored.flow, nil
+}
@@ -0,0 +1,178 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+	"github.com/yoshapihoff/bricks/auth/internal/kafka"
+	"github.com/yoshapihoff/bricks/auth/internal/repository"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// NewMessage serializes msg into an outbox row bound for topic
+func NewMessage(topic string, msg proto.Message) (*dto.OutboxMessage, error) {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return &dto.OutboxMessage{
+		Topic:       topic,
+		MessageType: string(msg.ProtoReflect().Descriptor().FullName()),
+		Payload:     payload,
+	}, nil
+}
+
+type RelayConfig struct {
+	Interval  time.Duration
+	BatchSize int
+	// MaxAttempts is the number of failed publishes after which a message is marked dead
+	MaxAttempts int
+	// RetryBackoff is the delay after the first failure, it doubles with every further one up to MaxRetryBackoff
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// Lease is how long a claimed batch is held back from other relays, the broker is waited on for half of it
+	Lease time.Duration
+}
+
+// Relay publishes pending outbox rows to Kafka. A row is only marked sent after the broker
+// acks it, so messages are delivered at least once.
+type Relay struct {
+	transactor repository.Transactor
+	repo       repository.OutboxRepository
+	producer   kafka.SRProducer
+	config     RelayConfig
+}
+
+func NewRelay(transactor repository.Transactor, repo repository.OutboxRepository, producer kafka.SRProducer, config RelayConfig) *Relay {
+	return &Relay{
+		transactor: transactor,
+		repo:       repo,
+		producer:   producer,
+		config:     config,
+	}
+}
+
+// Run relays pending messages every interval until ctx is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				sent, err := r.RelayBatch(ctx)
+				if err != nil {
+					log.Printf("Failed to relay outbox messages: %v", err)
+					break
+				}
+				// Keep draining while whole batches go through
+				if sent < r.config.BatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// RelayBatch publishes one batch of pending messages and returns how many were sent. The batch is claimed
+// with a lease, produced without holding a transaction or row locks, and each row is then marked by its own
+// delivery report in a second transaction.
+func (r *Relay) RelayBatch(ctx context.Context) (int, error) {
+	messages, err := r.repo.ClaimPending(ctx, r.config.BatchSize, time.Now().Add(r.config.Lease))
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		records     []kafka.Record
+		produced    []*dto.OutboxMessage
+		undecodable []*dto.OutboxMessage
+		decodeErrs  []error
+	)
+	for _, msg := range messages {
+		record, err := decode(msg)
+		if err != nil {
+			undecodable = append(undecodable, msg)
+			decodeErrs = append(decodeErrs, err)
+			continue
+		}
+		records = append(records, record)
+		produced = append(produced, msg)
+	}
+
+	var deliveryErrs []error
+	if len(records) > 0 {
+		// Waiting for half the lease leaves the other half to mark the rows before another relay may claim them
+		produceCtx, cancel := context.WithTimeout(ctx, r.config.Lease/2)
+		deliveryErrs = r.producer.ProduceBatch(produceCtx, records)
+		cancel()
+	}
+
+	// The outcome is recorded even when ctx is cancelled meanwhile, delivered rows would go out again otherwise
+	ctx = context.WithoutCancel(ctx)
+	var sent int
+	err = r.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		repo := r.repo.WithTx(tx)
+		for i, msg := range undecodable {
+			// A row that cannot be decoded never will be
+			if err := repo.MarkDead(ctx, msg.ID, decodeErrs[i].Error()); err != nil {
+				return err
+			}
+		}
+		for i, deliveryErr := range deliveryErrs {
+			if deliveryErr != nil {
+				if err := r.markFailed(ctx, repo, produced[i], deliveryErr); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := repo.MarkSent(ctx, produced[i].ID); err != nil {
+				return err
+			}
+			sent++
+		}
+		return nil
+	})
+	return sent, err
+}
+
+// markFailed backs the message off, or marks it dead once it used up its attempts. The claim already
+// counted the attempt that failed.
+func (r *Relay) markFailed(ctx context.Context, repo repository.OutboxRepository, msg *dto.OutboxMessage, cause error) error {
+	if msg.Attempts >= r.config.MaxAttempts {
+		log.Printf("Giving up on outbox message %s to %s after %d attempts: %v", msg.ID, msg.Topic, msg.Attempts, cause)
+		return repo.MarkDead(ctx, msg.ID, cause.Error())
+	}
+	return repo.MarkFailed(ctx, msg.ID, cause.Error(), time.Now().Add(r.backoff(msg.Attempts)))
+}
+
+// backoff returns the delay before the next attempt of a message that failed attempts times
+func (r *Relay) backoff(attempts int) time.Duration {
+	delay := r.config.RetryBackoff
+	for i := 1; i < attempts && delay < r.config.MaxRetryBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, r.config.MaxRetryBackoff)
+}
+
+// decode turns an outbox row back into the message it was created from
+func decode(msg *dto.OutboxMessage) (kafka.Record, error) {
+	messageType, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(msg.MessageType))
+	if err != nil {
+		return kafka.Record{}, fmt.Errorf("resolve %s: %w", msg.MessageType, err)
+	}
+	protoMsg := messageType.New().Interface()
+	if err := proto.Unmarshal(msg.Payload, protoMsg); err != nil {
+		return kafka.Record{}, err
+	}
+	return kafka.Record{Message: protoMsg, Topic: msg.Topic}, nil
+}
@@ -0,0 +1,255 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+	"github.com/yoshapihoff/bricks/auth/internal/kafka"
+	"github.com/yoshapihoff/bricks/auth/internal/repository"
+	sendEmail "github.com/yoshapihoff/bricks/auth/pkg/sendEmail.v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeTransactor runs fn without a transaction and tracks whether one would be open
+type fakeTransactor struct {
+	open bool
+}
+
+func (t *fakeTransactor) WithinTransaction(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	t.open = true
+	defer func() { t.open = false }()
+	return fn(nil)
+}
+
+// fakeOutboxRepository keeps rows in memory and records how each one was marked
+type fakeOutboxRepository struct {
+	pending    []*dto.OutboxMessage
+	leaseUntil time.Time
+	sent       []uuid.UUID
+	failed     map[uuid.UUID]time.Time
+	dead       map[uuid.UUID]string
+}
+
+func newFakeOutboxRepository() *fakeOutboxRepository {
+	return &fakeOutboxRepository{
+		failed: make(map[uuid.UUID]time.Time),
+		dead:   make(map[uuid.UUID]string),
+	}
+}
+
+func (r *fakeOutboxRepository) Create(ctx context.Context, msg *dto.OutboxMessage) error {
+	msg.ID = uuid.New()
+	r.pending = append(r.pending, msg)
+	return nil
+}
+
+func (r *fakeOutboxRepository) ClaimPending(ctx context.Context, limit int, leaseUntil time.Time) ([]*dto.OutboxMessage, error) {
+	r.leaseUntil = leaseUntil
+	claimed := r.pending[:min(limit, len(r.pending))]
+	for _, msg := range claimed {
+		msg.Attempts++
+		msg.NextAttemptAt = leaseUntil
+	}
+	return claimed, nil
+}
+
+func (r *fakeOutboxRepository) MarkSent(ctx context.Context, id uuid.UUID) error {
+	r.sent = append(r.sent, id)
+	return nil
+}
+
+func (r *fakeOutboxRepository) MarkFailed(ctx context.Context, id uuid.UUID, cause string, nextAttemptAt time.Time) error {
+	r.failed[id] = nextAttemptAt
+	return nil
+}
+
+func (r *fakeOutboxRepository) MarkDead(ctx context.Context, id uuid.UUID, cause string) error {
+	r.dead[id] = cause
+	return nil
+}
+
+func (r *fakeOutboxRepository) DeleteSentBefore(ctx context.Context, before time.Time) error {
+	return nil
+}
+
+func (r *fakeOutboxRepository) WithTx(tx *sql.Tx) repository.OutboxRepository {
+	return r
+}
+
+// fakeProducer rejects records bound for the topics in reject and remembers how the last batch was produced
+type fakeProducer struct {
+	batches    [][]kafka.Record
+	reject     map[string]error
+	transactor *fakeTransactor
+	inTx       bool
+	deadline   time.Time
+}
+
+func (p *fakeProducer) ProduceMessage(msg proto.Message, topic string) (int64, error) {
+	errs := p.ProduceBatch(context.Background(), []kafka.Record{{Message: msg, Topic: topic}})
+	return 0, errs[0]
+}
+
+func (p *fakeProducer) ProduceBatch(ctx context.Context, records []kafka.Record) []error {
+	p.batches = append(p.batches, records)
+	p.inTx = p.transactor != nil && p.transactor.open
+	p.deadline, _ = ctx.Deadline()
+	errs := make([]error, len(records))
+	for i, record := range records {
+		errs[i] = p.reject[record.Topic]
+	}
+	return errs
+}
+
+func (p *fakeProducer) ProduceMessageAsync(msg proto.Message, topic string) error {
+	p.ProduceBatch(context.Background(), []kafka.Record{{Message: msg, Topic: topic}})
+	return nil
+}
+
+func (p *fakeProducer) Stats() kafka.ProducerStats {
+	return kafka.ProducerStats{}
+}
+
+func (p *fakeProducer) Close() {}
+
+func TestRelayBatchProducesTheBatchAtOnceAndMarksEachRow(t *testing.T) {
+	repo := newFakeOutboxRepository()
+	transactor := &fakeTransactor{}
+	producer := &fakeProducer{reject: map[string]error{"broken-topic": errors.New("unknown topic")}, transactor: transactor}
+	relay := NewRelay(transactor, repo, producer, testRelayConfig())
+
+	for _, topic := range []string{"verify-email", "broken-topic", "magic-link"} {
+		msg, err := NewMessage(topic, &sendEmail.SendEmail{To: []string{"user@example.com"}, Template: topic})
+		if err != nil {
+			t.Fatalf("new message: %v", err)
+		}
+		if err := repo.Create(context.Background(), msg); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	undecodable := &dto.OutboxMessage{Topic: "verify-email", MessageType: "no.such.Message"}
+	if err := repo.Create(context.Background(), undecodable); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	sent, err := relay.RelayBatch(context.Background())
+	if err != nil {
+		t.Fatalf("relay batch: %v", err)
+	}
+
+	if sent != 2 || len(repo.sent) != 2 {
+		t.Errorf("sent = %d (%d marked), want 2", sent, len(repo.sent))
+	}
+	if len(producer.batches) != 1 || len(producer.batches[0]) != 3 {
+		t.Fatalf("batches = %v, want one batch of 3 records", producer.batches)
+	}
+	if _, ok := repo.failed[repo.pending[1].ID]; !ok {
+		t.Error("rejected record was not marked failed")
+	}
+	if _, ok := repo.dead[undecodable.ID]; !ok {
+		t.Error("undecodable row was not marked dead")
+	}
+}
+
+func TestRelayBatchProducesOutsideATransactionWithinTheLease(t *testing.T) {
+	repo := newFakeOutboxRepository()
+	transactor := &fakeTransactor{}
+	producer := &fakeProducer{transactor: transactor}
+	config := testRelayConfig()
+	relay := NewRelay(transactor, repo, producer, config)
+
+	msg, err := NewMessage("verify-email", &sendEmail.SendEmail{To: []string{"user@example.com"}})
+	if err != nil {
+		t.Fatalf("new message: %v", err)
+	}
+	if err := repo.Create(context.Background(), msg); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	before := time.Now()
+	if _, err := relay.RelayBatch(context.Background()); err != nil {
+		t.Fatalf("relay batch: %v", err)
+	}
+
+	if producer.inTx {
+		t.Error("batch was produced inside a transaction")
+	}
+	if msg.Attempts != 1 {
+		t.Errorf("attempts = %d, want the claim to count one", msg.Attempts)
+	}
+	if lease := repo.leaseUntil.Sub(before); lease < config.Lease || lease > config.Lease+time.Second {
+		t.Errorf("lease = %s, want %s", lease, config.Lease)
+	}
+	if producer.deadline.IsZero() || producer.deadline.After(repo.leaseUntil.Add(time.Second)) {
+		t.Errorf("produce deadline = %v, want one within the lease ending %v", producer.deadline, repo.leaseUntil)
+	}
+	if len(repo.sent) != 1 {
+		t.Errorf("%d rows marked sent, want 1", len(repo.sent))
+	}
+}
+
+func TestRelayBatchBacksOffAndMarksDeadAfterMaxAttempts(t *testing.T) {
+	tests := []struct {
+		name        string
+		attempts    int
+		wantBackoff time.Duration
+		wantDead    bool
+	}{
+		{name: "first failure", attempts: 0, wantBackoff: time.Second},
+		{name: "third failure", attempts: 2, wantBackoff: 4 * time.Second},
+		{name: "backoff is capped", attempts: 3, wantBackoff: 5 * time.Second},
+		{name: "last attempt", attempts: 4, wantDead: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeOutboxRepository()
+			producer := &fakeProducer{reject: map[string]error{"verify-email": errors.New("broker down")}}
+			relay := NewRelay(&fakeTransactor{}, repo, producer, testRelayConfig())
+
+			msg, err := NewMessage("verify-email", &sendEmail.SendEmail{To: []string{"user@example.com"}})
+			if err != nil {
+				t.Fatalf("new message: %v", err)
+			}
+			msg.Attempts = tt.attempts
+			if err := repo.Create(context.Background(), msg); err != nil {
+				t.Fatalf("create: %v", err)
+			}
+
+			before := time.Now()
+			if _, err := relay.RelayBatch(context.Background()); err != nil {
+				t.Fatalf("relay batch: %v", err)
+			}
+
+			if _, dead := repo.dead[msg.ID]; dead != tt.wantDead {
+				t.Fatalf("dead = %v, want %v", dead, tt.wantDead)
+			}
+			if tt.wantDead {
+				return
+			}
+			nextAttemptAt, ok := repo.failed[msg.ID]
+			if !ok {
+				t.Fatal("message was not marked failed")
+			}
+			if backoff := nextAttemptAt.Sub(before); backoff < tt.wantBackoff || backoff > tt.wantBackoff+time.Second {
+				t.Errorf("backoff = %s, want %s", backoff, tt.wantBackoff)
+			}
+		})
+	}
+}
+
+func testRelayConfig() RelayConfig {
+	return RelayConfig{
+		Interval:        time.Second,
+		BatchSize:       10,
+		MaxAttempts:     5,
+		RetryBackoff:    time.Second,
+		MaxRetryBackoff: 5 * time.Second,
+		Lease:           30 * time.Second,
+	}
+}
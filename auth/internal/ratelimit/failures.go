@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// FailureCounter counts consecutive failures per key and locks the key once enough add up
+type FailureCounter interface {
+	// LockedFor returns how long key stays locked, zero when it is not locked
+	LockedFor(ctx context.Context, key string) (time.Duration, error)
+	// RecordFailure counts a failure for key and locks it for lockDuration once threshold failures add up,
+	// the count then starts over. Failures are forgotten ttl after the last one. It returns the failures
+	// counted so far and how long key is locked.
+	RecordFailure(ctx context.Context, key string, threshold int, lockDuration, ttl time.Duration) (int, time.Duration, error)
+	// Reset forgets the failures and lifts the lock of key
+	Reset(ctx context.Context, key string) error
+}
+
+type failureState struct {
+	failures    int
+	expiresAt   time.Time
+	lockedUntil time.Time
+}
+
+// MemoryFailureCounter keeps the counts in process, each replica counts on its own
+type MemoryFailureCounter struct {
+	mu     sync.Mutex
+	states map[string]*failureState
+	calls  int
+}
+
+func NewMemoryFailureCounter() *MemoryFailureCounter {
+	return &MemoryFailureCounter{
+		states: make(map[string]*failureState),
+	}
+}
+
+func (c *MemoryFailureCounter) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state, ok := c.states[key]
+	if !ok {
+		return 0, nil
+	}
+	return max(time.Until(state.lockedUntil), 0), nil
+}
+
+func (c *MemoryFailureCounter) RecordFailure(ctx context.Context, key string, threshold int, lockDuration, ttl time.Duration) (int, time.Duration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.calls++
+	if c.calls%sweepEvery == 0 {
+		c.sweep(now)
+	}
+
+	state, ok := c.states[key]
+	if !ok {
+		state = &failureState{}
+		c.states[key] = state
+	}
+	if now.After(state.expiresAt) {
+		state.failures = 0
+	}
+	state.failures++
+	state.expiresAt = now.Add(ttl)
+
+	failures := state.failures
+	if state.failures >= threshold {
+		state.failures = 0
+		state.lockedUntil = now.Add(lockDuration)
+	}
+	return failures, max(state.lockedUntil.Sub(now), 0), nil
+}
+
+func (c *MemoryFailureCounter) Reset(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.states, key)
+	return nil
+}
+
+// sweep drops keys whose failures were forgotten and whose lock ran out
+func (c *MemoryFailureCounter) sweep(now time.Time) {
+	for key, state := range c.states {
+		if now.After(state.expiresAt) && now.After(state.lockedUntil) {
+			delete(c.states, key)
+		}
+	}
+}
+
+// FallbackFailureCounter uses the primary counter and switches to the fallback for calls where the primary fails
+type FallbackFailureCounter struct {
+	primary  FailureCounter
+	fallback FailureCounter
+}
+
+func NewFallbackFailureCounter(primary, fallback FailureCounter) *FallbackFailureCounter {
+	return &FallbackFailureCounter{
+		primary:  primary,
+		fallback: fallback,
+	}
+}
+
+func (c *FallbackFailureCounter) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	lockedFor, err := c.primary.LockedFor(ctx, key)
+	if err == nil {
+		return lockedFor, nil
+	}
+	log.Printf("Failure counter failed, falling back: %v", err)
+	return c.fallback.LockedFor(ctx, key)
+}
+
+func (c *FallbackFailureCounter) RecordFailure(ctx context.Context, key string, threshold int, lockDuration, ttl time.Duration) (int, time.Duration, error) {
+	failures, lockedFor, err := c.primary.RecordFailure(ctx, key, threshold, lockDuration, ttl)
+	if err == nil {
+		return failures, lockedFor, nil
+	}
+	log.Printf("Failure counter failed, falling back: %v", err)
+	return c.fallback.RecordFailure(ctx, key, threshold, lockDuration, ttl)
+}
+
+// Reset clears both counters, a failure may have been recorded in the fallback while the primary was down
+func (c *FallbackFailureCounter) Reset(ctx context.Context, key string) error {
+	if err := c.fallback.Reset(ctx, key); err != nil {
+		return err
+	}
+	if err := c.primary.Reset(ctx, key); err != nil {
+		log.Printf("Failure counter failed to reset %s: %v", key, err)
+	}
+	return nil
+}
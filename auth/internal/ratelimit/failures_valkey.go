@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	failuresKeyPrefix = "auth:failures:"
+	lockKeyPrefix     = "auth:lock:"
+)
+
+// recordFailureScript counts the failure in KEYS[1] and sets the lock in KEYS[2] once the threshold is reached.
+// It returns the failures counted and the milliseconds the key is locked for.
+var recordFailureScript = redis.NewScript(`
+local threshold = tonumber(ARGV[1])
+local failures = redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+if failures >= threshold then
+	redis.call('DEL', KEYS[1])
+	redis.call('SET', KEYS[2], 1, 'PX', ARGV[2])
+end
+local locked = redis.call('PTTL', KEYS[2])
+if locked < 0 then
+	locked = 0
+end
+return {failures, locked}
+`)
+
+// ValkeyFailureCounter shares the counts and locks between replicas
+type ValkeyFailureCounter struct {
+	client *redis.Client
+}
+
+func NewValkeyFailureCounter(client *redis.Client) *ValkeyFailureCounter {
+	return &ValkeyFailureCounter{client: client}
+}
+
+func (c *ValkeyFailureCounter) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := c.client.PTTL(ctx, lockKeyPrefix+key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// PTTL reports a missing key or one without expiry as a negative duration
+	return max(ttl, 0), nil
+}
+
+func (c *ValkeyFailureCounter) RecordFailure(ctx context.Context, key string, threshold int, lockDuration, ttl time.Duration) (int, time.Duration, error) {
+	result, err := recordFailureScript.Run(ctx, c.client, []string{failuresKeyPrefix + key, lockKeyPrefix + key},
+		threshold,
+		lockDuration.Milliseconds(),
+		ttl.Milliseconds(),
+	).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(result[0]), time.Duration(result[1]) * time.Millisecond, nil
+}
+
+func (c *ValkeyFailureCounter) Reset(ctx context.Context, key string) error {
+	return c.client.Del(ctx, failuresKeyPrefix+key, lockKeyPrefix+key).Err()
+}
@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Result tells whether an attempt is allowed and, if not, when the next one will be
+type Result struct {
+	Allowed    bool
+	RetryAfter time.Duration
+}
+
+// Limiter counts attempts per key over a sliding window
+type Limiter interface {
+	// Allow records an attempt for key unless limit attempts were already made within window.
+	// A limit of zero or less allows every attempt.
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (Result, error)
+}
+
+// sweepEvery is how many calls the memory limiter serves between sweeps of idle keys
+const sweepEvery = 1024
+
+// MemoryLimiter keeps the windows in process, each replica counts on its own
+type MemoryLimiter struct {
+	mu       sync.Mutex
+	attempts map[string][]time.Time
+	calls    int
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		attempts: make(map[string][]time.Time),
+	}
+}
+
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (Result, error) {
+	if limit <= 0 {
+		return Result{Allowed: true}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.calls++
+	if l.calls%sweepEvery == 0 {
+		l.sweep(now, window)
+	}
+
+	attempts := prune(l.attempts[key], now.Add(-window))
+	if len(attempts) >= limit {
+		l.attempts[key] = attempts
+		return Result{RetryAfter: attempts[0].Add(window).Sub(now)}, nil
+	}
+
+	l.attempts[key] = append(attempts, now)
+	return Result{Allowed: true}, nil
+}
+
+// sweep drops keys whose attempts all fell out of the window
+func (l *MemoryLimiter) sweep(now time.Time, window time.Duration) {
+	for key, attempts := range l.attempts {
+		if len(prune(attempts, now.Add(-window))) == 0 {
+			delete(l.attempts, key)
+		}
+	}
+}
+
+// prune drops the attempts made before since, attempts are kept oldest first
+func prune(attempts []time.Time, since time.Time) []time.Time {
+	i := 0
+	for i < len(attempts) && !attempts[i].After(since) {
+		i++
+	}
+	return attempts[i:]
+}
+
+// FallbackLimiter uses the primary limiter and switches to the fallback for calls where the primary fails
+type FallbackLimiter struct {
+	primary  Limiter
+	fallback Limiter
+}
+
+func NewFallbackLimiter(primary, fallback Limiter) *FallbackLimiter {
+	return &FallbackLimiter{
+		primary:  primary,
+		fallback: fallback,
+	}
+}
+
+func (l *FallbackLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (Result, error) {
+	result, err := l.primary.Allow(ctx, key, limit, window)
+	if err == nil {
+		return result, nil
+	}
+	log.Printf("Rate limiter failed, falling back: %v", err)
+	return l.fallback.Allow(ctx, key, limit, window)
+}
@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const keyPrefix = "auth:ratelimit:"
+
+// slidingWindowScript keeps one sorted set member per attempt scored by its time in milliseconds.
+// It returns 0 when the attempt is recorded and the milliseconds until a slot frees up otherwise.
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+if redis.call('ZCARD', KEYS[1]) < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	redis.call('PEXPIRE', KEYS[1], window)
+	return 0
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return tonumber(oldest[2]) + window - now
+`)
+
+// ValkeyLimiter shares the windows between replicas
+type ValkeyLimiter struct {
+	client *redis.Client
+}
+
+func NewValkeyLimiter(client *redis.Client) *ValkeyLimiter {
+	return &ValkeyLimiter{client: client}
+}
+
+func (l *ValkeyLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (Result, error) {
+	if limit <= 0 {
+		return Result{Allowed: true}, nil
+	}
+
+	retryAfter, err := slidingWindowScript.Run(ctx, l.client, []string{keyPrefix + key},
+		time.Now().UnixMilli(),
+		window.Milliseconds(),
+		limit,
+		uuid.NewString(),
+	).Int64()
+	if err != nil {
+		return Result{}, err
+	}
+	if retryAfter > 0 {
+		return Result{RetryAfter: time.Duration(retryAfter) * time.Millisecond}, nil
+	}
+
+	return Result{Allowed: true}, nil
+}
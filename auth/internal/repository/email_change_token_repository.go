@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+)
+
+type EmailChangeTokenRepository interface {
+	Create(ctx context.Context, token *dto.EmailChangeToken) error
+	FindByHash(ctx context.Context, tokenHash string) (*dto.EmailChangeToken, error)
+	DeleteByUserID(ctx context.Context, userID uuid.UUID) error
+	DeleteExpired(ctx context.Context, now time.Time) error
+	WithTx(tx *sql.Tx) EmailChangeTokenRepository
+}
+
+type DefaultEmailChangeTokenRepository struct {
+	db DBTX
+}
+
+func NewEmailChangeTokenRepository(db *sql.DB) *DefaultEmailChangeTokenRepository {
+	return &DefaultEmailChangeTokenRepository{db: db}
+}
+
+// WithTx returns a repository that runs its queries inside tx
+func (r *DefaultEmailChangeTokenRepository) WithTx(tx *sql.Tx) EmailChangeTokenRepository {
+	return &DefaultEmailChangeTokenRepository{db: tx}
+}
+
+func (r *DefaultEmailChangeTokenRepository) Create(ctx context.Context, token *dto.EmailChangeToken) error {
+	query := `
+		INSERT INTO email_change_tokens (id, user_id, new_email, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+
+	err := r.db.QueryRowContext(
+		ctx,
+		query,
+		uuid.New(),
+		token.UserID,
+		token.NewEmail,
+		token.TokenHash,
+		token.ExpiresAt,
+		time.Now(),
+	).Scan(&token.ID, &token.CreatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *DefaultEmailChangeTokenRepository) FindByHash(ctx context.Context, tokenHash string) (*dto.EmailChangeToken, error) {
+	query := `
+		SELECT id, user_id, new_email, token_hash, expires_at, created_at
+		FROM email_change_tokens
+		WHERE token_hash = $1
+	`
+
+	var token dto.EmailChangeToken
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.NewEmail,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (r *DefaultEmailChangeTokenRepository) DeleteByUserID(ctx context.Context, userID uuid.UUID) error {
+	query := `DELETE FROM email_change_tokens WHERE user_id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+func (r *DefaultEmailChangeTokenRepository) DeleteExpired(ctx context.Context, now time.Time) error {
+	query := `DELETE FROM email_change_tokens WHERE expires_at < $1`
+
+	_, err := r.db.ExecContext(ctx, query, now)
+	return err
+}
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+)
+
+type EmailVerificationTokenRepository interface {
+	Create(ctx context.Context, token *dto.EmailVerificationToken) error
+	FindByHash(ctx context.Context, tokenHash string) (*dto.EmailVerificationToken, error)
+	DeleteByUserID(ctx context.Context, userID uuid.UUID) error
+	DeleteExpired(ctx context.Context, now time.Time) error
+	WithTx(tx *sql.Tx) EmailVerificationTokenRepository
+}
+
+type DefaultEmailVerificationTokenRepository struct {
+	db DBTX
+}
+
+func NewEmailVerificationTokenRepository(db *sql.DB) *DefaultEmailVerificationTokenRepository {
+	return &DefaultEmailVerificationTokenRepository{db: db}
+}
+
+// WithTx returns a repository that runs its queries inside tx
+func (r *DefaultEmailVerificationTokenRepository) WithTx(tx *sql.Tx) EmailVerificationTokenRepository {
+	return &DefaultEmailVerificationTokenRepository{db: tx}
+}
+
+func (r *DefaultEmailVerificationTokenRepository) Create(ctx context.Context, token *dto.EmailVerificationToken) error {
+	query := `
+		INSERT INTO email_verification_tokens (id, user_id, email, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+
+	err := r.db.QueryRowContext(
+		ctx,
+		query,
+		uuid.New(),
+		token.UserID,
+		token.Email,
+		token.TokenHash,
+		token.ExpiresAt,
+		time.Now(),
+	).Scan(&token.ID, &token.CreatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *DefaultEmailVerificationTokenRepository) FindByHash(ctx context.Context, tokenHash string) (*dto.EmailVerificationToken, error) {
+	query := `
+		SELECT id, user_id, email, token_hash, expires_at, created_at
+		FROM email_verification_tokens
+		WHERE token_hash = $1
+	`
+
+	var token dto.EmailVerificationToken
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Email,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (r *DefaultEmailVerificationTokenRepository) DeleteByUserID(ctx context.Context, userID uuid.UUID) error {
+	query := `DELETE FROM email_verification_tokens WHERE user_id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+func (r *DefaultEmailVerificationTokenRepository) DeleteExpired(ctx context.Context, now time.Time) error {
+	query := `DELETE FROM email_verification_tokens WHERE expires_at < $1`
+
+	_, err := r.db.ExecContext(ctx, query, now)
+	return err
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+)
+
+type MagicLinkTokenRepository interface {
+	Create(ctx context.Context, token *dto.MagicLinkToken) error
+	// Delete removes the token with the given hash and returns it, a token can only be deleted once
+	Delete(ctx context.Context, tokenHash string) (*dto.MagicLinkToken, error)
+	DeleteByUserID(ctx context.Context, userID uuid.UUID) error
+	ClearFromOld(ctx context.Context, olderThan time.Time) error
+	WithTx(tx *sql.Tx) MagicLinkTokenRepository
+}
+
+type DefaultMagicLinkTokenRepository struct {
+	db DBTX
+}
+
+func NewMagicLinkTokenRepository(db *sql.DB) *DefaultMagicLinkTokenRepository {
+	return &DefaultMagicLinkTokenRepository{db: db}
+}
+
+// WithTx returns a repository that runs its queries inside tx
+func (r *DefaultMagicLinkTokenRepository) WithTx(tx *sql.Tx) MagicLinkTokenRepository {
+	return &DefaultMagicLinkTokenRepository{db: tx}
+}
+
+func (r *DefaultMagicLinkTokenRepository) Create(ctx context.Context, token *dto.MagicLinkToken) error {
+	query := `
+		INSERT INTO magic_link_tokens (id, user_id, token_hash, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	err := r.db.QueryRowContext(
+		ctx,
+		query,
+		uuid.New(),
+		token.UserID,
+		token.TokenHash,
+		time.Now(),
+	).Scan(
+		&token.ID,
+		&token.CreatedAt,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *DefaultMagicLinkTokenRepository) Delete(ctx context.Context, tokenHash string) (*dto.MagicLinkToken, error) {
+	query := `
+		DELETE FROM magic_link_tokens
+		WHERE token_hash = $1
+		RETURNING id, user_id, token_hash, created_at
+	`
+
+	var token dto.MagicLinkToken
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.TokenHash,
+		&token.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (r *DefaultMagicLinkTokenRepository) DeleteByUserID(ctx context.Context, userID uuid.UUID) error {
+	query := `DELETE FROM magic_link_tokens WHERE user_id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+func (r *DefaultMagicLinkTokenRepository) ClearFromOld(ctx context.Context, olderThan time.Time) error {
+	query := `
+		DELETE FROM magic_link_tokens
+		WHERE created_at < $1
+	`
+	_, err := r.db.ExecContext(ctx, query, olderThan)
+	return err
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+)
+
+type MFAChallengeRepository interface {
+	Create(ctx context.Context, challenge *dto.MFAChallenge) error
+	FindByHash(ctx context.Context, tokenHash string) (*dto.MFAChallenge, error)
+	// RecordAttempt counts an attempt at the challenge and returns the attempts so far including this one,
+	// it returns sql.ErrNoRows when the challenge is gone
+	RecordAttempt(ctx context.Context, id uuid.UUID) (int, error)
+	// Delete removes the challenge and reports whether it was still there
+	Delete(ctx context.Context, id uuid.UUID) (bool, error)
+	DeleteExpired(ctx context.Context, now time.Time) error
+	WithTx(tx *sql.Tx) MFAChallengeRepository
+}
+
+type DefaultMFAChallengeRepository struct {
+	db DBTX
+}
+
+func NewMFAChallengeRepository(db *sql.DB) *DefaultMFAChallengeRepository {
+	return &DefaultMFAChallengeRepository{db: db}
+}
+
+// WithTx returns a repository that runs its queries inside tx
+func (r *DefaultMFAChallengeRepository) WithTx(tx *sql.Tx) MFAChallengeRepository {
+	return &DefaultMFAChallengeRepository{db: tx}
+}
+
+func (r *DefaultMFAChallengeRepository) Create(ctx context.Context, challenge *dto.MFAChallenge) error {
+	query := `
+		INSERT INTO mfa_challenges (id, user_id, token_hash, attempts, expires_at, created_at)
+		VALUES ($1, $2, $3, 0, $4, $5)
+		RETURNING id, created_at
+	`
+
+	err := r.db.QueryRowContext(
+		ctx,
+		query,
+		uuid.New(),
+		challenge.UserID,
+		challenge.TokenHash,
+		challenge.ExpiresAt,
+		time.Now(),
+	).Scan(&challenge.ID, &challenge.CreatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *DefaultMFAChallengeRepository) FindByHash(ctx context.Context, tokenHash string) (*dto.MFAChallenge, error) {
+	query := `
+		SELECT id, user_id, token_hash, attempts, expires_at, created_at
+		FROM mfa_challenges
+		WHERE token_hash = $1
+	`
+
+	var challenge dto.MFAChallenge
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&challenge.ID,
+		&challenge.UserID,
+		&challenge.TokenHash,
+		&challenge.Attempts,
+		&challenge.ExpiresAt,
+		&challenge.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &challenge, nil
+}
+
+func (r *DefaultMFAChallengeRepository) RecordAttempt(ctx context.Context, id uuid.UUID) (int, error) {
+	query := `UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = $1 RETURNING attempts`
+
+	var attempts int
+	if err := r.db.QueryRowContext(ctx, query, id).Scan(&attempts); err != nil {
+		return 0, err
+	}
+	return attempts, nil
+}
+
+func (r *DefaultMFAChallengeRepository) Delete(ctx context.Context, id uuid.UUID) (bool, error) {
+	query := `DELETE FROM mfa_challenges WHERE id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+func (r *DefaultMFAChallengeRepository) DeleteExpired(ctx context.Context, now time.Time) error {
+	query := `DELETE FROM mfa_challenges WHERE expires_at < $1`
+
+	_, err := r.db.ExecContext(ctx, query, now)
+	return err
+}
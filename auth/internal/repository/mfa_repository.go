@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+)
+
+type MFARepository interface {
+	// SaveTOTP stores a pending enrollment, replacing a pending one. It returns sql.ErrNoRows when a confirmed one exists.
+	SaveTOTP(ctx context.Context, totp *dto.UserTOTP) error
+	FindTOTP(ctx context.Context, userID uuid.UUID) (*dto.UserTOTP, error)
+	ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64) error
+	// UseTOTPStep records step as used and reports false when it or a later step was used already
+	UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error)
+	DeleteTOTP(ctx context.Context, userID uuid.UUID) error
+	// ReplaceRecoveryCodes drops the user's recovery codes and stores the given hashes instead
+	ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error
+	// UseRecoveryCode deletes the code and reports whether it existed
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error)
+	DeleteRecoveryCodes(ctx context.Context, userID uuid.UUID) error
+	WithTx(tx *sql.Tx) MFARepository
+}
+
+type DefaultMFARepository struct {
+	db DBTX
+}
+
+func NewMFARepository(db *sql.DB) *DefaultMFARepository {
+	return &DefaultMFARepository{db: db}
+}
+
+// WithTx returns a repository that runs its queries inside tx
+func (r *DefaultMFARepository) WithTx(tx *sql.Tx) MFARepository {
+	return &DefaultMFARepository{db: tx}
+}
+
+func (r *DefaultMFARepository) SaveTOTP(ctx context.Context, totp *dto.UserTOTP) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret, last_used_step, confirmed_at, created_at)
+		VALUES ($1, $2, 0, NULL, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = 0, confirmed_at = NULL, created_at = EXCLUDED.created_at
+		WHERE user_totp.confirmed_at IS NULL
+		RETURNING created_at
+	`
+
+	err := r.db.QueryRowContext(ctx, query, totp.UserID, totp.Secret, time.Now()).Scan(&totp.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	totp.LastUsedStep = 0
+	totp.ConfirmedAt = nil
+	return nil
+}
+
+func (r *DefaultMFARepository) FindTOTP(ctx context.Context, userID uuid.UUID) (*dto.UserTOTP, error) {
+	query := `
+		SELECT user_id, secret, last_used_step, confirmed_at, created_at
+		FROM user_totp
+		WHERE user_id = $1
+	`
+
+	var totp dto.UserTOTP
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.LastUsedStep,
+		&totp.ConfirmedAt,
+		&totp.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &totp, nil
+}
+
+func (r *DefaultMFARepository) ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64) error {
+	query := `UPDATE user_totp SET confirmed_at = $2, last_used_step = $3 WHERE user_id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, userID, time.Now(), step)
+	return err
+}
+
+func (r *DefaultMFARepository) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	query := `UPDATE user_totp SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2`
+
+	result, err := r.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+func (r *DefaultMFARepository) DeleteTOTP(ctx context.Context, userID uuid.UUID) error {
+	query := `DELETE FROM user_totp WHERE user_id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+func (r *DefaultMFARepository) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	if err := r.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO mfa_recovery_codes (id, user_id, code_hash, created_at)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	now := time.Now()
+	for _, codeHash := range codeHashes {
+		if _, err := r.db.ExecContext(ctx, query, uuid.New(), userID, codeHash, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *DefaultMFARepository) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error) {
+	query := `DELETE FROM mfa_recovery_codes WHERE user_id = $1 AND code_hash = $2`
+
+	result, err := r.db.ExecContext(ctx, query, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+func (r *DefaultMFARepository) DeleteRecoveryCodes(ctx context.Context, userID uuid.UUID) error {
+	query := `DELETE FROM mfa_recovery_codes WHERE user_id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, userID)
+	return err
+}
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+)
+
+type OutboxRepository interface {
+	Create(ctx context.Context, msg *dto.OutboxMessage) error
+	// ClaimPending counts an attempt on up to limit unsent messages that are neither dead nor backing off and
+	// holds them back until leaseUntil, so the claim commits on its own and no lock outlives the statement.
+	// A relay that dies before marking them leaves them to be claimed again once the lease ran out.
+	ClaimPending(ctx context.Context, limit int, leaseUntil time.Time) ([]*dto.OutboxMessage, error)
+	MarkSent(ctx context.Context, id uuid.UUID) error
+	// MarkFailed records why the claimed attempt failed and holds the message back until nextAttemptAt
+	MarkFailed(ctx context.Context, id uuid.UUID, cause string, nextAttemptAt time.Time) error
+	// MarkDead records why the claimed attempt failed and stops relaying the message
+	MarkDead(ctx context.Context, id uuid.UUID, cause string) error
+	DeleteSentBefore(ctx context.Context, before time.Time) error
+	WithTx(tx *sql.Tx) OutboxRepository
+}
+
+type DefaultOutboxRepository struct {
+	db DBTX
+}
+
+func NewOutboxRepository(db *sql.DB) *DefaultOutboxRepository {
+	return &DefaultOutboxRepository{db: db}
+}
+
+// WithTx returns a repository that runs its queries inside tx
+func (r *DefaultOutboxRepository) WithTx(tx *sql.Tx) OutboxRepository {
+	return &DefaultOutboxRepository{db: tx}
+}
+
+func (r *DefaultOutboxRepository) Create(ctx context.Context, msg *dto.OutboxMessage) error {
+	query := `
+		INSERT INTO outbox (id, topic, message_type, payload, attempts, created_at, next_attempt_at)
+		VALUES ($1, $2, $3, $4, 0, $5, $5)
+		RETURNING id, created_at, next_attempt_at
+	`
+
+	err := r.db.QueryRowContext(
+		ctx,
+		query,
+		uuid.New(),
+		msg.Topic,
+		msg.MessageType,
+		msg.Payload,
+		time.Now(),
+	).Scan(&msg.ID, &msg.CreatedAt, &msg.NextAttemptAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *DefaultOutboxRepository) ClaimPending(ctx context.Context, limit int, leaseUntil time.Time) ([]*dto.OutboxMessage, error) {
+	query := `
+		UPDATE outbox SET attempts = attempts + 1, next_attempt_at = $3
+		WHERE id IN (
+			SELECT id
+			FROM outbox
+			WHERE sent_at IS NULL AND dead_at IS NULL AND next_attempt_at <= $2
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, topic, message_type, payload, attempts, COALESCE(last_error, ''), created_at, next_attempt_at
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, limit, time.Now(), leaseUntil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*dto.OutboxMessage
+	for rows.Next() {
+		var msg dto.OutboxMessage
+		if err := rows.Scan(
+			&msg.ID,
+			&msg.Topic,
+			&msg.MessageType,
+			&msg.Payload,
+			&msg.Attempts,
+			&msg.LastError,
+			&msg.CreatedAt,
+			&msg.NextAttemptAt,
+		); err != nil {
+			return nil, err
+		}
+		messages = append(messages, &msg)
+	}
+
+	return messages, rows.Err()
+}
+
+func (r *DefaultOutboxRepository) MarkSent(ctx context.Context, id uuid.UUID) error {
+	query := `UPDATE outbox SET sent_at = $2 WHERE id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, id, time.Now())
+	return err
+}
+
+func (r *DefaultOutboxRepository) MarkFailed(ctx context.Context, id uuid.UUID, cause string, nextAttemptAt time.Time) error {
+	query := `UPDATE outbox SET last_error = $2, next_attempt_at = $3 WHERE id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, id, cause, nextAttemptAt)
+	return err
+}
+
+func (r *DefaultOutboxRepository) MarkDead(ctx context.Context, id uuid.UUID, cause string) error {
+	query := `UPDATE outbox SET last_error = $2, dead_at = $3 WHERE id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, id, cause, time.Now())
+	return err
+}
+
+func (r *DefaultOutboxRepository) DeleteSentBefore(ctx context.Context, before time.Time) error {
+	query := `DELETE FROM outbox WHERE sent_at < $1`
+
+	_, err := r.db.ExecContext(ctx, query, before)
+	return err
+}
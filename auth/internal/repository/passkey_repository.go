@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+)
+
+type PasskeyRepository interface {
+	Create(ctx context.Context, passkey *dto.Passkey) error
+	FindByCredentialID(ctx context.Context, credentialID []byte) (*dto.Passkey, error)
+	FindByUserID(ctx context.Context, userID uuid.UUID) ([]*dto.Passkey, error)
+	// UpdateSignCount stores the counter from the last login and marks the passkey used
+	UpdateSignCount(ctx context.Context, id uuid.UUID, signCount uint32) error
+	// Delete removes the user's passkey and reports whether it existed
+	Delete(ctx context.Context, userID, id uuid.UUID) (bool, error)
+	WithTx(tx *sql.Tx) PasskeyRepository
+}
+
+type DefaultPasskeyRepository struct {
+	db DBTX
+}
+
+func NewPasskeyRepository(db *sql.DB) *DefaultPasskeyRepository {
+	return &DefaultPasskeyRepository{db: db}
+}
+
+// WithTx returns a repository that runs its queries inside tx
+func (r *DefaultPasskeyRepository) WithTx(tx *sql.Tx) PasskeyRepository {
+	return &DefaultPasskeyRepository{db: tx}
+}
+
+func (r *DefaultPasskeyRepository) Create(ctx context.Context, passkey *dto.Passkey) error {
+	query := `
+		INSERT INTO passkeys (id, user_id, credential_id, public_key, sign_count, name, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`
+
+	err := r.db.QueryRowContext(
+		ctx,
+		query,
+		uuid.New(),
+		passkey.UserID,
+		passkey.CredentialID,
+		passkey.PublicKey,
+		int64(passkey.SignCount),
+		passkey.Name,
+		time.Now(),
+	).Scan(&passkey.ID, &passkey.CreatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *DefaultPasskeyRepository) FindByCredentialID(ctx context.Context, credentialID []byte) (*dto.Passkey, error) {
+	query := `
+		SELECT id, user_id, credential_id, public_key, sign_count, name, created_at, last_used_at
+		FROM passkeys
+		WHERE credential_id = $1
+	`
+
+	var (
+		passkey   dto.Passkey
+		signCount int64
+	)
+	err := r.db.QueryRowContext(ctx, query, credentialID).Scan(
+		&passkey.ID,
+		&passkey.UserID,
+		&passkey.CredentialID,
+		&passkey.PublicKey,
+		&signCount,
+		&passkey.Name,
+		&passkey.CreatedAt,
+		&passkey.LastUsedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	passkey.SignCount = uint32(signCount)
+	return &passkey, nil
+}
+
+func (r *DefaultPasskeyRepository) FindByUserID(ctx context.Context, userID uuid.UUID) ([]*dto.Passkey, error) {
+	query := `
+		SELECT id, user_id, credential_id, public_key, sign_count, name, created_at, last_used_at
+		FROM passkeys
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var passkeys []*dto.Passkey
+	for rows.Next() {
+		var (
+			passkey   dto.Passkey
+			signCount int64
+		)
+		if err := rows.Scan(
+			&passkey.ID,
+			&passkey.UserID,
+			&passkey.CredentialID,
+			&passkey.PublicKey,
+			&signCount,
+			&passkey.Name,
+			&passkey.CreatedAt,
+			&passkey.LastUsedAt,
+		); err != nil {
+			return nil, err
+		}
+		passkey.SignCount = uint32(signCount)
+		passkeys = append(passkeys, &passkey)
+	}
+
+	return passkeys, rows.Err()
+}
+
+func (r *DefaultPasskeyRepository) UpdateSignCount(ctx context.Context, id uuid.UUID, signCount uint32) error {
+	query := `UPDATE passkeys SET sign_count = $2, last_used_at = $3 WHERE id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, id, int64(signCount), time.Now())
+	return err
+}
+
+func (r *DefaultPasskeyRepository) Delete(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	query := `DELETE FROM passkeys WHERE id = $1 AND user_id = $2`
+
+	result, err := r.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
@@ -11,25 +11,30 @@ import (
 
 type PasswordResetTokenRepository interface {
 	Create(ctx context.Context, token *dto.PasswordResetToken) error
-	Find(ctx context.Context, token uuid.UUID) (*dto.PasswordResetToken, error)
-	Delete(ctx context.Context, token uuid.UUID) error
+	// Delete removes the token with the given hash and returns it, a token can only be deleted once
+	Delete(ctx context.Context, tokenHash string) (*dto.PasswordResetToken, error)
 	ClearFromOld(ctx context.Context, olderThan time.Time) error
-	CreateTables(ctx context.Context) error
+	WithTx(tx *sql.Tx) PasswordResetTokenRepository
 }
 
 type DefaultPasswordResetTokenRepository struct {
-	db *sql.DB
+	db DBTX
 }
 
 func NewPasswordResetTokenRepository(db *sql.DB) *DefaultPasswordResetTokenRepository {
 	return &DefaultPasswordResetTokenRepository{db: db}
 }
 
+// WithTx returns a repository that runs its queries inside tx
+func (p *DefaultPasswordResetTokenRepository) WithTx(tx *sql.Tx) PasswordResetTokenRepository {
+	return &DefaultPasswordResetTokenRepository{db: tx}
+}
+
 func (p *DefaultPasswordResetTokenRepository) Create(ctx context.Context, passwordResetToken *dto.PasswordResetToken) error {
 	query := `
-		INSERT INTO password_reset_tokens (token, user_id, created_at)
-		VALUES ($1, $2, $3)
-		RETURNING token, user_id, created_at
+		INSERT INTO password_reset_tokens (id, user_id, token_hash, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
 	`
 
 	err := p.db.QueryRowContext(
@@ -37,10 +42,10 @@ func (p *DefaultPasswordResetTokenRepository) Create(ctx context.Context, passwo
 		query,
 		uuid.New(),
 		passwordResetToken.UserID,
+		passwordResetToken.TokenHash,
 		time.Now(),
 	).Scan(
-		&passwordResetToken.Token,
-		&passwordResetToken.UserID,
+		&passwordResetToken.ID,
 		&passwordResetToken.CreatedAt,
 	)
 
@@ -60,17 +65,18 @@ func (p *DefaultPasswordResetTokenRepository) ClearFromOld(ctx context.Context,
 	return err
 }
 
-func (p *DefaultPasswordResetTokenRepository) Find(ctx context.Context, token uuid.UUID) (*dto.PasswordResetToken, error) {
+func (p *DefaultPasswordResetTokenRepository) Delete(ctx context.Context, tokenHash string) (*dto.PasswordResetToken, error) {
 	query := `
-		SELECT token, user_id, created_at
-		FROM password_reset_tokens
-		WHERE token = $1
+		DELETE FROM password_reset_tokens
+		WHERE token_hash = $1
+		RETURNING id, user_id, token_hash, created_at
 	`
 
 	var passwordResetToken dto.PasswordResetToken
-	err := p.db.QueryRowContext(ctx, query, token).Scan(
-		&passwordResetToken.Token,
+	err := p.db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&passwordResetToken.ID,
 		&passwordResetToken.UserID,
+		&passwordResetToken.TokenHash,
 		&passwordResetToken.CreatedAt,
 	)
 
@@ -80,27 +86,3 @@ func (p *DefaultPasswordResetTokenRepository) Find(ctx context.Context, token uu
 
 	return &passwordResetToken, nil
 }
-
-func (p *DefaultPasswordResetTokenRepository) Delete(ctx context.Context, token uuid.UUID) error {
-	query := `DELETE FROM password_reset_tokens WHERE token = $1`
-
-	_, err := p.db.ExecContext(ctx, query, token)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (p *DefaultPasswordResetTokenRepository) CreateTables(ctx context.Context) error {
-	query := `
-		CREATE TABLE IF NOT EXISTS password_reset_tokens (
-			token UUID PRIMARY KEY,
-			user_id UUID NOT NULL,
-			created_at TIMESTAMP NOT NULL
-		);
-	`
-
-	_, err := p.db.ExecContext(ctx, query)
-	return err
-}
@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+)
+
+type RefreshTokenRepository interface {
+	Create(ctx context.Context, token *dto.RefreshToken) error
+	FindByHash(ctx context.Context, tokenHash string) (*dto.RefreshToken, error)
+	// MarkUsed flags the token as used and reports false if it had already been used
+	MarkUsed(ctx context.Context, id uuid.UUID) (bool, error)
+	RevokeFamily(ctx context.Context, familyID uuid.UUID) error
+	RevokeByUserID(ctx context.Context, userID uuid.UUID) error
+	DeleteExpired(ctx context.Context, now time.Time) error
+	WithTx(tx *sql.Tx) RefreshTokenRepository
+}
+
+type DefaultRefreshTokenRepository struct {
+	db DBTX
+}
+
+func NewRefreshTokenRepository(db *sql.DB) *DefaultRefreshTokenRepository {
+	return &DefaultRefreshTokenRepository{db: db}
+}
+
+// WithTx returns a repository that runs its queries inside tx
+func (r *DefaultRefreshTokenRepository) WithTx(tx *sql.Tx) RefreshTokenRepository {
+	return &DefaultRefreshTokenRepository{db: tx}
+}
+
+func (r *DefaultRefreshTokenRepository) Create(ctx context.Context, token *dto.RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+
+	err := r.db.QueryRowContext(
+		ctx,
+		query,
+		uuid.New(),
+		token.UserID,
+		token.FamilyID,
+		token.TokenHash,
+		token.ExpiresAt,
+		time.Now(),
+	).Scan(&token.ID, &token.CreatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *DefaultRefreshTokenRepository) FindByHash(ctx context.Context, tokenHash string) (*dto.RefreshToken, error) {
+	query := `
+		SELECT id, user_id, family_id, token_hash, expires_at, created_at, used_at, revoked_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
+	`
+
+	var token dto.RefreshToken
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.FamilyID,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+		&token.UsedAt,
+		&token.RevokedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (r *DefaultRefreshTokenRepository) MarkUsed(ctx context.Context, id uuid.UUID) (bool, error) {
+	query := `UPDATE refresh_tokens SET used_at = $2 WHERE id = $1 AND used_at IS NULL`
+
+	result, err := r.db.ExecContext(ctx, query, id, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+func (r *DefaultRefreshTokenRepository) RevokeFamily(ctx context.Context, familyID uuid.UUID) error {
+	query := `UPDATE refresh_tokens SET revoked_at = $2 WHERE family_id = $1 AND revoked_at IS NULL`
+
+	_, err := r.db.ExecContext(ctx, query, familyID, time.Now())
+	return err
+}
+
+func (r *DefaultRefreshTokenRepository) RevokeByUserID(ctx context.Context, userID uuid.UUID) error {
+	query := `UPDATE refresh_tokens SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL`
+
+	_, err := r.db.ExecContext(ctx, query, userID, time.Now())
+	return err
+}
+
+func (r *DefaultRefreshTokenRepository) DeleteExpired(ctx context.Context, now time.Time) error {
+	query := `DELETE FROM refresh_tokens WHERE expires_at < $1`
+
+	_, err := r.db.ExecContext(ctx, query, now)
+	return err
+}
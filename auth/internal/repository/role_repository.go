@@ -0,0 +1,317 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+)
+
+type RoleRepository interface {
+	// CreateRole stores the role without permissions, it returns sql.ErrNoRows when the name is taken
+	CreateRole(ctx context.Context, role *dto.Role) error
+	FindRole(ctx context.Context, name string) (*dto.Role, error)
+	FindRoles(ctx context.Context) ([]*dto.Role, error)
+	// DeleteRole removes the role along with its assignments and reports whether it existed
+	DeleteRole(ctx context.Context, name string) (bool, error)
+	// ReplaceRolePermissions sets the role's permissions, it returns sql.ErrNoRows when one of them does not exist
+	ReplaceRolePermissions(ctx context.Context, role string, permissions []string) error
+	// CreatePermission returns sql.ErrNoRows when the name is taken
+	CreatePermission(ctx context.Context, permission *dto.Permission) error
+	FindPermissions(ctx context.Context) ([]*dto.Permission, error)
+	FindUserRoles(ctx context.Context, userID uuid.UUID) ([]string, error)
+	// FindUserPermissions returns the permissions of all the user's roles without duplicates
+	FindUserPermissions(ctx context.Context, userID uuid.UUID) ([]string, error)
+	// AssignRole gives the user the role, assigning it twice is not an error
+	AssignRole(ctx context.Context, userID uuid.UUID, role string) error
+	// UnassignRole takes the role from the user and reports whether the user had it
+	UnassignRole(ctx context.Context, userID uuid.UUID, role string) (bool, error)
+	// LockRole locks the role until the transaction ends, it returns sql.ErrNoRows when the role does not exist
+	LockRole(ctx context.Context, name string) error
+	// CountRoleHolders returns how many users have the role
+	CountRoleHolders(ctx context.Context, role string) (int, error)
+	WithTx(tx *sql.Tx) RoleRepository
+}
+
+type DefaultRoleRepository struct {
+	db DBTX
+}
+
+func NewRoleRepository(db *sql.DB) *DefaultRoleRepository {
+	return &DefaultRoleRepository{db: db}
+}
+
+// WithTx returns a repository that runs its queries inside tx
+func (r *DefaultRoleRepository) WithTx(tx *sql.Tx) RoleRepository {
+	return &DefaultRoleRepository{db: tx}
+}
+
+func (r *DefaultRoleRepository) CreateRole(ctx context.Context, role *dto.Role) error {
+	query := `
+		INSERT INTO roles (name, description, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO NOTHING
+		RETURNING created_at
+	`
+
+	err := r.db.QueryRowContext(ctx, query, role.Name, role.Description, time.Now()).Scan(&role.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	role.Permissions = []string{}
+	return nil
+}
+
+func (r *DefaultRoleRepository) FindRole(ctx context.Context, name string) (*dto.Role, error) {
+	query := `
+		SELECT name, description, created_at
+		FROM roles
+		WHERE name = $1
+	`
+
+	var role dto.Role
+	err := r.db.QueryRowContext(ctx, query, name).Scan(
+		&role.Name,
+		&role.Description,
+		&role.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	permissionsQuery := `
+		SELECT permission_name
+		FROM role_permissions
+		WHERE role_name = $1
+		ORDER BY permission_name
+	`
+
+	rows, err := r.db.QueryContext(ctx, permissionsQuery, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	role.Permissions = []string{}
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		role.Permissions = append(role.Permissions, permission)
+	}
+
+	return &role, rows.Err()
+}
+
+func (r *DefaultRoleRepository) FindRoles(ctx context.Context) ([]*dto.Role, error) {
+	query := `
+		SELECT r.name, r.description, r.created_at, rp.permission_name
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role_name = r.name
+		ORDER BY r.name, rp.permission_name
+	`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Rows come grouped by role, one per permission
+	var roles []*dto.Role
+	for rows.Next() {
+		var (
+			role       dto.Role
+			permission sql.NullString
+		)
+		if err := rows.Scan(&role.Name, &role.Description, &role.CreatedAt, &permission); err != nil {
+			return nil, err
+		}
+		if len(roles) == 0 || roles[len(roles)-1].Name != role.Name {
+			role.Permissions = []string{}
+			roles = append(roles, &role)
+		}
+		if permission.Valid {
+			last := roles[len(roles)-1]
+			last.Permissions = append(last.Permissions, permission.String)
+		}
+	}
+
+	return roles, rows.Err()
+}
+
+func (r *DefaultRoleRepository) DeleteRole(ctx context.Context, name string) (bool, error) {
+	query := `DELETE FROM roles WHERE name = $1`
+
+	result, err := r.db.ExecContext(ctx, query, name)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+func (r *DefaultRoleRepository) ReplaceRolePermissions(ctx context.Context, role string, permissions []string) error {
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM role_permissions WHERE role_name = $1`, role); err != nil {
+		return err
+	}
+
+	// Selecting from permissions inserts nothing for a name that does not exist
+	query := `
+		INSERT INTO role_permissions (role_name, permission_name)
+		SELECT $1, name FROM permissions WHERE name = $2
+		ON CONFLICT DO NOTHING
+	`
+
+	for _, permission := range permissions {
+		result, err := r.db.ExecContext(ctx, query, role, permission)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return sql.ErrNoRows
+		}
+	}
+	return nil
+}
+
+func (r *DefaultRoleRepository) CreatePermission(ctx context.Context, permission *dto.Permission) error {
+	query := `
+		INSERT INTO permissions (name, description, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO NOTHING
+		RETURNING created_at
+	`
+
+	return r.db.QueryRowContext(ctx, query, permission.Name, permission.Description, time.Now()).Scan(&permission.CreatedAt)
+}
+
+func (r *DefaultRoleRepository) FindPermissions(ctx context.Context) ([]*dto.Permission, error) {
+	query := `
+		SELECT name, description, created_at
+		FROM permissions
+		ORDER BY name
+	`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []*dto.Permission
+	for rows.Next() {
+		var permission dto.Permission
+		if err := rows.Scan(&permission.Name, &permission.Description, &permission.CreatedAt); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, &permission)
+	}
+
+	return permissions, rows.Err()
+}
+
+func (r *DefaultRoleRepository) FindUserRoles(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	query := `
+		SELECT role_name
+		FROM user_roles
+		WHERE user_id = $1
+		ORDER BY role_name
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+func (r *DefaultRoleRepository) FindUserPermissions(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	query := `
+		SELECT DISTINCT rp.permission_name
+		FROM user_roles ur
+		JOIN role_permissions rp ON rp.role_name = ur.role_name
+		WHERE ur.user_id = $1
+		ORDER BY rp.permission_name
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []string
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	return permissions, rows.Err()
+}
+
+func (r *DefaultRoleRepository) AssignRole(ctx context.Context, userID uuid.UUID, role string) error {
+	query := `
+		INSERT INTO user_roles (user_id, role_name, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, role_name) DO NOTHING
+	`
+
+	_, err := r.db.ExecContext(ctx, query, userID, role, time.Now())
+	return err
+}
+
+func (r *DefaultRoleRepository) UnassignRole(ctx context.Context, userID uuid.UUID, role string) (bool, error) {
+	query := `DELETE FROM user_roles WHERE user_id = $1 AND role_name = $2`
+
+	result, err := r.db.ExecContext(ctx, query, userID, role)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// LockRole takes a row lock that conflicts with other LockRole calls but not with assignments referencing the role
+func (r *DefaultRoleRepository) LockRole(ctx context.Context, name string) error {
+	query := `SELECT name FROM roles WHERE name = $1 FOR NO KEY UPDATE`
+
+	var locked string
+	return r.db.QueryRowContext(ctx, query, name).Scan(&locked)
+}
+
+func (r *DefaultRoleRepository) CountRoleHolders(ctx context.Context, role string) (int, error) {
+	query := `SELECT COUNT(*) FROM user_roles WHERE role_name = $1`
+
+	var count int
+	err := r.db.QueryRowContext(ctx, query, role).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+)
+
+type SessionRepository interface {
+	// Create stores the session under the ID it already has
+	Create(ctx context.Context, session *dto.Session) error
+	FindByID(ctx context.Context, id uuid.UUID) (*dto.Session, error)
+	FindActiveByUserID(ctx context.Context, userID uuid.UUID) ([]*dto.Session, error)
+	UpdateLastSeen(ctx context.Context, id uuid.UUID, lastSeenAt time.Time) error
+	// Revoke ends the user's session and reports whether it was active
+	Revoke(ctx context.Context, userID, id uuid.UUID) (bool, error)
+	RevokeByUserID(ctx context.Context, userID uuid.UUID) error
+	// DeleteInactive removes revoked sessions and sessions last seen before the given time
+	DeleteInactive(ctx context.Context, lastSeenBefore time.Time) error
+	WithTx(tx *sql.Tx) SessionRepository
+}
+
+type DefaultSessionRepository struct {
+	db DBTX
+}
+
+func NewSessionRepository(db *sql.DB) *DefaultSessionRepository {
+	return &DefaultSessionRepository{db: db}
+}
+
+// WithTx returns a repository that runs its queries inside tx
+func (r *DefaultSessionRepository) WithTx(tx *sql.Tx) SessionRepository {
+	return &DefaultSessionRepository{db: tx}
+}
+
+func (r *DefaultSessionRepository) Create(ctx context.Context, session *dto.Session) error {
+	query := `
+		INSERT INTO sessions (id, user_id, device, user_agent, ip, created_at, last_seen_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6)
+		RETURNING created_at, last_seen_at
+	`
+
+	err := r.db.QueryRowContext(
+		ctx,
+		query,
+		session.ID,
+		session.UserID,
+		session.Device,
+		session.UserAgent,
+		session.IP,
+		time.Now(),
+	).Scan(&session.CreatedAt, &session.LastSeenAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *DefaultSessionRepository) FindByID(ctx context.Context, id uuid.UUID) (*dto.Session, error) {
+	query := `
+		SELECT id, user_id, device, user_agent, ip, created_at, last_seen_at, revoked_at
+		FROM sessions
+		WHERE id = $1
+	`
+
+	var session dto.Session
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.Device,
+		&session.UserAgent,
+		&session.IP,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.RevokedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (r *DefaultSessionRepository) FindActiveByUserID(ctx context.Context, userID uuid.UUID) ([]*dto.Session, error) {
+	query := `
+		SELECT id, user_id, device, user_agent, ip, created_at, last_seen_at, revoked_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY last_seen_at DESC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*dto.Session
+	for rows.Next() {
+		var session dto.Session
+		if err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.Device,
+			&session.UserAgent,
+			&session.IP,
+			&session.CreatedAt,
+			&session.LastSeenAt,
+			&session.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+
+	return sessions, rows.Err()
+}
+
+func (r *DefaultSessionRepository) UpdateLastSeen(ctx context.Context, id uuid.UUID, lastSeenAt time.Time) error {
+	query := `UPDATE sessions SET last_seen_at = $2 WHERE id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, id, lastSeenAt)
+	return err
+}
+
+func (r *DefaultSessionRepository) Revoke(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	query := `UPDATE sessions SET revoked_at = $3 WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+
+	result, err := r.db.ExecContext(ctx, query, id, userID, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+func (r *DefaultSessionRepository) RevokeByUserID(ctx context.Context, userID uuid.UUID) error {
+	query := `UPDATE sessions SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL`
+
+	_, err := r.db.ExecContext(ctx, query, userID, time.Now())
+	return err
+}
+
+func (r *DefaultSessionRepository) DeleteInactive(ctx context.Context, lastSeenBefore time.Time) error {
+	query := `DELETE FROM sessions WHERE revoked_at IS NOT NULL OR last_seen_at < $1`
+
+	_, err := r.db.ExecContext(ctx, query, lastSeenBefore)
+	return err
+}
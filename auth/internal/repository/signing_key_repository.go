@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+)
+
+// signingKeyLockID is the Postgres advisory lock key that keeps replicas from generating signing keys at the same time
+const signingKeyLockID int64 = 0x61757468_6b657973
+
+type SigningKeyRepository interface {
+	Create(ctx context.Context, key *dto.SigningKey) error
+	// CreateUnlessScheduled inserts key unless a key of the same algorithm activates after `after` and no later
+	// than key.ActivatesAt, it reports whether key was inserted
+	CreateUnlessScheduled(ctx context.Context, key *dto.SigningKey, after time.Time) (bool, error)
+	// FindActive returns the keys that have not expired at now, latest activation first
+	FindActive(ctx context.Context, now time.Time) ([]*dto.SigningKey, error)
+}
+
+type DefaultSigningKeyRepository struct {
+	db         DBTX
+	transactor Transactor
+}
+
+func NewSigningKeyRepository(db *sql.DB) *DefaultSigningKeyRepository {
+	return &DefaultSigningKeyRepository{
+		db:         db,
+		transactor: NewTransactor(db),
+	}
+}
+
+func (r *DefaultSigningKeyRepository) Create(ctx context.Context, key *dto.SigningKey) error {
+	query := `
+		INSERT INTO signing_keys (id, algorithm, private_key, encrypted, created_at, activates_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	_, err := r.db.ExecContext(
+		ctx,
+		query,
+		key.ID,
+		key.Algorithm,
+		key.PrivateKey,
+		key.Encrypted,
+		key.CreatedAt,
+		key.ActivatesAt,
+		key.ExpiresAt,
+	)
+	return err
+}
+
+// CreateUnlessScheduled holds a transaction level advisory lock while it checks and inserts, so of the replicas
+// racing to rotate only the first creates a key and the others find it
+func (r *DefaultSigningKeyRepository) CreateUnlessScheduled(ctx context.Context, key *dto.SigningKey, after time.Time) (bool, error) {
+	var created bool
+	err := r.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, signingKeyLockID); err != nil {
+			return err
+		}
+
+		query := `
+			SELECT EXISTS (
+				SELECT 1 FROM signing_keys
+				WHERE algorithm = $1 AND activates_at > $2 AND activates_at <= $3
+			)
+		`
+		var exists bool
+		if err := tx.QueryRowContext(ctx, query, key.Algorithm, after, key.ActivatesAt).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+
+		if err := (&DefaultSigningKeyRepository{db: tx}).Create(ctx, key); err != nil {
+			return err
+		}
+		created = true
+		return nil
+	})
+	return created, err
+}
+
+func (r *DefaultSigningKeyRepository) FindActive(ctx context.Context, now time.Time) ([]*dto.SigningKey, error) {
+	query := `
+		SELECT id, algorithm, private_key, encrypted, created_at, activates_at, expires_at
+		FROM signing_keys
+		WHERE expires_at > $1
+		ORDER BY activates_at DESC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*dto.SigningKey
+	for rows.Next() {
+		var key dto.SigningKey
+		if err := rows.Scan(
+			&key.ID,
+			&key.Algorithm,
+			&key.PrivateKey,
+			&key.Encrypted,
+			&key.CreatedAt,
+			&key.ActivatesAt,
+			&key.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+
+	return keys, rows.Err()
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+)
+
+// DBTX is implemented by both *sql.DB and *sql.Tx so repositories can run inside a transaction
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type Transactor interface {
+	WithinTransaction(ctx context.Context, fn func(tx *sql.Tx) error) error
+}
+
+type DefaultTransactor struct {
+	db *sql.DB
+}
+
+func NewTransactor(db *sql.DB) *DefaultTransactor {
+	return &DefaultTransactor{db: db}
+}
+
+// WithinTransaction commits the transaction if fn succeeds and rolls it back otherwise
+func (t *DefaultTransactor) WithinTransaction(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+)
+
+type UserIdentityRepository interface {
+	Create(ctx context.Context, identity *dto.UserIdentity) error
+	Find(ctx context.Context, provider, subject string) (*dto.UserIdentity, error)
+	WithTx(tx *sql.Tx) UserIdentityRepository
+}
+
+type DefaultUserIdentityRepository struct {
+	db DBTX
+}
+
+func NewUserIdentityRepository(db *sql.DB) *DefaultUserIdentityRepository {
+	return &DefaultUserIdentityRepository{db: db}
+}
+
+// WithTx returns a repository that runs its queries inside tx
+func (r *DefaultUserIdentityRepository) WithTx(tx *sql.Tx) UserIdentityRepository {
+	return &DefaultUserIdentityRepository{db: tx}
+}
+
+func (r *DefaultUserIdentityRepository) Create(ctx context.Context, identity *dto.UserIdentity) error {
+	query := `
+		INSERT INTO user_identities (provider, subject, user_id, email, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at
+	`
+
+	err := r.db.QueryRowContext(
+		ctx,
+		query,
+		identity.Provider,
+		identity.Subject,
+		identity.UserID,
+		identity.Email,
+		time.Now(),
+	).Scan(&identity.CreatedAt)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *DefaultUserIdentityRepository) Find(ctx context.Context, provider, subject string) (*dto.UserIdentity, error) {
+	query := `
+		SELECT provider, subject, user_id, email, created_at
+		FROM user_identities
+		WHERE provider = $1 AND subject = $2
+	`
+
+	var identity dto.UserIdentity
+	err := r.db.QueryRowContext(ctx, query, provider, subject).Scan(
+		&identity.Provider,
+		&identity.Subject,
+		&identity.UserID,
+		&identity.Email,
+		&identity.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &identity, nil
+}
@@ -15,22 +15,31 @@ type UserRepository interface {
 	FindByID(ctx context.Context, id uuid.UUID) (*dto.User, error)
 	UpdateEmail(ctx context.Context, userID uuid.UUID, email string) error
 	UpdatePasswordHash(ctx context.Context, userID uuid.UUID, passwordHash string) error
+	UpdateProfile(ctx context.Context, userID uuid.UUID, profile *dto.Profile) error
+	MarkEmailVerified(ctx context.Context, userID uuid.UUID) error
+	// UpdateLockout records the consecutive failed logins of the user with the email and until when it is locked
+	UpdateLockout(ctx context.Context, email string, failedAttempts int, lockedUntil *time.Time) error
 	Delete(ctx context.Context, id uuid.UUID) error
-	CreateTables(ctx context.Context) error
+	WithTx(tx *sql.Tx) UserRepository
 }
 
 type DefaultUserRepository struct {
-	db *sql.DB
+	db DBTX
 }
 
 func NewUserRepository(db *sql.DB) *DefaultUserRepository {
 	return &DefaultUserRepository{db: db}
 }
 
+// WithTx returns a repository that runs its queries inside tx
+func (r *DefaultUserRepository) WithTx(tx *sql.Tx) UserRepository {
+	return &DefaultUserRepository{db: tx}
+}
+
 func (r *DefaultUserRepository) Create(ctx context.Context, user *dto.User) error {
 	query := `
-		INSERT INTO users (id, email, password_hash, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO users (id, email, password_hash, name, locale, timezone, avatar_url, email_verified_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		RETURNING id, created_at, updated_at
 	`
 
@@ -44,6 +53,11 @@ func (r *DefaultUserRepository) Create(ctx context.Context, user *dto.User) erro
 		user.ID,
 		user.Email,
 		user.PasswordHash,
+		user.Name,
+		user.Locale,
+		user.Timezone,
+		user.AvatarURL,
+		user.EmailVerifiedAt,
 		user.CreatedAt,
 		user.UpdatedAt,
 	).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
@@ -57,7 +71,7 @@ func (r *DefaultUserRepository) Create(ctx context.Context, user *dto.User) erro
 
 func (r *DefaultUserRepository) FindByEmail(ctx context.Context, email string) (*dto.User, error) {
 	query := `
-		SELECT id, email, password_hash, created_at, updated_at
+		SELECT id, email, password_hash, name, locale, timezone, avatar_url, email_verified_at, created_at, updated_at
 		FROM users
 		WHERE email = $1
 	`
@@ -67,6 +81,11 @@ func (r *DefaultUserRepository) FindByEmail(ctx context.Context, email string) (
 		&user.ID,
 		&user.Email,
 		&user.PasswordHash,
+		&user.Name,
+		&user.Locale,
+		&user.Timezone,
+		&user.AvatarURL,
+		&user.EmailVerifiedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -80,7 +99,7 @@ func (r *DefaultUserRepository) FindByEmail(ctx context.Context, email string) (
 
 func (r *DefaultUserRepository) FindByID(ctx context.Context, id uuid.UUID) (*dto.User, error) {
 	query := `
-		SELECT id, email, password_hash, created_at, updated_at
+		SELECT id, email, password_hash, name, locale, timezone, avatar_url, email_verified_at, created_at, updated_at
 		FROM users
 		WHERE id = $1
 	`
@@ -90,6 +109,11 @@ func (r *DefaultUserRepository) FindByID(ctx context.Context, id uuid.UUID) (*dt
 		&user.ID,
 		&user.Email,
 		&user.PasswordHash,
+		&user.Name,
+		&user.Locale,
+		&user.Timezone,
+		&user.AvatarURL,
+		&user.EmailVerifiedAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -149,10 +173,26 @@ func (r *DefaultUserRepository) UpdatePasswordHash(ctx context.Context, userID u
 	return nil
 }
 
-func (r *DefaultUserRepository) Delete(ctx context.Context, id uuid.UUID) error {
-	query := `DELETE FROM users WHERE id = $1`
+func (r *DefaultUserRepository) UpdateProfile(ctx context.Context, userID uuid.UUID, profile *dto.Profile) error {
+	updatedAt := time.Now()
+
+	query := `
+		UPDATE users
+		SET name = $2, locale = $3, timezone = $4, avatar_url = $5, updated_at = $6
+		WHERE id = $1
+		RETURNING updated_at
+	`
+	err := r.db.QueryRowContext(
+		ctx,
+		query,
+		userID,
+		profile.Name,
+		profile.Locale,
+		profile.Timezone,
+		profile.AvatarURL,
+		updatedAt,
+	).Scan(&updatedAt)
 
-	_, err := r.db.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -160,20 +200,27 @@ func (r *DefaultUserRepository) Delete(ctx context.Context, id uuid.UUID) error
 	return nil
 }
 
-// CreateTables creates the necessary database tables
-func (r *DefaultUserRepository) CreateTables(ctx context.Context) error {
-	query := `
-		CREATE TABLE IF NOT EXISTS users (
-			id UUID PRIMARY KEY,
-			email VARCHAR(255) UNIQUE NOT NULL,
-			password_hash VARCHAR(255) NOT NULL,
-			created_at TIMESTAMP NOT NULL,
-			updated_at TIMESTAMP NOT NULL
-		);
-
-		CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
-	`
+func (r *DefaultUserRepository) MarkEmailVerified(ctx context.Context, userID uuid.UUID) error {
+	query := `UPDATE users SET email_verified_at = $2 WHERE id = $1`
 
-	_, err := r.db.ExecContext(ctx, query)
+	_, err := r.db.ExecContext(ctx, query, userID, time.Now())
 	return err
 }
+
+func (r *DefaultUserRepository) UpdateLockout(ctx context.Context, email string, failedAttempts int, lockedUntil *time.Time) error {
+	query := `UPDATE users SET failed_login_attempts = $2, locked_until = $3 WHERE email = $1`
+
+	_, err := r.db.ExecContext(ctx, query, email, failedAttempts, lockedUntil)
+	return err
+}
+
+func (r *DefaultUserRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	query := `DELETE FROM users WHERE id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yoshapihoff/bricks/auth/internal/ratelimit"
+)
+
+var (
+	ErrTooManyAttempts = errors.New("too many attempts")
+	ErrAccountLocked   = errors.New("account is temporarily locked")
+)
+
+// RetryAfterError tells the client how long to wait before trying again
+type RetryAfterError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+const (
+	ActionLogin          = "login"
+	ActionLoginMFA       = "login-mfa"
+	ActionForgotPassword = "forgot-password"
+	ActionMagicLink      = "magic-link"
+)
+
+// AttemptPolicy limits the attempts at an action within a sliding window
+type AttemptPolicy struct {
+	PerIP      int
+	PerAccount int
+	Window     time.Duration
+}
+
+type AttemptLimiter interface {
+	// Check records an attempt at action from ip against account and fails with ErrTooManyAttempts over the limit
+	Check(ctx context.Context, action, ip, account string) error
+}
+
+type DefaultAttemptLimiter struct {
+	limiter  ratelimit.Limiter
+	policies map[string]AttemptPolicy
+}
+
+func NewAttemptLimiter(limiter ratelimit.Limiter, policies map[string]AttemptPolicy) *DefaultAttemptLimiter {
+	return &DefaultAttemptLimiter{
+		limiter:  limiter,
+		policies: policies,
+	}
+}
+
+func (l *DefaultAttemptLimiter) Check(ctx context.Context, action, ip, account string) error {
+	policy, ok := l.policies[action]
+	if !ok {
+		return nil
+	}
+
+	// An IP over its limit is turned away before it can use up the account window
+	result, err := l.limiter.Allow(ctx, fmt.Sprintf("%s:ip:%s", action, ip), policy.PerIP, policy.Window)
+	if err != nil {
+		return err
+	}
+	if result.Allowed {
+		result, err = l.limiter.Allow(ctx, fmt.Sprintf("%s:account:%s", action, strings.ToLower(account)), policy.PerAccount, policy.Window)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !result.Allowed {
+		return &RetryAfterError{
+			Err:        ErrTooManyAttempts,
+			RetryAfter: result.RetryAfter,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/auth"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+	"github.com/yoshapihoff/bricks/auth/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrEmailChangeTokenNotFound = errors.New("email change token not found")
+	ErrEmailChangeTokenExpired  = errors.New("email change token expired")
+	ErrSameEmail                = errors.New("new email matches the current one")
+)
+
+type EmailChangeService interface {
+	// Request mails a confirmation token to the new address and a notice to the current one,
+	// the email is not changed until the token is confirmed. A new address that is already taken only
+	// gets a notice, the caller cannot tell the difference. Users without a password re-authenticate with
+	// an MFA code instead when they have MFA enabled.
+	Request(ctx context.Context, userID uuid.UUID, newEmail, password, code string) error
+	// Confirm switches the user to the address the token was issued for
+	Confirm(ctx context.Context, token string) (*dto.User, error)
+}
+
+// EmailChangeEmailProducer enqueues the email change emails as part of the token transaction
+type EmailChangeEmailProducer interface {
+	ProduceEmailChangeConfirmation(ctx context.Context, tx *sql.Tx, newEmail, emailChangeToken string) error
+	ProduceEmailChangeNotice(ctx context.Context, tx *sql.Tx, oldEmail, newEmail string) error
+	ProduceEmailChangeAddressTaken(ctx context.Context, tx *sql.Tx, newEmail string) error
+}
+
+type DefaultEmailChangeService struct {
+	repo          repository.EmailChangeTokenRepository
+	userRepo      repository.UserRepository
+	mfaSvc        MFAService
+	transactor    repository.Transactor
+	emailProducer EmailChangeEmailProducer
+	expiration    time.Duration
+}
+
+func NewEmailChangeService(
+	repo repository.EmailChangeTokenRepository,
+	userRepo repository.UserRepository,
+	mfaSvc MFAService,
+	transactor repository.Transactor,
+	emailProducer EmailChangeEmailProducer,
+	expiration time.Duration,
+) *DefaultEmailChangeService {
+	return &DefaultEmailChangeService{
+		repo:          repo,
+		userRepo:      userRepo,
+		mfaSvc:        mfaSvc,
+		transactor:    transactor,
+		emailProducer: emailProducer,
+		expiration:    expiration,
+	}
+}
+
+func (s *DefaultEmailChangeService) Request(ctx context.Context, userID uuid.UUID, newEmail, password, code string) error {
+	if _, err := mail.ParseAddress(newEmail); err != nil {
+		return ErrInvalidEmail
+	}
+
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	if err := s.reauthenticate(ctx, user, password, code); err != nil {
+		return err
+	}
+	if strings.EqualFold(user.Email, newEmail) {
+		return ErrSameEmail
+	}
+
+	// The owner of a taken address is told about the attempt instead, the requester gets the same answer
+	if err := s.ensureEmailAvailable(ctx, s.userRepo, newEmail); err != nil {
+		if !errors.Is(err, ErrEmailExists) {
+			return err
+		}
+		return s.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+			if err := s.emailProducer.ProduceEmailChangeAddressTaken(ctx, tx, newEmail); err != nil {
+				return err
+			}
+			return s.emailProducer.ProduceEmailChangeNotice(ctx, tx, user.Email, newEmail)
+		})
+	}
+
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	// A new request replaces any pending one so only the latest link works
+	return s.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if err := repo.DeleteByUserID(ctx, userID); err != nil {
+			return err
+		}
+		if err := repo.Create(ctx, &dto.EmailChangeToken{
+			UserID:    userID,
+			NewEmail:  newEmail,
+			TokenHash: tokenHash,
+			ExpiresAt: time.Now().Add(s.expiration),
+		}); err != nil {
+			return err
+		}
+		if err := s.emailProducer.ProduceEmailChangeConfirmation(ctx, tx, newEmail, token); err != nil {
+			return err
+		}
+		return s.emailProducer.ProduceEmailChangeNotice(ctx, tx, user.Email, newEmail)
+	})
+}
+
+func (s *DefaultEmailChangeService) Confirm(ctx context.Context, token string) (*dto.User, error) {
+	var user *dto.User
+	err := s.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		repo := s.repo.WithTx(tx)
+		userRepo := s.userRepo.WithTx(tx)
+
+		emailChangeToken, err := repo.FindByHash(ctx, auth.HashOpaqueToken(token))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrEmailChangeTokenNotFound
+			}
+			return err
+		}
+		if emailChangeToken.ExpiresAt.Before(time.Now()) {
+			return ErrEmailChangeTokenExpired
+		}
+
+		// The address may have been taken since the change was requested
+		if err := s.ensureEmailAvailable(ctx, userRepo, emailChangeToken.NewEmail); err != nil {
+			return err
+		}
+		if err := userRepo.UpdateEmail(ctx, emailChangeToken.UserID, emailChangeToken.NewEmail); err != nil {
+			return err
+		}
+		// Confirming the token proves the user owns the new address
+		if err := userRepo.MarkEmailVerified(ctx, emailChangeToken.UserID); err != nil {
+			return err
+		}
+		if err := repo.DeleteByUserID(ctx, emailChangeToken.UserID); err != nil {
+			return err
+		}
+
+		user, err = userRepo.FindByID(ctx, emailChangeToken.UserID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// reauthenticate follows the rule of disabling MFA: the password when the user has one, otherwise a code
+// when MFA is enabled. Users with neither only have their session, the notice to the current address is
+// what tells them about a change they did not ask for.
+func (s *DefaultEmailChangeService) reauthenticate(ctx context.Context, user *dto.User, password, code string) error {
+	if user.PasswordHash != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+			return ErrInvalidPassword
+		}
+		return nil
+	}
+
+	if err := s.mfaSvc.VerifyCode(ctx, user.ID, code); err != nil && !errors.Is(err, ErrMFANotEnabled) {
+		return err
+	}
+	return nil
+}
+
+func (s *DefaultEmailChangeService) ensureEmailAvailable(ctx context.Context, userRepo repository.UserRepository, email string) error {
+	existingUser, err := userRepo.FindByEmail(ctx, email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if existingUser != nil {
+		return ErrEmailExists
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestEmailChangeRequestToTakenAddressLooksLikeSuccess(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	user := &dto.User{ID: uuid.New(), Email: "user@example.com", PasswordHash: string(hash)}
+	owner := &dto.User{ID: uuid.New(), Email: "taken@example.com"}
+	tokens := newFakeEmailChangeTokenRepository()
+	producer := &fakeEmailProducer{}
+	svc := NewEmailChangeService(tokens, newFakeUserRepository(user, owner), nil, fakeTransactor{}, producer, time.Hour)
+
+	if err := svc.Request(context.Background(), user.ID, owner.Email, "correct horse", ""); err != nil {
+		t.Fatalf("Request() error = %v, want nil", err)
+	}
+
+	if len(tokens.tokens) != 0 {
+		t.Errorf("%d tokens created for a taken address, want none", len(tokens.tokens))
+	}
+	want := []sentEmail{
+		{template: "email-change-taken", to: owner.Email},
+		{template: "email-change-notice", to: user.Email},
+	}
+	if got := producer.sent(); !slices.Equal(got, want) {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+}
+
+func TestEmailChangeRequestReauthenticatesPasswordlessUsersWithMFA(t *testing.T) {
+	user := &dto.User{ID: uuid.New(), Email: "user@example.com"}
+	mfaSvc, codes := newMFATestService(t, user)
+	tokens := newFakeEmailChangeTokenRepository()
+	producer := &fakeEmailProducer{}
+	svc := NewEmailChangeService(tokens, newFakeUserRepository(user), mfaSvc, fakeTransactor{}, producer, time.Hour)
+	ctx := context.Background()
+
+	if err := svc.Request(ctx, user.ID, "new@example.com", "", "000000"); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("Request() with a wrong code: error = %v, want %v", err, ErrInvalidMFACode)
+	}
+	if err := svc.Request(ctx, user.ID, "new@example.com", "", codes[0]); err != nil {
+		t.Fatalf("Request() with a recovery code: %v", err)
+	}
+
+	sent := producer.sent()
+	if len(sent) == 0 || sent[0].template != "email-change-confirm" || sent[0].to != "new@example.com" {
+		t.Fatalf("sent %v, want a confirmation to the new address first", sent)
+	}
+	changed, err := svc.Confirm(ctx, sent[0].token)
+	if err != nil {
+		t.Fatalf("Confirm() error = %v", err)
+	}
+	if changed.Email != "new@example.com" {
+		t.Errorf("email = %q, want new@example.com", changed.Email)
+	}
+}
+
+func TestEmailChangeRequestChecksThePassword(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	user := &dto.User{ID: uuid.New(), Email: "user@example.com", PasswordHash: string(hash)}
+	svc := NewEmailChangeService(newFakeEmailChangeTokenRepository(), newFakeUserRepository(user), nil, fakeTransactor{}, &fakeEmailProducer{}, time.Hour)
+
+	if err := svc.Request(context.Background(), user.ID, "new@example.com", "wrong", ""); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("error = %v, want %v", err, ErrInvalidPassword)
+	}
+}
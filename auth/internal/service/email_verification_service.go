@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/yoshapihoff/bricks/auth/internal/auth"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+	"github.com/yoshapihoff/bricks/auth/internal/repository"
+)
+
+var (
+	ErrEmailVerificationTokenNotFound = errors.New("email verification token not found")
+	ErrEmailVerificationTokenExpired  = errors.New("email verification token expired")
+)
+
+type EmailVerificationService interface {
+	// Send mails a new verification token to the user, replacing any pending one
+	Send(ctx context.Context, user *dto.User) error
+	// Resend mails a new verification token when the address belongs to an unverified user and does nothing otherwise
+	Resend(ctx context.Context, email string) error
+	// NotifyAccountExists tells the owner of a registered address that someone tried to sign up with it
+	NotifyAccountExists(ctx context.Context, email string) error
+	// Verify marks the address the token was issued for as verified
+	Verify(ctx context.Context, token string) (*dto.User, error)
+}
+
+// VerifyEmailProducer enqueues the sign up emails as part of the caller's transaction
+type VerifyEmailProducer interface {
+	ProduceVerifyEmail(ctx context.Context, tx *sql.Tx, email, verifyEmailToken string) error
+	ProduceAccountExistsEmail(ctx context.Context, tx *sql.Tx, email string) error
+}
+
+type DefaultEmailVerificationService struct {
+	repo          repository.EmailVerificationTokenRepository
+	userRepo      repository.UserRepository
+	transactor    repository.Transactor
+	emailProducer VerifyEmailProducer
+	expiration    time.Duration
+}
+
+func NewEmailVerificationService(
+	repo repository.EmailVerificationTokenRepository,
+	userRepo repository.UserRepository,
+	transactor repository.Transactor,
+	emailProducer VerifyEmailProducer,
+	expiration time.Duration,
+) *DefaultEmailVerificationService {
+	return &DefaultEmailVerificationService{
+		repo:          repo,
+		userRepo:      userRepo,
+		transactor:    transactor,
+		emailProducer: emailProducer,
+		expiration:    expiration,
+	}
+}
+
+func (s *DefaultEmailVerificationService) Send(ctx context.Context, user *dto.User) error {
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	return s.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if err := repo.DeleteByUserID(ctx, user.ID); err != nil {
+			return err
+		}
+		if err := repo.Create(ctx, &dto.EmailVerificationToken{
+			UserID:    user.ID,
+			Email:     user.Email,
+			TokenHash: tokenHash,
+			ExpiresAt: time.Now().Add(s.expiration),
+		}); err != nil {
+			return err
+		}
+		return s.emailProducer.ProduceVerifyEmail(ctx, tx, user.Email, token)
+	})
+}
+
+func (s *DefaultEmailVerificationService) Resend(ctx context.Context, email string) error {
+	user, err := s.userRepo.FindByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	return s.Send(ctx, user)
+}
+
+func (s *DefaultEmailVerificationService) NotifyAccountExists(ctx context.Context, email string) error {
+	return s.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		return s.emailProducer.ProduceAccountExistsEmail(ctx, tx, email)
+	})
+}
+
+func (s *DefaultEmailVerificationService) Verify(ctx context.Context, token string) (*dto.User, error) {
+	var user *dto.User
+	err := s.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		repo := s.repo.WithTx(tx)
+		userRepo := s.userRepo.WithTx(tx)
+
+		verificationToken, err := repo.FindByHash(ctx, auth.HashOpaqueToken(token))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrEmailVerificationTokenNotFound
+			}
+			return err
+		}
+		if verificationToken.ExpiresAt.Before(time.Now()) {
+			return ErrEmailVerificationTokenExpired
+		}
+
+		user, err = userRepo.FindByID(ctx, verificationToken.UserID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		// A token issued before an email change does not verify the new address
+		if user.Email != verificationToken.Email {
+			return ErrEmailVerificationTokenNotFound
+		}
+
+		if err := userRepo.MarkEmailVerified(ctx, user.ID); err != nil {
+			return err
+		}
+		if err := repo.DeleteByUserID(ctx, user.ID); err != nil {
+			return err
+		}
+
+		user, err = userRepo.FindByID(ctx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
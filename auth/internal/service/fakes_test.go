@@ -0,0 +1,666 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+	"github.com/yoshapihoff/bricks/auth/internal/repository"
+)
+
+// fakeTransactor runs fn without a transaction, the fake repositories ignore the tx
+type fakeTransactor struct{}
+
+func (fakeTransactor) WithinTransaction(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return fn(nil)
+}
+
+// fakeUserRepository keeps users in memory and returns sql.ErrNoRows like the real one
+type fakeUserRepository struct {
+	mu    sync.Mutex
+	users map[uuid.UUID]*dto.User
+	// lockouts holds what UpdateLockout recorded, by user
+	lockouts map[uuid.UUID]lockout
+}
+
+type lockout struct {
+	failedAttempts int
+	lockedUntil    *time.Time
+}
+
+func newFakeUserRepository(users ...*dto.User) *fakeUserRepository {
+	r := &fakeUserRepository{users: make(map[uuid.UUID]*dto.User), lockouts: make(map[uuid.UUID]lockout)}
+	for _, user := range users {
+		r.users[user.ID] = user
+	}
+	return r
+}
+
+func (r *fakeUserRepository) Create(ctx context.Context, user *dto.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	stored := *user
+	r.users[user.ID] = &stored
+	return nil
+}
+
+func (r *fakeUserRepository) FindByEmail(ctx context.Context, email string) (*dto.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if user.Email == email {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *fakeUserRepository) FindByID(ctx context.Context, id uuid.UUID) (*dto.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	found := *user
+	return &found, nil
+}
+
+func (r *fakeUserRepository) update(userID uuid.UUID, fn func(user *dto.User)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user, ok := r.users[userID]; ok {
+		fn(user)
+		user.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (r *fakeUserRepository) UpdateEmail(ctx context.Context, userID uuid.UUID, email string) error {
+	return r.update(userID, func(user *dto.User) { user.Email = email })
+}
+
+func (r *fakeUserRepository) UpdatePasswordHash(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+	return r.update(userID, func(user *dto.User) { user.PasswordHash = passwordHash })
+}
+
+func (r *fakeUserRepository) UpdateProfile(ctx context.Context, userID uuid.UUID, profile *dto.Profile) error {
+	return r.update(userID, func(user *dto.User) { user.Profile = *profile })
+}
+
+func (r *fakeUserRepository) MarkEmailVerified(ctx context.Context, userID uuid.UUID) error {
+	return r.update(userID, func(user *dto.User) {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	})
+}
+
+func (r *fakeUserRepository) UpdateLockout(ctx context.Context, email string, failedAttempts int, lockedUntil *time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if user.Email == email {
+			r.lockouts[user.ID] = lockout{failedAttempts: failedAttempts, lockedUntil: lockedUntil}
+		}
+	}
+	return nil
+}
+
+func (r *fakeUserRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.users, id)
+	return nil
+}
+
+func (r *fakeUserRepository) WithTx(tx *sql.Tx) repository.UserRepository {
+	return r
+}
+
+type fakeUserIdentityRepository struct {
+	mu         sync.Mutex
+	identities []*dto.UserIdentity
+}
+
+func (r *fakeUserIdentityRepository) Create(ctx context.Context, identity *dto.UserIdentity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	identity.CreatedAt = time.Now()
+	r.identities = append(r.identities, identity)
+	return nil
+}
+
+func (r *fakeUserIdentityRepository) Find(ctx context.Context, provider, subject string) (*dto.UserIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *fakeUserIdentityRepository) WithTx(tx *sql.Tx) repository.UserIdentityRepository {
+	return r
+}
+
+type fakeMFARepository struct {
+	mu            sync.Mutex
+	totps         map[uuid.UUID]*dto.UserTOTP
+	recoveryCodes map[uuid.UUID]map[string]bool
+}
+
+func newFakeMFARepository() *fakeMFARepository {
+	return &fakeMFARepository{
+		totps:         make(map[uuid.UUID]*dto.UserTOTP),
+		recoveryCodes: make(map[uuid.UUID]map[string]bool),
+	}
+}
+
+func (r *fakeMFARepository) SaveTOTP(ctx context.Context, totp *dto.UserTOTP) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.totps[totp.UserID]; ok && existing.ConfirmedAt != nil {
+		return sql.ErrNoRows
+	}
+	saved := *totp
+	r.totps[totp.UserID] = &saved
+	return nil
+}
+
+func (r *fakeMFARepository) FindTOTP(ctx context.Context, userID uuid.UUID) (*dto.UserTOTP, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	totp, ok := r.totps[userID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	found := *totp
+	return &found, nil
+}
+
+func (r *fakeMFARepository) ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	totp, ok := r.totps[userID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	now := time.Now()
+	totp.ConfirmedAt = &now
+	totp.LastUsedStep = step
+	return nil
+}
+
+func (r *fakeMFARepository) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	totp, ok := r.totps[userID]
+	if !ok || step <= totp.LastUsedStep {
+		return false, nil
+	}
+	totp.LastUsedStep = step
+	return true, nil
+}
+
+func (r *fakeMFARepository) DeleteTOTP(ctx context.Context, userID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.totps, userID)
+	return nil
+}
+
+func (r *fakeMFARepository) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	codes := make(map[string]bool, len(codeHashes))
+	for _, codeHash := range codeHashes {
+		codes[codeHash] = true
+	}
+	r.recoveryCodes[userID] = codes
+	return nil
+}
+
+func (r *fakeMFARepository) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.recoveryCodes[userID][codeHash] {
+		return false, nil
+	}
+	delete(r.recoveryCodes[userID], codeHash)
+	return true, nil
+}
+
+func (r *fakeMFARepository) DeleteRecoveryCodes(ctx context.Context, userID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.recoveryCodes, userID)
+	return nil
+}
+
+func (r *fakeMFARepository) WithTx(tx *sql.Tx) repository.MFARepository {
+	return r
+}
+
+// fakeRoleRepository keeps role assignments, the embedded interface panics on methods the tests do not use
+type fakeRoleRepository struct {
+	repository.RoleRepository
+
+	mu      sync.Mutex
+	roles   map[string]bool
+	holders map[string]map[uuid.UUID]bool
+}
+
+func newFakeRoleRepository(roles ...string) *fakeRoleRepository {
+	r := &fakeRoleRepository{
+		roles:   make(map[string]bool),
+		holders: make(map[string]map[uuid.UUID]bool),
+	}
+	for _, role := range roles {
+		r.roles[role] = true
+		r.holders[role] = make(map[uuid.UUID]bool)
+	}
+	return r
+}
+
+func (r *fakeRoleRepository) FindUserRoles(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var roles []string
+	for role, holders := range r.holders {
+		if holders[userID] {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+func (r *fakeRoleRepository) AssignRole(ctx context.Context, userID uuid.UUID, role string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.roles[role] {
+		return sql.ErrNoRows
+	}
+	r.holders[role][userID] = true
+	return nil
+}
+
+func (r *fakeRoleRepository) UnassignRole(ctx context.Context, userID uuid.UUID, role string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.holders[role][userID] {
+		return false, nil
+	}
+	delete(r.holders[role], userID)
+	return true, nil
+}
+
+func (r *fakeRoleRepository) LockRole(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.roles[name] {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (r *fakeRoleRepository) CountRoleHolders(ctx context.Context, role string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.holders[role]), nil
+}
+
+func (r *fakeRoleRepository) WithTx(tx *sql.Tx) repository.RoleRepository {
+	return r
+}
+
+type fakeMFAChallengeRepository struct {
+	mu         sync.Mutex
+	challenges map[uuid.UUID]*dto.MFAChallenge
+}
+
+func newFakeMFAChallengeRepository() *fakeMFAChallengeRepository {
+	return &fakeMFAChallengeRepository{challenges: make(map[uuid.UUID]*dto.MFAChallenge)}
+}
+
+func (r *fakeMFAChallengeRepository) Create(ctx context.Context, challenge *dto.MFAChallenge) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	challenge.ID = uuid.New()
+	challenge.CreatedAt = time.Now()
+	stored := *challenge
+	r.challenges[challenge.ID] = &stored
+	return nil
+}
+
+func (r *fakeMFAChallengeRepository) FindByHash(ctx context.Context, tokenHash string) (*dto.MFAChallenge, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, challenge := range r.challenges {
+		if challenge.TokenHash == tokenHash {
+			found := *challenge
+			return &found, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *fakeMFAChallengeRepository) RecordAttempt(ctx context.Context, id uuid.UUID) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	challenge, ok := r.challenges[id]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	challenge.Attempts++
+	return challenge.Attempts, nil
+}
+
+func (r *fakeMFAChallengeRepository) Delete(ctx context.Context, id uuid.UUID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.challenges[id]
+	delete(r.challenges, id)
+	return ok, nil
+}
+
+func (r *fakeMFAChallengeRepository) DeleteExpired(ctx context.Context, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, challenge := range r.challenges {
+		if challenge.ExpiresAt.Before(now) {
+			delete(r.challenges, id)
+		}
+	}
+	return nil
+}
+
+func (r *fakeMFAChallengeRepository) WithTx(tx *sql.Tx) repository.MFAChallengeRepository {
+	return r
+}
+
+type fakeEmailChangeTokenRepository struct {
+	mu     sync.Mutex
+	tokens map[uuid.UUID]*dto.EmailChangeToken
+}
+
+func newFakeEmailChangeTokenRepository() *fakeEmailChangeTokenRepository {
+	return &fakeEmailChangeTokenRepository{tokens: make(map[uuid.UUID]*dto.EmailChangeToken)}
+}
+
+func (r *fakeEmailChangeTokenRepository) Create(ctx context.Context, token *dto.EmailChangeToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token.ID = uuid.New()
+	token.CreatedAt = time.Now()
+	stored := *token
+	r.tokens[token.ID] = &stored
+	return nil
+}
+
+func (r *fakeEmailChangeTokenRepository) FindByHash(ctx context.Context, tokenHash string) (*dto.EmailChangeToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, token := range r.tokens {
+		if token.TokenHash == tokenHash {
+			found := *token
+			return &found, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *fakeEmailChangeTokenRepository) DeleteByUserID(ctx context.Context, userID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, token := range r.tokens {
+		if token.UserID == userID {
+			delete(r.tokens, id)
+		}
+	}
+	return nil
+}
+
+func (r *fakeEmailChangeTokenRepository) DeleteExpired(ctx context.Context, now time.Time) error {
+	return nil
+}
+
+func (r *fakeEmailChangeTokenRepository) WithTx(tx *sql.Tx) repository.EmailChangeTokenRepository {
+	return r
+}
+
+// sentEmail is what a fake producer enqueued, the token is only set for emails that carry one
+type sentEmail struct {
+	template string
+	to       string
+	token    string
+}
+
+// fakeEmailProducer records the emails the services enqueue
+type fakeEmailProducer struct {
+	mu     sync.Mutex
+	emails []sentEmail
+}
+
+func (p *fakeEmailProducer) record(template, to, token string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.emails = append(p.emails, sentEmail{template: template, to: to, token: token})
+	return nil
+}
+
+func (p *fakeEmailProducer) sent() []sentEmail {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]sentEmail(nil), p.emails...)
+}
+
+func (p *fakeEmailProducer) ProduceEmailChangeConfirmation(ctx context.Context, tx *sql.Tx, newEmail, emailChangeToken string) error {
+	return p.record("email-change-confirm", newEmail, emailChangeToken)
+}
+
+func (p *fakeEmailProducer) ProduceEmailChangeNotice(ctx context.Context, tx *sql.Tx, oldEmail, newEmail string) error {
+	return p.record("email-change-notice", oldEmail, "")
+}
+
+func (p *fakeEmailProducer) ProduceEmailChangeAddressTaken(ctx context.Context, tx *sql.Tx, newEmail string) error {
+	return p.record("email-change-taken", newEmail, "")
+}
+
+type fakeRefreshTokenRepository struct {
+	mu     sync.Mutex
+	tokens map[uuid.UUID]*dto.RefreshToken
+}
+
+func newFakeRefreshTokenRepository() *fakeRefreshTokenRepository {
+	return &fakeRefreshTokenRepository{tokens: make(map[uuid.UUID]*dto.RefreshToken)}
+}
+
+func (r *fakeRefreshTokenRepository) Create(ctx context.Context, token *dto.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token.ID = uuid.New()
+	token.CreatedAt = time.Now()
+	stored := *token
+	r.tokens[token.ID] = &stored
+	return nil
+}
+
+func (r *fakeRefreshTokenRepository) FindByHash(ctx context.Context, tokenHash string) (*dto.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, token := range r.tokens {
+		if token.TokenHash == tokenHash {
+			found := *token
+			return &found, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (r *fakeRefreshTokenRepository) MarkUsed(ctx context.Context, id uuid.UUID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[id]
+	if !ok || token.UsedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	token.UsedAt = &now
+	return true, nil
+}
+
+func (r *fakeRefreshTokenRepository) RevokeFamily(ctx context.Context, familyID uuid.UUID) error {
+	return r.revoke(func(token *dto.RefreshToken) bool { return token.FamilyID == familyID })
+}
+
+func (r *fakeRefreshTokenRepository) RevokeByUserID(ctx context.Context, userID uuid.UUID) error {
+	return r.revoke(func(token *dto.RefreshToken) bool { return token.UserID == userID })
+}
+
+func (r *fakeRefreshTokenRepository) revoke(match func(token *dto.RefreshToken) bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, token := range r.tokens {
+		if match(token) && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (r *fakeRefreshTokenRepository) DeleteExpired(ctx context.Context, now time.Time) error {
+	return nil
+}
+
+func (r *fakeRefreshTokenRepository) WithTx(tx *sql.Tx) repository.RefreshTokenRepository {
+	return r
+}
+
+// fakeSessionRepository only records revocations, the other methods are not used by the tests and panic
+type fakeSessionRepository struct {
+	repository.SessionRepository
+	mu      sync.Mutex
+	revoked []uuid.UUID
+}
+
+func (r *fakeSessionRepository) Revoke(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.revoked = append(r.revoked, id)
+	return true, nil
+}
+
+func (r *fakeSessionRepository) WithTx(tx *sql.Tx) repository.SessionRepository {
+	return r
+}
+
+func (p *fakeEmailProducer) ProduceMagicLinkEmail(ctx context.Context, tx *sql.Tx, email, magicLinkToken string) error {
+	return p.record("magic-link", email, magicLinkToken)
+}
+
+type fakeMagicLinkTokenRepository struct {
+	mu     sync.Mutex
+	tokens map[string]*dto.MagicLinkToken
+}
+
+func newFakeMagicLinkTokenRepository() *fakeMagicLinkTokenRepository {
+	return &fakeMagicLinkTokenRepository{tokens: make(map[string]*dto.MagicLinkToken)}
+}
+
+func (r *fakeMagicLinkTokenRepository) Create(ctx context.Context, token *dto.MagicLinkToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token.ID = uuid.New()
+	token.CreatedAt = time.Now()
+	stored := *token
+	r.tokens[token.TokenHash] = &stored
+	return nil
+}
+
+func (r *fakeMagicLinkTokenRepository) Delete(ctx context.Context, tokenHash string) (*dto.MagicLinkToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[tokenHash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	delete(r.tokens, tokenHash)
+	return token, nil
+}
+
+func (r *fakeMagicLinkTokenRepository) DeleteByUserID(ctx context.Context, userID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for hash, token := range r.tokens {
+		if token.UserID == userID {
+			delete(r.tokens, hash)
+		}
+	}
+	return nil
+}
+
+// backdate moves the creation of every token back by age
+func (r *fakeMagicLinkTokenRepository) backdate(age time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, token := range r.tokens {
+		token.CreatedAt = token.CreatedAt.Add(-age)
+	}
+}
+
+func (r *fakeMagicLinkTokenRepository) ClearFromOld(ctx context.Context, olderThan time.Time) error {
+	return nil
+}
+
+func (r *fakeMagicLinkTokenRepository) WithTx(tx *sql.Tx) repository.MagicLinkTokenRepository {
+	return r
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/yoshapihoff/bricks/auth/internal/auth"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+	"github.com/yoshapihoff/bricks/auth/internal/repository"
+)
+
+var (
+	ErrMagicLinkTokenNotFound = errors.New("magic link token not found")
+	ErrMagicLinkTokenExpired  = errors.New("magic link token expired")
+)
+
+type MagicLinkService interface {
+	// Send mails a sign in token when the address belongs to a user and does nothing otherwise
+	Send(ctx context.Context, email string) error
+	// Consume exchanges the token for the user it was sent to, a token only works once
+	Consume(ctx context.Context, token string) (*dto.User, error)
+}
+
+// MagicLinkEmailProducer enqueues the magic link email as part of the token transaction
+type MagicLinkEmailProducer interface {
+	ProduceMagicLinkEmail(ctx context.Context, tx *sql.Tx, email, magicLinkToken string) error
+}
+
+type DefaultMagicLinkService struct {
+	repo          repository.MagicLinkTokenRepository
+	userRepo      repository.UserRepository
+	transactor    repository.Transactor
+	emailProducer MagicLinkEmailProducer
+	expiration    time.Duration
+}
+
+func NewMagicLinkService(
+	repo repository.MagicLinkTokenRepository,
+	userRepo repository.UserRepository,
+	transactor repository.Transactor,
+	emailProducer MagicLinkEmailProducer,
+	expiration time.Duration,
+) *DefaultMagicLinkService {
+	return &DefaultMagicLinkService{
+		repo:          repo,
+		userRepo:      userRepo,
+		transactor:    transactor,
+		emailProducer: emailProducer,
+		expiration:    expiration,
+	}
+}
+
+func (s *DefaultMagicLinkService) Send(ctx context.Context, email string) error {
+	user, err := s.userRepo.FindByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	// Only the latest link works, so an older email left in the inbox is useless
+	return s.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if err := repo.DeleteByUserID(ctx, user.ID); err != nil {
+			return err
+		}
+		if err := repo.Create(ctx, &dto.MagicLinkToken{
+			UserID:    user.ID,
+			TokenHash: tokenHash,
+		}); err != nil {
+			return err
+		}
+		return s.emailProducer.ProduceMagicLinkEmail(ctx, tx, user.Email, token)
+	})
+}
+
+func (s *DefaultMagicLinkService) Consume(ctx context.Context, token string) (*dto.User, error) {
+	var user *dto.User
+	// Deleting the token claims it, so a concurrent request with the same token finds nothing
+	err := s.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		magicLinkToken, err := s.repo.WithTx(tx).Delete(ctx, auth.HashOpaqueToken(token))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrMagicLinkTokenNotFound
+			}
+			return err
+		}
+		if magicLinkToken.CreatedAt.Add(s.expiration).Before(time.Now()) {
+			return ErrMagicLinkTokenExpired
+		}
+
+		userRepo := s.userRepo.WithTx(tx)
+		user, err = userRepo.FindByID(ctx, magicLinkToken.UserID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		if user.EmailVerifiedAt != nil {
+			return nil
+		}
+
+		// Following the link proves the user owns the address
+		if err := userRepo.MarkEmailVerified(ctx, user.ID); err != nil {
+			return err
+		}
+		user, err = userRepo.FindByID(ctx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+)
+
+func newMagicLinkTestService(user *dto.User) (*DefaultMagicLinkService, *fakeMagicLinkTokenRepository, *fakeEmailProducer) {
+	tokens := newFakeMagicLinkTokenRepository()
+	producer := &fakeEmailProducer{}
+	return NewMagicLinkService(tokens, newFakeUserRepository(user), fakeTransactor{}, producer, 15*time.Minute), tokens, producer
+}
+
+// lastToken returns the token of the last magic link email
+func lastToken(t *testing.T, producer *fakeEmailProducer) string {
+	t.Helper()
+
+	sent := producer.sent()
+	if len(sent) == 0 {
+		t.Fatal("no magic link was sent")
+	}
+	return sent[len(sent)-1].token
+}
+
+func TestMagicLinkWorksOnce(t *testing.T) {
+	user := &dto.User{ID: uuid.New(), Email: "user@example.com"}
+	svc, _, producer := newMagicLinkTestService(user)
+	ctx := context.Background()
+
+	if err := svc.Send(ctx, user.Email); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	token := lastToken(t, producer)
+
+	got, err := svc.Consume(ctx, token)
+	if err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+	if got.ID != user.ID {
+		t.Errorf("user = %s, want %s", got.ID, user.ID)
+	}
+	if got.EmailVerifiedAt == nil {
+		t.Error("following the link did not verify the email")
+	}
+
+	if _, err := svc.Consume(ctx, token); !errors.Is(err, ErrMagicLinkTokenNotFound) {
+		t.Errorf("second Consume() error = %v, want %v", err, ErrMagicLinkTokenNotFound)
+	}
+}
+
+func TestMagicLinkOnlyTheLatestWorks(t *testing.T) {
+	user := &dto.User{ID: uuid.New(), Email: "user@example.com"}
+	svc, _, producer := newMagicLinkTestService(user)
+	ctx := context.Background()
+
+	if err := svc.Send(ctx, user.Email); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	first := lastToken(t, producer)
+	if err := svc.Send(ctx, user.Email); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	if _, err := svc.Consume(ctx, first); !errors.Is(err, ErrMagicLinkTokenNotFound) {
+		t.Errorf("Consume() of the replaced link: error = %v, want %v", err, ErrMagicLinkTokenNotFound)
+	}
+	if _, err := svc.Consume(ctx, lastToken(t, producer)); err != nil {
+		t.Errorf("Consume() of the latest link: %v", err)
+	}
+}
+
+func TestMagicLinkExpires(t *testing.T) {
+	user := &dto.User{ID: uuid.New(), Email: "user@example.com"}
+	svc, tokens, producer := newMagicLinkTestService(user)
+	ctx := context.Background()
+
+	if err := svc.Send(ctx, user.Email); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	token := lastToken(t, producer)
+	tokens.backdate(time.Hour)
+
+	if _, err := svc.Consume(ctx, token); !errors.Is(err, ErrMagicLinkTokenExpired) {
+		t.Errorf("Consume() error = %v, want %v", err, ErrMagicLinkTokenExpired)
+	}
+}
+
+func TestMagicLinkToUnknownAddressSendsNothing(t *testing.T) {
+	svc, _, producer := newMagicLinkTestService(&dto.User{ID: uuid.New(), Email: "user@example.com"})
+
+	if err := svc.Send(context.Background(), "unknown@example.com"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if sent := producer.sent(); len(sent) != 0 {
+		t.Errorf("sent %v, want nothing", sent)
+	}
+}
@@ -0,0 +1,310 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/auth"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+	"github.com/yoshapihoff/bricks/auth/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrMFAAlreadyEnabled    = errors.New("mfa is already enabled")
+	ErrMFANotEnabled        = errors.New("mfa is not enabled")
+	ErrInvalidMFACode       = errors.New("invalid mfa code")
+	ErrMFAChallengeNotFound = errors.New("mfa challenge not found")
+	ErrMFAChallengeExpired  = errors.New("mfa challenge expired")
+)
+
+const (
+	recoveryCodeCount = 10
+	recoveryCodeBytes = 10
+	// maxMFAChallengeAttempts is how many codes a challenge takes before the login has to start over
+	maxMFAChallengeAttempts = 5
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type MFAService interface {
+	// EnrollTOTP starts a TOTP enrollment and returns its secret and otpauth URI, it does nothing until confirmed
+	EnrollTOTP(ctx context.Context, userID uuid.UUID) (string, string, error)
+	// ConfirmTOTP enables MFA once the first code checks out and returns the recovery codes, they are only shown once
+	ConfirmTOTP(ctx context.Context, userID uuid.UUID, code string) ([]string, error)
+	// Disable turns MFA off, the user proves it is them with a current code and the password unless they have none
+	Disable(ctx context.Context, userID uuid.UUID, password, code string) error
+	// VerifyCode checks a current code or an unused recovery code of the user, sensitive changes use it to re-authenticate
+	VerifyCode(ctx context.Context, userID uuid.UUID, code string) error
+	// Enabled reports whether the user has to pass the second login step
+	Enabled(ctx context.Context, userID uuid.UUID) (bool, error)
+	// Challenge starts the second login step and returns its token
+	Challenge(ctx context.Context, userID uuid.UUID) (string, error)
+	// VerifyChallenge completes the login when code is a current TOTP code or an unused recovery code
+	VerifyChallenge(ctx context.Context, token, code string) (*dto.User, error)
+}
+
+type DefaultMFAService struct {
+	repo                repository.MFARepository
+	challengeRepo       repository.MFAChallengeRepository
+	userRepo            repository.UserRepository
+	transactor          repository.Transactor
+	issuer              string
+	challengeExpiration time.Duration
+}
+
+func NewMFAService(
+	repo repository.MFARepository,
+	challengeRepo repository.MFAChallengeRepository,
+	userRepo repository.UserRepository,
+	transactor repository.Transactor,
+	issuer string,
+	challengeExpiration time.Duration,
+) *DefaultMFAService {
+	return &DefaultMFAService{
+		repo:                repo,
+		challengeRepo:       challengeRepo,
+		userRepo:            userRepo,
+		transactor:          transactor,
+		issuer:              issuer,
+		challengeExpiration: challengeExpiration,
+	}
+}
+
+func (s *DefaultMFAService) EnrollTOTP(ctx context.Context, userID uuid.UUID) (string, string, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", ErrUserNotFound
+		}
+		return "", "", err
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := s.repo.SaveTOTP(ctx, &dto.UserTOTP{UserID: user.ID, Secret: secret}); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", ErrMFAAlreadyEnabled
+		}
+		return "", "", err
+	}
+
+	return secret, auth.TOTPURI(s.issuer, user.Email, secret), nil
+}
+
+func (s *DefaultMFAService) ConfirmTOTP(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	totp, err := s.repo.FindTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMFANotEnabled
+		}
+		return nil, err
+	}
+	if totp.ConfirmedAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	step, ok := auth.ValidateTOTP(totp.Secret, strings.TrimSpace(code), time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, codeHashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if err := repo.ConfirmTOTP(ctx, userID, step); err != nil {
+			return err
+		}
+		return repo.ReplaceRecoveryCodes(ctx, userID, codeHashes)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (s *DefaultMFAService) Disable(ctx context.Context, userID uuid.UUID, password, code string) error {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	// Users who only sign in through a provider have no password, the code alone proves it is them
+	if user.PasswordHash != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+			return ErrInvalidPassword
+		}
+	}
+	if err := s.verifyCode(ctx, userID, code); err != nil {
+		return err
+	}
+
+	return s.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if err := repo.DeleteTOTP(ctx, userID); err != nil {
+			return err
+		}
+		return repo.DeleteRecoveryCodes(ctx, userID)
+	})
+}
+
+func (s *DefaultMFAService) VerifyCode(ctx context.Context, userID uuid.UUID, code string) error {
+	return s.verifyCode(ctx, userID, code)
+}
+
+func (s *DefaultMFAService) Enabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	totp, err := s.repo.FindTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return totp.ConfirmedAt != nil, nil
+}
+
+func (s *DefaultMFAService) Challenge(ctx context.Context, userID uuid.UUID) (string, error) {
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.challengeRepo.Create(ctx, &dto.MFAChallenge{
+		UserID:    userID,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(s.challengeExpiration),
+	}); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (s *DefaultMFAService) VerifyChallenge(ctx context.Context, token, code string) (*dto.User, error) {
+	challenge, err := s.challengeRepo.FindByHash(ctx, auth.HashOpaqueToken(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMFAChallengeNotFound
+		}
+		return nil, err
+	}
+	if challenge.ExpiresAt.Before(time.Now()) {
+		return nil, ErrMFAChallengeExpired
+	}
+
+	// The attempt is counted before the code is checked, in one statement, so parallel requests
+	// with the same token cannot get more guesses than the cap between them
+	attempts, err := s.challengeRepo.RecordAttempt(ctx, challenge.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMFAChallengeNotFound
+		}
+		return nil, err
+	}
+	if attempts > maxMFAChallengeAttempts {
+		// Guessing further has to start over from the password
+		if _, err := s.challengeRepo.Delete(ctx, challenge.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrMFAChallengeNotFound
+	}
+
+	if err := s.verifyCode(ctx, challenge.UserID, code); err != nil {
+		return nil, err
+	}
+
+	// Deleting claims the challenge, a concurrent request with the same token finds nothing
+	claimed, err := s.challengeRepo.Delete(ctx, challenge.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrMFAChallengeNotFound
+	}
+
+	user, err := s.userRepo.FindByID(ctx, challenge.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// verifyCode accepts a current TOTP code or an unused recovery code, either one is spent by the check
+func (s *DefaultMFAService) verifyCode(ctx context.Context, userID uuid.UUID, code string) error {
+	totp, err := s.repo.FindTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrMFANotEnabled
+		}
+		return err
+	}
+	if totp.ConfirmedAt == nil {
+		return ErrMFANotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+	if step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now()); ok {
+		used, err := s.repo.UseTOTPStep(ctx, userID, step)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	used, err := s.repo.UseRecoveryCode(ctx, userID, auth.HashOpaqueToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// generateRecoveryCodes returns the codes to show the user and the hashes to store in their place
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	codeHashes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		b := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		codes = append(codes, code[:len(code)/2]+"-"+code[len(code)/2:])
+		codeHashes = append(codeHashes, auth.HashOpaqueToken(code))
+	}
+	return codes, codeHashes, nil
+}
+
+// normalizeRecoveryCode drops the separator and case so codes match however they were typed
+func normalizeRecoveryCode(code string) string {
+	code = strings.ReplaceAll(code, "-", "")
+	code = strings.ReplaceAll(code, " ", "")
+	return strings.ToLower(code)
+}
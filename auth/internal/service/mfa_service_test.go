@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/auth"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newMFATestService returns a service where the user has MFA enabled and the recovery codes they were shown
+func newMFATestService(t *testing.T, user *dto.User) (*DefaultMFAService, []string) {
+	t.Helper()
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("generate secret: %v", err)
+	}
+	codes, codeHashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("generate recovery codes: %v", err)
+	}
+
+	repo := newFakeMFARepository()
+	confirmedAt := time.Now()
+	if err := repo.SaveTOTP(context.Background(), &dto.UserTOTP{UserID: user.ID, Secret: secret, ConfirmedAt: &confirmedAt}); err != nil {
+		t.Fatalf("save totp: %v", err)
+	}
+	if err := repo.ReplaceRecoveryCodes(context.Background(), user.ID, codeHashes); err != nil {
+		t.Fatalf("save recovery codes: %v", err)
+	}
+
+	return NewMFAService(repo, newFakeMFAChallengeRepository(), newFakeUserRepository(user), fakeTransactor{}, "Bricks", time.Minute), codes
+}
+
+func TestDisableMFA(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		passwordHash string
+		password     string
+		validCode    bool
+		wantErr      error
+	}{
+		{name: "passwordless user with a recovery code", validCode: true},
+		{name: "passwordless user with a wrong code", wantErr: ErrInvalidMFACode},
+		{name: "password user with password and code", passwordHash: string(hash), password: "correct horse", validCode: true},
+		{name: "password user without the password", passwordHash: string(hash), validCode: true, wantErr: ErrInvalidPassword},
+		{name: "password user with a wrong password", passwordHash: string(hash), password: "wrong", validCode: true, wantErr: ErrInvalidPassword},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &dto.User{ID: uuid.New(), Email: "user@example.com", PasswordHash: tt.passwordHash}
+			svc, codes := newMFATestService(t, user)
+			ctx := context.Background()
+
+			code := "not-a-code"
+			if tt.validCode {
+				code = codes[0]
+			}
+			if err := svc.Disable(ctx, user.ID, tt.password, code); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Disable() error = %v, want %v", err, tt.wantErr)
+			}
+
+			enabled, err := svc.Enabled(ctx, user.ID)
+			if err != nil {
+				t.Fatalf("Enabled() error = %v", err)
+			}
+			if enabled != (tt.wantErr != nil) {
+				t.Errorf("Enabled() = %v after Disable() returned %v", enabled, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyChallengeCapsAttemptsBeforeCheckingTheCode(t *testing.T) {
+	user := &dto.User{ID: uuid.New(), Email: "user@example.com"}
+	svc, codes := newMFATestService(t, user)
+	ctx := context.Background()
+
+	token, err := svc.Challenge(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Challenge() error = %v", err)
+	}
+
+	// Parallel guesses on one token share the cap, none of them can slip in before a failure is recorded
+	var wg sync.WaitGroup
+	results := make(chan error, 2*maxMFAChallengeAttempts)
+	for range 2 * maxMFAChallengeAttempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := svc.VerifyChallenge(ctx, token, "000000")
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	var wrongCode, rejected int
+	for err := range results {
+		switch {
+		case errors.Is(err, ErrInvalidMFACode):
+			wrongCode++
+		case errors.Is(err, ErrMFAChallengeNotFound):
+			rejected++
+		default:
+			t.Errorf("VerifyChallenge() error = %v", err)
+		}
+	}
+	if wrongCode > maxMFAChallengeAttempts {
+		t.Errorf("%d codes were checked, want at most %d", wrongCode, maxMFAChallengeAttempts)
+	}
+
+	// Once the cap is reached even the right code has to start over from the password
+	if _, err := svc.VerifyChallenge(ctx, token, codes[0]); !errors.Is(err, ErrMFAChallengeNotFound) {
+		t.Errorf("VerifyChallenge() with a valid code after the cap: error = %v, want %v", err, ErrMFAChallengeNotFound)
+	}
+}
+
+func TestVerifyChallengeAcceptsCodeWithinTheCap(t *testing.T) {
+	user := &dto.User{ID: uuid.New(), Email: "user@example.com"}
+	svc, codes := newMFATestService(t, user)
+	ctx := context.Background()
+
+	token, err := svc.Challenge(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Challenge() error = %v", err)
+	}
+	for range maxMFAChallengeAttempts - 1 {
+		if _, err := svc.VerifyChallenge(ctx, token, "000000"); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("VerifyChallenge() error = %v, want %v", err, ErrInvalidMFACode)
+		}
+	}
+
+	got, err := svc.VerifyChallenge(ctx, token, codes[0])
+	if err != nil {
+		t.Fatalf("VerifyChallenge() on the last attempt: %v", err)
+	}
+	if got.ID != user.ID {
+		t.Errorf("user = %s, want %s", got.ID, user.ID)
+	}
+	if _, err := svc.VerifyChallenge(ctx, token, codes[1]); !errors.Is(err, ErrMFAChallengeNotFound) {
+		t.Errorf("reusing the challenge: error = %v, want %v", err, ErrMFAChallengeNotFound)
+	}
+}
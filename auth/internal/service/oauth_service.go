@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+	"github.com/yoshapihoff/bricks/auth/internal/oauth"
+	"github.com/yoshapihoff/bricks/auth/internal/repository"
+)
+
+var ErrUnverifiedExternalEmail = errors.New("external account email is not verified")
+
+type OAuthService interface {
+	// LoginWithIdentity returns the user linked to the external identity, linking or creating one by verified email
+	LoginWithIdentity(ctx context.Context, provider string, identity *oauth.Identity) (*dto.User, error)
+}
+
+type DefaultOAuthService struct {
+	userRepo     repository.UserRepository
+	identityRepo repository.UserIdentityRepository
+	transactor   repository.Transactor
+}
+
+func NewOAuthService(userRepo repository.UserRepository, identityRepo repository.UserIdentityRepository, transactor repository.Transactor) *DefaultOAuthService {
+	return &DefaultOAuthService{
+		userRepo:     userRepo,
+		identityRepo: identityRepo,
+		transactor:   transactor,
+	}
+}
+
+func (s *DefaultOAuthService) LoginWithIdentity(ctx context.Context, provider string, identity *oauth.Identity) (*dto.User, error) {
+	linked, err := s.identityRepo.Find(ctx, provider, identity.Subject)
+	if err == nil {
+		user, err := s.userRepo.FindByID(ctx, linked.UserID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, ErrUserNotFound
+			}
+			return nil, err
+		}
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	// Only a verified address proves the external account owns the local one
+	if !identity.EmailVerified || identity.Email == "" {
+		return nil, ErrUnverifiedExternalEmail
+	}
+
+	var user *dto.User
+	err = s.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		userRepo := s.userRepo.WithTx(tx)
+		user, err = userRepo.FindByEmail(ctx, identity.Email)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// Users created from an external identity have no password until they set one
+			verifiedAt := time.Now()
+			user = &dto.User{
+				ID:    uuid.New(),
+				Email: identity.Email,
+				Profile: dto.Profile{
+					Name: identity.Name,
+				},
+				EmailVerifiedAt: &verifiedAt,
+			}
+			err = userRepo.Create(ctx, user)
+		case err == nil && user.EmailVerifiedAt == nil:
+			// The provider verified the address, so the local account is verified too
+			err = userRepo.MarkEmailVerified(ctx, user.ID)
+		}
+		if err != nil {
+			return err
+		}
+
+		return s.identityRepo.WithTx(tx).Create(ctx, &dto.UserIdentity{
+			Provider: provider,
+			Subject:  identity.Subject,
+			UserID:   user.ID,
+			Email:    identity.Email,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+	"github.com/yoshapihoff/bricks/auth/internal/oauth"
+	"github.com/yoshapihoff/bricks/auth/internal/oauth/oauthtest"
+)
+
+const testOIDCProvider = "test-oidc"
+
+// identityFromIssuer runs the full authorization code flow against a fake provider that approves grant
+func identityFromIssuer(t *testing.T, grant oauthtest.Grant) *oauth.Identity {
+	t.Helper()
+
+	ctx := context.Background()
+	issuer := oauthtest.NewIssuer(t)
+	provider, err := oauth.NewOIDCProvider(ctx, oauth.ProviderConfig{
+		Name:         testOIDCProvider,
+		IssuerURL:    issuer.URL,
+		ClientID:     oauthtest.ClientID,
+		ClientSecret: oauthtest.ClientSecret,
+		RedirectURL:  "http://localhost/auth/oauth/test-oidc/callback",
+	})
+	if err != nil {
+		t.Fatalf("new oidc provider: %v", err)
+	}
+	manager := oauth.NewManager(oauth.NewMemoryStateStore(), time.Minute, provider)
+
+	authURL, state, err := manager.Start(ctx, testOIDCProvider)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	code, _ := issuer.Authorize(t, authURL, grant)
+	identity, err := manager.Finish(ctx, testOIDCProvider, state, code)
+	if err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	return identity
+}
+
+func TestLoginWithIdentityRefusesUnverifiedEmail(t *testing.T) {
+	existing := &dto.User{ID: uuid.New(), Email: "user@example.com", PasswordHash: "hash"}
+	userRepo := newFakeUserRepository(existing)
+	identityRepo := &fakeUserIdentityRepository{}
+	svc := NewOAuthService(userRepo, identityRepo, fakeTransactor{})
+
+	identity := identityFromIssuer(t, oauthtest.Grant{
+		Subject:       "attacker",
+		Email:         "user@example.com",
+		EmailVerified: false,
+	})
+
+	if _, err := svc.LoginWithIdentity(context.Background(), testOIDCProvider, identity); !errors.Is(err, ErrUnverifiedExternalEmail) {
+		t.Fatalf("error = %v, want %v", err, ErrUnverifiedExternalEmail)
+	}
+	if len(identityRepo.identities) != 0 {
+		t.Errorf("identity was linked: %+v", identityRepo.identities)
+	}
+}
+
+func TestLoginWithIdentityLinksExistingUserByVerifiedEmail(t *testing.T) {
+	existing := &dto.User{ID: uuid.New(), Email: "user@example.com", PasswordHash: "hash"}
+	userRepo := newFakeUserRepository(existing)
+	identityRepo := &fakeUserIdentityRepository{}
+	svc := NewOAuthService(userRepo, identityRepo, fakeTransactor{})
+
+	identity := identityFromIssuer(t, oauthtest.Grant{
+		Subject:       "subject-1",
+		Email:         "user@example.com",
+		EmailVerified: true,
+	})
+
+	user, err := svc.LoginWithIdentity(context.Background(), testOIDCProvider, identity)
+	if err != nil {
+		t.Fatalf("login with identity: %v", err)
+	}
+	if user.ID != existing.ID {
+		t.Errorf("user = %s, want the existing user %s", user.ID, existing.ID)
+	}
+	if len(userRepo.users) != 1 {
+		t.Errorf("users = %d, want no new user", len(userRepo.users))
+	}
+	if stored, _ := userRepo.FindByID(context.Background(), existing.ID); stored.EmailVerifiedAt == nil {
+		t.Error("existing user was not marked verified")
+	}
+	linked, err := identityRepo.Find(context.Background(), testOIDCProvider, "subject-1")
+	if err != nil || linked.UserID != existing.ID {
+		t.Fatalf("linked identity = %+v, %v", linked, err)
+	}
+
+	// The next login finds the user through the link
+	again, err := svc.LoginWithIdentity(context.Background(), testOIDCProvider, identity)
+	if err != nil || again.ID != existing.ID {
+		t.Errorf("second login = %v, %v, want the existing user", again, err)
+	}
+	if len(identityRepo.identities) != 1 {
+		t.Errorf("identities = %d, want 1", len(identityRepo.identities))
+	}
+}
+
+func TestLoginWithIdentityCreatesVerifiedUser(t *testing.T) {
+	userRepo := newFakeUserRepository()
+	identityRepo := &fakeUserIdentityRepository{}
+	svc := NewOAuthService(userRepo, identityRepo, fakeTransactor{})
+
+	identity := identityFromIssuer(t, oauthtest.Grant{
+		Subject:       "subject-2",
+		Email:         "new@example.com",
+		EmailVerified: true,
+		Name:          "New User",
+	})
+
+	user, err := svc.LoginWithIdentity(context.Background(), testOIDCProvider, identity)
+	if err != nil {
+		t.Fatalf("login with identity: %v", err)
+	}
+	if user.Email != "new@example.com" || user.Name != "New User" || user.EmailVerifiedAt == nil {
+		t.Errorf("user = %+v", user)
+	}
+	if user.PasswordHash != "" {
+		t.Error("user created from an external identity has a password")
+	}
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+	"github.com/yoshapihoff/bricks/auth/internal/repository"
+	"github.com/yoshapihoff/bricks/auth/internal/webauthn"
+)
+
+var ErrPasskeyNotFound = errors.New("passkey not found")
+
+const defaultPasskeyName = "Passkey"
+
+type PasskeyService interface {
+	BeginRegistration(ctx context.Context, userID uuid.UUID) (*webauthn.CreationOptions, error)
+	// FinishRegistration stores the credential created for the user's pending registration
+	FinishRegistration(ctx context.Context, userID uuid.UUID, name string, response *webauthn.RegistrationResponse) (*dto.Passkey, error)
+	List(ctx context.Context, userID uuid.UUID) ([]*dto.Passkey, error)
+	Delete(ctx context.Context, userID, id uuid.UUID) error
+	BeginLogin(ctx context.Context) (*webauthn.RequestOptions, error)
+	// FinishLogin verifies the assertion and returns the user the passkey belongs to
+	FinishLogin(ctx context.Context, response *webauthn.LoginResponse) (*dto.User, error)
+}
+
+type DefaultPasskeyService struct {
+	repo         repository.PasskeyRepository
+	userRepo     repository.UserRepository
+	relyingParty *webauthn.RelyingParty
+}
+
+func NewPasskeyService(repo repository.PasskeyRepository, userRepo repository.UserRepository, relyingParty *webauthn.RelyingParty) *DefaultPasskeyService {
+	return &DefaultPasskeyService{
+		repo:         repo,
+		userRepo:     userRepo,
+		relyingParty: relyingParty,
+	}
+}
+
+func (s *DefaultPasskeyService) BeginRegistration(ctx context.Context, userID uuid.UUID) (*webauthn.CreationOptions, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	passkeys, err := s.repo.FindByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	// Keeps the authenticator from registering a second credential for the same account
+	exclude := make([][]byte, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		exclude = append(exclude, passkey.CredentialID)
+	}
+
+	displayName := user.Name
+	if displayName == "" {
+		displayName = user.Email
+	}
+
+	// The user handle is the user ID, it is what a discoverable login gives back
+	return s.relyingParty.BeginRegistration(ctx, webauthn.User{
+		ID:          user.ID[:],
+		Name:        user.Email,
+		DisplayName: displayName,
+	}, exclude)
+}
+
+func (s *DefaultPasskeyService) FinishRegistration(ctx context.Context, userID uuid.UUID, name string, response *webauthn.RegistrationResponse) (*dto.Passkey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = defaultPasskeyName
+	}
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+
+	credential, err := s.relyingParty.FinishRegistration(ctx, userID[:], response)
+	if err != nil {
+		return nil, err
+	}
+
+	passkey := &dto.Passkey{
+		UserID:       userID,
+		CredentialID: credential.ID,
+		PublicKey:    credential.PublicKey,
+		SignCount:    credential.SignCount,
+		Name:         name,
+	}
+	if err := s.repo.Create(ctx, passkey); err != nil {
+		return nil, err
+	}
+
+	return passkey, nil
+}
+
+func (s *DefaultPasskeyService) List(ctx context.Context, userID uuid.UUID) ([]*dto.Passkey, error) {
+	return s.repo.FindByUserID(ctx, userID)
+}
+
+func (s *DefaultPasskeyService) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	deleted, err := s.repo.Delete(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+func (s *DefaultPasskeyService) BeginLogin(ctx context.Context) (*webauthn.RequestOptions, error) {
+	return s.relyingParty.BeginLogin(ctx)
+}
+
+func (s *DefaultPasskeyService) FinishLogin(ctx context.Context, response *webauthn.LoginResponse) (*dto.User, error) {
+	passkey, err := s.repo.FindByCredentialID(ctx, response.RawID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, webauthn.ErrInvalidCredential
+		}
+		return nil, err
+	}
+	if !bytes.Equal(response.Response.UserHandle, passkey.UserID[:]) {
+		return nil, webauthn.ErrInvalidCredential
+	}
+
+	signCount, err := s.relyingParty.FinishLogin(ctx, response, &webauthn.Credential{
+		ID:        passkey.CredentialID,
+		PublicKey: passkey.PublicKey,
+		SignCount: passkey.SignCount,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.UpdateSignCount(ctx, passkey.ID, signCount); err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.FindByID(ctx, passkey.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
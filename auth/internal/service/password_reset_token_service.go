@@ -7,8 +7,10 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/auth"
 	"github.com/yoshapihoff/bricks/auth/internal/dto"
 	"github.com/yoshapihoff/bricks/auth/internal/repository"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var (
@@ -18,31 +20,95 @@ var (
 
 type PasswordResetTokenService interface {
 	Create(ctx context.Context, userEmail string) (*dto.PasswordResetToken, error)
-	ReceiveUserIdByToken(ctx context.Context, token uuid.UUID, expiration time.Duration) (uuid.UUID, error)
+	// ResetPassword consumes the token, sets the new password and returns the user it belonged to
+	ResetPassword(ctx context.Context, token string, newPassword string, expiration time.Duration) (uuid.UUID, error)
 	ClearFromOld(ctx context.Context, olderThan time.Time) error
 }
 
+// ForgotPasswordEmailProducer enqueues the reset emails as part of the token transaction
+type ForgotPasswordEmailProducer interface {
+	ProduceForgotPasswordEmail(ctx context.Context, tx *sql.Tx, email, resetPasswordToken string) error
+	ProducePasswordResetConfirmation(ctx context.Context, tx *sql.Tx, email string) error
+}
+
 type DefaultPasswordResetTokenService struct {
-	repo        repository.PasswordResetTokenRepository
-	userService UserService
+	repo          repository.PasswordResetTokenRepository
+	userRepo      repository.UserRepository
+	userService   UserService
+	transactor    repository.Transactor
+	emailProducer ForgotPasswordEmailProducer
 }
 
-func NewPasswordResetTokenService(repo repository.PasswordResetTokenRepository, userService UserService) *DefaultPasswordResetTokenService {
-	return &DefaultPasswordResetTokenService{repo: repo, userService: userService}
+func NewPasswordResetTokenService(
+	repo repository.PasswordResetTokenRepository,
+	userRepo repository.UserRepository,
+	userService UserService,
+	transactor repository.Transactor,
+	emailProducer ForgotPasswordEmailProducer,
+) *DefaultPasswordResetTokenService {
+	return &DefaultPasswordResetTokenService{
+		repo:          repo,
+		userRepo:      userRepo,
+		userService:   userService,
+		transactor:    transactor,
+		emailProducer: emailProducer,
+	}
 }
 
-func (p *DefaultPasswordResetTokenService) ReceiveUserIdByToken(ctx context.Context, token uuid.UUID, expiration time.Duration) (uuid.UUID, error) {
-	passwordResetToken, err := p.repo.Find(ctx, token)
+func (p *DefaultPasswordResetTokenService) ResetPassword(ctx context.Context, token string, newPassword string, expiration time.Duration) (uuid.UUID, error) {
+	if len(newPassword) < 8 {
+		return uuid.UUID{}, ErrWeakPassword
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return uuid.UUID{}, ErrPasswordResetTokenNotFound
+		return uuid.UUID{}, err
+	}
+
+	var (
+		userID uuid.UUID
+		email  string
+	)
+	// Deleting the token claims it, so a concurrent request with the same token finds nothing.
+	// Only the hash is looked up, so lookup timing reveals nothing about stored tokens.
+	err = p.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		passwordResetToken, err := p.repo.WithTx(tx).Delete(ctx, auth.HashOpaqueToken(token))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrPasswordResetTokenNotFound
+			}
+			return err
+		}
+		if passwordResetToken.CreatedAt.Add(expiration).Before(time.Now()) {
+			return ErrPasswordResetTokenExpired
+		}
+
+		userRepo := p.userRepo.WithTx(tx)
+		user, err := userRepo.FindByID(ctx, passwordResetToken.UserID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUserNotFound
+			}
+			return err
 		}
+		if err := userRepo.UpdatePasswordHash(ctx, user.ID, string(hashedPassword)); err != nil {
+			return err
+		}
+
+		userID = user.ID
+		email = user.Email
+		return p.emailProducer.ProducePasswordResetConfirmation(ctx, tx, user.Email)
+	})
+	if err != nil {
 		return uuid.UUID{}, err
 	}
-	if passwordResetToken.CreatedAt.Add(expiration).Before(time.Now()) {
-		return uuid.UUID{}, ErrPasswordResetTokenExpired
+
+	// Owning the mailbox is enough to lift a lockout
+	if err := p.userService.UnlockLogin(ctx, email); err != nil {
+		return uuid.UUID{}, err
 	}
-	return passwordResetToken.UserID, nil
+
+	return userID, nil
 }
 
 func (p *DefaultPasswordResetTokenService) ClearFromOld(ctx context.Context, olderThan time.Time) error {
@@ -54,11 +120,24 @@ func (p *DefaultPasswordResetTokenService) Create(ctx context.Context, userEmail
 	if err != nil {
 		return nil, err
 	}
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
 	passwordResetToken := &dto.PasswordResetToken{
-		UserID: user.ID,
+		UserID:    user.ID,
+		Token:     token,
+		TokenHash: tokenHash,
 	}
 
-	if err := p.repo.Create(ctx, passwordResetToken); err != nil {
+	// The token and its email are committed together so neither can exist without the other
+	err = p.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		if err := p.repo.WithTx(tx).Create(ctx, passwordResetToken); err != nil {
+			return err
+		}
+		return p.emailProducer.ProduceForgotPasswordEmail(ctx, tx, user.Email, passwordResetToken.Token)
+	})
+	if err != nil {
 		return nil, err
 	}
 
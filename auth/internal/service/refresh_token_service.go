@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/auth"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+	"github.com/yoshapihoff/bricks/auth/internal/repository"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+type RefreshTokenService interface {
+	// Issue starts the token family of the session and returns the plaintext token
+	Issue(ctx context.Context, userID, sessionID uuid.UUID) (string, error)
+	// Rotate exchanges a refresh token for a new one in the same family and returns the user and session it belongs to
+	Rotate(ctx context.Context, token string) (uuid.UUID, uuid.UUID, string, error)
+	// Revoke revokes the family of the token if it belongs to the user
+	Revoke(ctx context.Context, userID uuid.UUID, token string) error
+}
+
+type DefaultRefreshTokenService struct {
+	repo        repository.RefreshTokenRepository
+	sessionRepo repository.SessionRepository
+	transactor  repository.Transactor
+	expiration  time.Duration
+}
+
+func NewRefreshTokenService(repo repository.RefreshTokenRepository, sessionRepo repository.SessionRepository, transactor repository.Transactor, expiration time.Duration) *DefaultRefreshTokenService {
+	return &DefaultRefreshTokenService{
+		repo:        repo,
+		sessionRepo: sessionRepo,
+		transactor:  transactor,
+		expiration:  expiration,
+	}
+}
+
+func (s *DefaultRefreshTokenService) Issue(ctx context.Context, userID, sessionID uuid.UUID) (string, error) {
+	// The family ID is the session ID, revoking the session revokes its refresh tokens
+	return s.create(ctx, s.repo, userID, sessionID)
+}
+
+func (s *DefaultRefreshTokenService) Rotate(ctx context.Context, token string) (uuid.UUID, uuid.UUID, string, error) {
+	var (
+		userID   uuid.UUID
+		familyID uuid.UUID
+		newToken string
+	)
+	err := s.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		repo := s.repo.WithTx(tx)
+		refreshToken, err := repo.FindByHash(ctx, auth.HashOpaqueToken(token))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+		if refreshToken.RevokedAt != nil {
+			return ErrInvalidRefreshToken
+		}
+		userID = refreshToken.UserID
+		familyID = refreshToken.FamilyID
+
+		marked, err := repo.MarkUsed(ctx, refreshToken.ID)
+		if err != nil {
+			return err
+		}
+		if !marked {
+			return ErrRefreshTokenReused
+		}
+		if refreshToken.ExpiresAt.Before(time.Now()) {
+			return ErrRefreshTokenExpired
+		}
+
+		newToken, err = s.create(ctx, repo, refreshToken.UserID, refreshToken.FamilyID)
+		return err
+	})
+	if errors.Is(err, ErrRefreshTokenReused) {
+		// A replayed token means the family leaked, revoke it and its session outside the rolled back transaction
+		if err := s.repo.RevokeFamily(ctx, familyID); err != nil {
+			return uuid.UUID{}, uuid.UUID{}, "", err
+		}
+		if _, err := s.sessionRepo.Revoke(ctx, userID, familyID); err != nil {
+			return uuid.UUID{}, uuid.UUID{}, "", err
+		}
+	}
+	if err != nil {
+		return uuid.UUID{}, uuid.UUID{}, "", err
+	}
+
+	return userID, familyID, newToken, nil
+}
+
+func (s *DefaultRefreshTokenService) Revoke(ctx context.Context, userID uuid.UUID, token string) error {
+	refreshToken, err := s.repo.FindByHash(ctx, auth.HashOpaqueToken(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidRefreshToken
+		}
+		return err
+	}
+	if refreshToken.UserID != userID {
+		return ErrInvalidRefreshToken
+	}
+
+	return s.repo.RevokeFamily(ctx, refreshToken.FamilyID)
+}
+
+func (s *DefaultRefreshTokenService) create(ctx context.Context, repo repository.RefreshTokenRepository, userID, familyID uuid.UUID) (string, error) {
+	token, tokenHash, err := auth.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	if err := repo.Create(ctx, &dto.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(s.expiration),
+	}); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
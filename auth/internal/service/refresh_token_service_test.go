@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRotateIssuesTheNextTokenOfTheFamily(t *testing.T) {
+	svc := NewRefreshTokenService(newFakeRefreshTokenRepository(), &fakeSessionRepository{}, fakeTransactor{}, time.Hour)
+	ctx := context.Background()
+	userID, sessionID := uuid.New(), uuid.New()
+
+	token, err := svc.Issue(ctx, userID, sessionID)
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	for range 3 {
+		gotUser, gotSession, next, err := svc.Rotate(ctx, token)
+		if err != nil {
+			t.Fatalf("Rotate() error = %v", err)
+		}
+		if gotUser != userID || gotSession != sessionID {
+			t.Errorf("Rotate() = %s, %s, want %s, %s", gotUser, gotSession, userID, sessionID)
+		}
+		if next == token {
+			t.Fatal("Rotate() returned the same token")
+		}
+		token = next
+	}
+}
+
+func TestRotateReusedTokenRevokesTheFamilyAndSession(t *testing.T) {
+	sessions := &fakeSessionRepository{}
+	svc := NewRefreshTokenService(newFakeRefreshTokenRepository(), sessions, fakeTransactor{}, time.Hour)
+	ctx := context.Background()
+	sessionID := uuid.New()
+
+	stolen, err := svc.Issue(ctx, uuid.New(), sessionID)
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	_, _, current, err := svc.Rotate(ctx, stolen)
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+
+	if _, _, _, err := svc.Rotate(ctx, stolen); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("Rotate() of a used token: error = %v, want %v", err, ErrRefreshTokenReused)
+	}
+	// The legitimate holder is logged out too, the family can no longer be trusted
+	if _, _, _, err := svc.Rotate(ctx, current); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Rotate() of the latest token after reuse: error = %v, want %v", err, ErrInvalidRefreshToken)
+	}
+	if !slices.Equal(sessions.revoked, []uuid.UUID{sessionID}) {
+		t.Errorf("revoked sessions = %v, want [%s]", sessions.revoked, sessionID)
+	}
+}
+
+func TestRotateConcurrentlyLetsOneRequestThrough(t *testing.T) {
+	svc := NewRefreshTokenService(newFakeRefreshTokenRepository(), &fakeSessionRepository{}, fakeTransactor{}, time.Hour)
+	ctx := context.Background()
+
+	token, err := svc.Issue(ctx, uuid.New(), uuid.New())
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, _, err := svc.Rotate(ctx, token)
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	var rotated int
+	for err := range results {
+		switch {
+		case err == nil:
+			rotated++
+		// The losers look like a replay, once the family is revoked the token is just invalid
+		case errors.Is(err, ErrRefreshTokenReused), errors.Is(err, ErrInvalidRefreshToken):
+		default:
+			t.Errorf("Rotate() error = %v", err)
+		}
+	}
+	if rotated != 1 {
+		t.Errorf("%d rotations went through, want 1", rotated)
+	}
+}
+
+func TestRotateExpiredToken(t *testing.T) {
+	svc := NewRefreshTokenService(newFakeRefreshTokenRepository(), &fakeSessionRepository{}, fakeTransactor{}, -time.Minute)
+	ctx := context.Background()
+
+	token, err := svc.Issue(ctx, uuid.New(), uuid.New())
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	if _, _, _, err := svc.Rotate(ctx, token); !errors.Is(err, ErrRefreshTokenExpired) {
+		t.Errorf("error = %v, want %v", err, ErrRefreshTokenExpired)
+	}
+}
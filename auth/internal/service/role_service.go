@@ -0,0 +1,277 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+	"github.com/yoshapihoff/bricks/auth/internal/repository"
+	"github.com/yoshapihoff/bricks/auth/pkg/authz"
+)
+
+var (
+	ErrRoleNotFound          = errors.New("role not found")
+	ErrRoleExists            = errors.New("role already exists")
+	ErrProtectedRole         = errors.New("role cannot be changed")
+	ErrPermissionNotFound    = errors.New("permission not found")
+	ErrPermissionExists      = errors.New("permission already exists")
+	ErrInvalidRoleName       = errors.New("invalid role name")
+	ErrInvalidPermissionName = errors.New("invalid permission name")
+	ErrInvalidDescription    = errors.New("invalid description")
+	ErrLastAdmin             = errors.New("cannot unassign the last admin")
+)
+
+// AdminRole is seeded with the permissions that manage roles, it cannot be edited or taken from its last holder so it cannot be lost
+const AdminRole = "admin"
+
+const maxDescriptionLength = 255
+
+// grantNamePattern matches role and permission names such as "admin" or "orders:write"
+var grantNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_.:-]{0,63}$`)
+
+type RoleService interface {
+	ListRoles(ctx context.Context) ([]*dto.Role, error)
+	CreateRole(ctx context.Context, name, description string, permissions []string) (*dto.Role, error)
+	// SetRolePermissions replaces the role's permissions, users get them with their next token
+	SetRolePermissions(ctx context.Context, name string, permissions []string) (*dto.Role, error)
+	DeleteRole(ctx context.Context, name string) error
+	ListPermissions(ctx context.Context) ([]*dto.Permission, error)
+	CreatePermission(ctx context.Context, name, description string) (*dto.Permission, error)
+	UserRoles(ctx context.Context, userID uuid.UUID) ([]string, error)
+	AssignRole(ctx context.Context, userID uuid.UUID, role string) error
+	UnassignRole(ctx context.Context, userID uuid.UUID, role string) error
+}
+
+type DefaultRoleService struct {
+	repo       repository.RoleRepository
+	userRepo   repository.UserRepository
+	transactor repository.Transactor
+}
+
+func NewRoleService(repo repository.RoleRepository, userRepo repository.UserRepository, transactor repository.Transactor) *DefaultRoleService {
+	return &DefaultRoleService{
+		repo:       repo,
+		userRepo:   userRepo,
+		transactor: transactor,
+	}
+}
+
+func (s *DefaultRoleService) ListRoles(ctx context.Context) ([]*dto.Role, error) {
+	return s.repo.FindRoles(ctx)
+}
+
+func (s *DefaultRoleService) CreateRole(ctx context.Context, name, description string, permissions []string) (*dto.Role, error) {
+	if !grantNamePattern.MatchString(name) {
+		return nil, ErrInvalidRoleName
+	}
+	description = strings.TrimSpace(description)
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		return nil, ErrInvalidDescription
+	}
+
+	role := &dto.Role{Name: name, Description: description}
+	err := s.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if err := repo.CreateRole(ctx, role); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrRoleExists
+			}
+			return err
+		}
+
+		return s.replacePermissions(ctx, repo, role, permissions)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+func (s *DefaultRoleService) SetRolePermissions(ctx context.Context, name string, permissions []string) (*dto.Role, error) {
+	if name == AdminRole {
+		return nil, ErrProtectedRole
+	}
+
+	var role *dto.Role
+	err := s.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		repo := s.repo.WithTx(tx)
+		var err error
+		role, err = repo.FindRole(ctx, name)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrRoleNotFound
+			}
+			return err
+		}
+
+		return s.replacePermissions(ctx, repo, role, permissions)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+func (s *DefaultRoleService) DeleteRole(ctx context.Context, name string) error {
+	if name == AdminRole {
+		return ErrProtectedRole
+	}
+
+	deleted, err := s.repo.DeleteRole(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrRoleNotFound
+	}
+	return nil
+}
+
+func (s *DefaultRoleService) ListPermissions(ctx context.Context) ([]*dto.Permission, error) {
+	return s.repo.FindPermissions(ctx)
+}
+
+func (s *DefaultRoleService) CreatePermission(ctx context.Context, name, description string) (*dto.Permission, error) {
+	if !grantNamePattern.MatchString(name) {
+		return nil, ErrInvalidPermissionName
+	}
+	description = strings.TrimSpace(description)
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		return nil, ErrInvalidDescription
+	}
+
+	permission := &dto.Permission{Name: name, Description: description}
+	if err := s.repo.CreatePermission(ctx, permission); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPermissionExists
+		}
+		return nil, err
+	}
+
+	return permission, nil
+}
+
+func (s *DefaultRoleService) UserRoles(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	if _, err := s.findUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	roles, err := s.repo.FindUserRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if roles == nil {
+		roles = []string{}
+	}
+	return roles, nil
+}
+
+func (s *DefaultRoleService) AssignRole(ctx context.Context, userID uuid.UUID, role string) error {
+	if _, err := s.findUser(ctx, userID); err != nil {
+		return err
+	}
+	if _, err := s.repo.FindRole(ctx, role); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRoleNotFound
+		}
+		return err
+	}
+
+	return s.repo.AssignRole(ctx, userID, role)
+}
+
+func (s *DefaultRoleService) UnassignRole(ctx context.Context, userID uuid.UUID, role string) error {
+	return s.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		repo := s.repo.WithTx(tx)
+		if role == AdminRole {
+			if err := keepLastAdmin(ctx, repo, userID); err != nil {
+				return err
+			}
+		}
+
+		unassigned, err := repo.UnassignRole(ctx, userID, role)
+		if err != nil {
+			return err
+		}
+		if !unassigned {
+			return ErrRoleNotFound
+		}
+		return nil
+	})
+}
+
+// keepLastAdmin refuses to take the admin role from the only user who has it, nobody could manage roles afterwards.
+// The role is locked first so two admins unassigning each other at once cannot both pass the check.
+func keepLastAdmin(ctx context.Context, repo repository.RoleRepository, userID uuid.UUID) error {
+	if err := repo.LockRole(ctx, AdminRole); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRoleNotFound
+		}
+		return err
+	}
+
+	holders, err := repo.CountRoleHolders(ctx, AdminRole)
+	if err != nil || holders > 1 {
+		return err
+	}
+	roles, err := repo.FindUserRoles(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(roles, AdminRole) {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+func (s *DefaultRoleService) findUser(ctx context.Context, userID uuid.UUID) (*dto.User, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *DefaultRoleService) replacePermissions(ctx context.Context, repo repository.RoleRepository, role *dto.Role, permissions []string) error {
+	permissions = slices.Clone(permissions)
+	slices.Sort(permissions)
+	permissions = slices.Compact(permissions)
+	if permissions == nil {
+		permissions = []string{}
+	}
+
+	if err := repo.ReplaceRolePermissions(ctx, role.Name, permissions); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrPermissionNotFound
+		}
+		return err
+	}
+
+	role.Permissions = permissions
+	return nil
+}
+
+// loadGrants reads the user's roles and the permissions they give for an access token
+func loadGrants(ctx context.Context, repo repository.RoleRepository, userID uuid.UUID) (authz.Grants, error) {
+	roles, err := repo.FindUserRoles(ctx, userID)
+	if err != nil {
+		return authz.Grants{}, err
+	}
+	permissions, err := repo.FindUserPermissions(ctx, userID)
+	if err != nil {
+		return authz.Grants{}, err
+	}
+
+	return authz.Grants{Roles: roles, Permissions: permissions}, nil
+}
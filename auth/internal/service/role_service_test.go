@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestUnassignRoleKeepsLastAdmin(t *testing.T) {
+	ctx := context.Background()
+	first, second, other := uuid.New(), uuid.New(), uuid.New()
+	repo := newFakeRoleRepository(AdminRole, "support")
+	for _, userID := range []uuid.UUID{first, second} {
+		if err := repo.AssignRole(ctx, userID, AdminRole); err != nil {
+			t.Fatalf("assign admin: %v", err)
+		}
+	}
+	svc := NewRoleService(repo, nil, fakeTransactor{})
+
+	if err := svc.UnassignRole(ctx, first, AdminRole); err != nil {
+		t.Fatalf("unassigning one of two admins: %v", err)
+	}
+	if err := svc.UnassignRole(ctx, other, AdminRole); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("unassigning from a non admin: error = %v, want %v", err, ErrRoleNotFound)
+	}
+	if err := svc.UnassignRole(ctx, second, AdminRole); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("unassigning the last admin: error = %v, want %v", err, ErrLastAdmin)
+	}
+
+	roles, err := repo.FindUserRoles(ctx, second)
+	if err != nil {
+		t.Fatalf("find user roles: %v", err)
+	}
+	if !slices.Contains(roles, AdminRole) {
+		t.Errorf("last admin has roles %v, want admin kept", roles)
+	}
+}
+
+func TestUnassignRoleOtherRolesCanBeEmptied(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	repo := newFakeRoleRepository(AdminRole, "support")
+	if err := repo.AssignRole(ctx, userID, "support"); err != nil {
+		t.Fatalf("assign support: %v", err)
+	}
+	svc := NewRoleService(repo, nil, fakeTransactor{})
+
+	if err := svc.UnassignRole(ctx, userID, "support"); err != nil {
+		t.Errorf("unassigning the last holder of another role: %v", err)
+	}
+	if err := svc.UnassignRole(ctx, userID, "support"); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("unassigning twice: error = %v, want %v", err, ErrRoleNotFound)
+	}
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+	"github.com/yoshapihoff/bricks/auth/internal/repository"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionRevoked  = errors.New("session revoked")
+)
+
+const (
+	// sessionTouchInterval throttles last seen updates so authenticated requests do not all write
+	sessionTouchInterval = time.Minute
+	maxUserAgentLength   = 512
+	unknownDevice        = "Unknown device"
+)
+
+type SessionService interface {
+	// Start stores a new session, the device is derived from the user agent
+	Start(ctx context.Context, session *dto.Session) error
+	List(ctx context.Context, userID uuid.UUID) ([]*dto.Session, error)
+	// Touch checks the session is still active for the user and records that it was seen
+	Touch(ctx context.Context, userID, sessionID uuid.UUID) error
+	// Revoke ends the session and revokes its refresh tokens
+	Revoke(ctx context.Context, userID, sessionID uuid.UUID) error
+	RevokeAll(ctx context.Context, userID uuid.UUID) error
+}
+
+type DefaultSessionService struct {
+	repo             repository.SessionRepository
+	refreshTokenRepo repository.RefreshTokenRepository
+	transactor       repository.Transactor
+}
+
+func NewSessionService(repo repository.SessionRepository, refreshTokenRepo repository.RefreshTokenRepository, transactor repository.Transactor) *DefaultSessionService {
+	return &DefaultSessionService{
+		repo:             repo,
+		refreshTokenRepo: refreshTokenRepo,
+		transactor:       transactor,
+	}
+}
+
+func (s *DefaultSessionService) Start(ctx context.Context, session *dto.Session) error {
+	session.UserAgent = truncateUserAgent(session.UserAgent)
+	session.Device = deviceName(session.UserAgent)
+
+	return s.repo.Create(ctx, session)
+}
+
+func (s *DefaultSessionService) List(ctx context.Context, userID uuid.UUID) ([]*dto.Session, error) {
+	return s.repo.FindActiveByUserID(ctx, userID)
+}
+
+func (s *DefaultSessionService) Touch(ctx context.Context, userID, sessionID uuid.UUID) error {
+	session, err := s.repo.FindByID(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSessionNotFound
+		}
+		return err
+	}
+	if session.UserID != userID {
+		return ErrSessionNotFound
+	}
+	if session.RevokedAt != nil {
+		return ErrSessionRevoked
+	}
+
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) < sessionTouchInterval {
+		return nil
+	}
+	return s.repo.UpdateLastSeen(ctx, sessionID, now)
+}
+
+func (s *DefaultSessionService) Revoke(ctx context.Context, userID, sessionID uuid.UUID) error {
+	return s.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		revoked, err := s.repo.WithTx(tx).Revoke(ctx, userID, sessionID)
+		if err != nil {
+			return err
+		}
+		if !revoked {
+			return ErrSessionNotFound
+		}
+
+		return s.refreshTokenRepo.WithTx(tx).RevokeFamily(ctx, sessionID)
+	})
+}
+
+func (s *DefaultSessionService) RevokeAll(ctx context.Context, userID uuid.UUID) error {
+	return s.transactor.WithinTransaction(ctx, func(tx *sql.Tx) error {
+		if err := s.repo.WithTx(tx).RevokeByUserID(ctx, userID); err != nil {
+			return err
+		}
+
+		return s.refreshTokenRepo.WithTx(tx).RevokeByUserID(ctx, userID)
+	})
+}
+
+// truncateUserAgent cuts the user agent to maxUserAgentLength bytes without splitting a character.
+// Invalid UTF-8 is replaced first, Postgres would reject the insert and with it the login.
+func truncateUserAgent(userAgent string) string {
+	userAgent = strings.ToValidUTF8(userAgent, string(utf8.RuneError))
+	if len(userAgent) <= maxUserAgentLength {
+		return userAgent
+	}
+
+	end := maxUserAgentLength
+	for end > 0 && !utf8.RuneStart(userAgent[end]) {
+		end--
+	}
+	return userAgent[:end]
+}
+
+// deviceName gives a short label like "Firefox on Windows" for a user agent, it only knows common browsers
+func deviceName(userAgent string) string {
+	var browser string
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(userAgent, "Firefox/"), strings.Contains(userAgent, "FxiOS/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"), strings.Contains(userAgent, "CriOS/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	}
+
+	// iOS and Android agents also mention macOS and Linux, so they are matched first
+	var platform string
+	switch {
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"):
+		platform = "iOS"
+	case strings.Contains(userAgent, "Android"):
+		platform = "Android"
+	case strings.Contains(userAgent, "Windows"):
+		platform = "Windows"
+	case strings.Contains(userAgent, "Mac OS X"):
+		platform = "macOS"
+	case strings.Contains(userAgent, "CrOS"):
+		platform = "ChromeOS"
+	case strings.Contains(userAgent, "Linux"):
+		platform = "Linux"
+	}
+
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	}
+	return unknownDevice
+}
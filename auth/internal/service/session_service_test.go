@@ -0,0 +1,34 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateUserAgent(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{name: "short", userAgent: "Mozilla/5.0", want: "Mozilla/5.0"},
+		{name: "exactly the limit", userAgent: strings.Repeat("a", maxUserAgentLength), want: strings.Repeat("a", maxUserAgentLength)},
+		{name: "ascii over the limit", userAgent: strings.Repeat("a", maxUserAgentLength+10), want: strings.Repeat("a", maxUserAgentLength)},
+		// The three byte character straddles the limit and is dropped whole
+		{name: "multibyte at the limit", userAgent: strings.Repeat("a", maxUserAgentLength-1) + "日本", want: strings.Repeat("a", maxUserAgentLength-1)},
+		{name: "multibyte throughout", userAgent: strings.Repeat("日", maxUserAgentLength), want: strings.Repeat("日", maxUserAgentLength/3)},
+		{name: "invalid utf-8", userAgent: "Mozilla\xff/5.0", want: "Mozilla�/5.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateUserAgent(tt.userAgent)
+			if got != tt.want {
+				t.Errorf("truncateUserAgent() = %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) || len(got) > maxUserAgentLength {
+				t.Errorf("truncateUserAgent() returned %d bytes, valid utf-8 %v", len(got), utf8.ValidString(got))
+			}
+		})
+	}
+}
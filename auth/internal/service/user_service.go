@@ -5,42 +5,120 @@ import (
 	"database/sql"
 	"errors"
 	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/yoshapihoff/bricks/auth/internal/auth"
 	"github.com/yoshapihoff/bricks/auth/internal/dto"
+	"github.com/yoshapihoff/bricks/auth/internal/ratelimit"
 	"github.com/yoshapihoff/bricks/auth/internal/repository"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/language"
 )
 
 var (
-	ErrUserNotFound    = errors.New("user not found")
-	ErrEmailExists     = errors.New("email already exists")
-	ErrInvalidEmail    = errors.New("invalid email")
-	ErrInvalidPassword = errors.New("invalid password")
-	ErrWeakPassword    = errors.New("password is too weak")
+	ErrUserNotFound     = errors.New("user not found")
+	ErrEmailExists      = errors.New("email already exists")
+	ErrInvalidEmail     = errors.New("invalid email")
+	ErrInvalidPassword  = errors.New("invalid password")
+	ErrWeakPassword     = errors.New("password is too weak")
+	ErrInvalidName      = errors.New("invalid name")
+	ErrInvalidLocale    = errors.New("invalid locale")
+	ErrInvalidTimezone  = errors.New("invalid timezone")
+	ErrInvalidAvatarURL = errors.New("invalid avatar url")
+	ErrEmailNotVerified = errors.New("email is not verified")
+	// ErrInvalidCredentials is the only login failure, so it does not tell unknown emails from wrong passwords
+	ErrInvalidCredentials = errors.New("invalid email or password")
 )
 
+const maxNameLength = 255
+
+// dummyPasswordHash is compared against when there is no password to check, so every failed login costs one bcrypt compare
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// ProfileUpdate lists the profile fields to change, nil fields are left as they are
+type ProfileUpdate struct {
+	Name      *string
+	Locale    *string
+	Timezone  *string
+	AvatarURL *string
+}
+
+// LockoutPolicy locks an email after repeated failed logins and slows down the failures before that.
+// It applies to every email alike, known or not, so neither the delays nor the lock reveal which accounts exist.
+type LockoutPolicy struct {
+	// Threshold is the number of consecutive failures that lock the email, zero disables the lock and the delays
+	Threshold int
+	// Duration is how long the lock lasts, failures further apart than that are not counted as consecutive
+	Duration time.Duration
+	// DelayBase is the delay after the first failure, it doubles with every further failure up to DelayMax
+	DelayBase time.Duration
+	DelayMax  time.Duration
+}
+
+// delay returns how long to hold the response after the given number of consecutive failures
+func (p LockoutPolicy) delay(failures int) time.Duration {
+	if failures <= 0 || p.DelayBase <= 0 {
+		return 0
+	}
+	delay := p.DelayBase
+	for i := 1; i < failures && delay < p.DelayMax; i++ {
+		delay *= 2
+	}
+	return min(delay, p.DelayMax)
+}
+
 type UserService interface {
 	Register(ctx context.Context, email, password, name string) (*dto.User, error)
-	Login(ctx context.Context, email, password string) (string, error)
+	// Authenticate checks the password and returns the user, the caller decides whether a second factor is needed
+	Authenticate(ctx context.Context, email, password string) (*dto.User, error)
+	// IssueToken returns an access token for the user, it fails while the email is unverified if verification is required
+	IssueToken(ctx context.Context, user *dto.User, sessionID uuid.UUID) (string, error)
 	ValidateToken(ctx context.Context, tokenString string) (*dto.User, error)
-	LoginByID(ctx context.Context, userID uuid.UUID) (string, error)
+	LoginByID(ctx context.Context, userID, sessionID uuid.UUID) (string, error)
 	GetProfile(ctx context.Context, userID uuid.UUID) (*dto.User, error)
-	UpdateEmail(ctx context.Context, userID uuid.UUID, email string) error
+	UpdateProfile(ctx context.Context, userID uuid.UUID, update *ProfileUpdate) (*dto.User, error)
 	UpdatePassword(ctx context.Context, userID uuid.UUID, oldPassword, newPassword string) error
 	GetUserByEmail(ctx context.Context, email string) (*dto.User, error)
+	// UnlockLogin forgets the failed logins for email and lifts its lock
+	UnlockLogin(ctx context.Context, email string) error
 }
 
 type DefaultUserService struct {
 	userRepo repository.UserRepository
+	roleRepo repository.RoleRepository
 	jwtSvc   auth.JWTService
+	// requireVerifiedEmail blocks login until the user verifies their email
+	requireVerifiedEmail bool
+	lockout              LockoutPolicy
+	loginFailures        ratelimit.FailureCounter
 }
 
-func NewUserService(userRepo repository.UserRepository, jwtSvc auth.JWTService) *DefaultUserService {
+func NewUserService(
+	userRepo repository.UserRepository,
+	roleRepo repository.RoleRepository,
+	jwtSvc auth.JWTService,
+	requireVerifiedEmail bool,
+	lockout LockoutPolicy,
+	loginFailures ratelimit.FailureCounter,
+) *DefaultUserService {
 	return &DefaultUserService{
-		userRepo: userRepo,
-		jwtSvc:   jwtSvc,
+		userRepo:             userRepo,
+		roleRepo:             roleRepo,
+		jwtSvc:               jwtSvc,
+		requireVerifiedEmail: requireVerifiedEmail,
+		lockout:              lockout,
+		loginFailures:        loginFailures,
 	}
 }
 
@@ -49,11 +127,21 @@ func (s *DefaultUserService) Register(ctx context.Context, email, password, name
 		return nil, ErrWeakPassword
 	}
 
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+
 	_, err := mail.ParseAddress(email)
 	if err != nil {
 		return nil, ErrInvalidEmail
 	}
 
+	// Hashing before the lookup keeps taken addresses from answering faster
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
 	existingUser, err := s.userRepo.FindByEmail(ctx, email)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		return nil, err
@@ -62,15 +150,13 @@ func (s *DefaultUserService) Register(ctx context.Context, email, password, name
 		return nil, ErrEmailExists
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return nil, err
-	}
-
 	user := &dto.User{
 		ID:           uuid.New(),
 		Email:        email,
 		PasswordHash: string(hashedPassword),
+		Profile: dto.Profile{
+			Name: strings.TrimSpace(name),
+		},
 	}
 
 	if err := s.userRepo.Create(ctx, user); err != nil {
@@ -80,23 +166,104 @@ func (s *DefaultUserService) Register(ctx context.Context, email, password, name
 	return user, nil
 }
 
-func (s *DefaultUserService) Login(ctx context.Context, email, password string) (string, error) {
+func (s *DefaultUserService) Authenticate(ctx context.Context, email, password string) (*dto.User, error) {
+	// A locked email is refused before it is even looked up, so guesses made during the lock reveal nothing
+	if s.lockout.Threshold > 0 {
+		lockedFor, err := s.loginFailures.LockedFor(ctx, loginFailureKey(email))
+		if err != nil {
+			return nil, err
+		}
+		if lockedFor > 0 {
+			return nil, &RetryAfterError{Err: ErrAccountLocked, RetryAfter: lockedFor}
+		}
+	}
+
 	user, err := s.userRepo.FindByEmail(ctx, email)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", ErrInvalidEmail
+			bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+			return nil, s.failLogin(ctx, email)
 		}
-		return "", err
+		return nil, err
+	}
+
+	// Users created from an external identity have no password, they fail as slowly as everyone else
+	passwordHash := []byte(user.PasswordHash)
+	if len(passwordHash) == 0 {
+		passwordHash = dummyPasswordHash()
+	}
+	if err := bcrypt.CompareHashAndPassword(passwordHash, []byte(password)); err != nil || user.PasswordHash == "" {
+		return nil, s.failLogin(ctx, email)
+	}
+
+	if s.lockout.Threshold > 0 {
+		if err := s.UnlockLogin(ctx, email); err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// failLogin records the failure against the email, holds the response for the progressive delay and reports
+// the lock once it is set. Unknown emails go through it too, so they fail exactly like known ones.
+func (s *DefaultUserService) failLogin(ctx context.Context, email string) error {
+	if s.lockout.Threshold <= 0 {
+		return ErrInvalidCredentials
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
-		return "", ErrInvalidPassword
+	failures, lockedFor, err := s.loginFailures.RecordFailure(ctx, loginFailureKey(email), s.lockout.Threshold, s.lockout.Duration, s.lockout.Duration)
+	if err != nil {
+		return err
+	}
+	// The counter decides, the users row keeps a record of it. The update runs for unknown emails too and
+	// just matches no row, so it costs the same either way.
+	var lockedUntil *time.Time
+	if lockedFor > 0 {
+		until := time.Now().Add(lockedFor)
+		lockedUntil = &until
+	}
+	if err := s.userRepo.UpdateLockout(ctx, email, failures, lockedUntil); err != nil {
+		return err
+	}
+	if lockedFor > 0 {
+		return &RetryAfterError{Err: ErrAccountLocked, RetryAfter: lockedFor}
 	}
 
-	return s.jwtSvc.GenerateToken(user.ID, user.Email)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(s.lockout.delay(failures)):
+	}
+	return ErrInvalidCredentials
 }
 
-func (s *DefaultUserService) LoginByID(ctx context.Context, userID uuid.UUID) (string, error) {
+func (s *DefaultUserService) UnlockLogin(ctx context.Context, email string) error {
+	if err := s.loginFailures.Reset(ctx, loginFailureKey(email)); err != nil {
+		return err
+	}
+	return s.userRepo.UpdateLockout(ctx, email, 0, nil)
+}
+
+// loginFailureKey normalizes the email the same way the attempt limiter does
+func loginFailureKey(email string) string {
+	return ActionLogin + ":" + strings.ToLower(email)
+}
+
+func (s *DefaultUserService) IssueToken(ctx context.Context, user *dto.User, sessionID uuid.UUID) (string, error) {
+	if s.requireVerifiedEmail && user.EmailVerifiedAt == nil {
+		return "", ErrEmailNotVerified
+	}
+
+	grants, err := loadGrants(ctx, s.roleRepo, user.ID)
+	if err != nil {
+		return "", err
+	}
+
+	return s.jwtSvc.GenerateToken(user.ID, user.Email, sessionID, grants)
+}
+
+func (s *DefaultUserService) LoginByID(ctx context.Context, userID, sessionID uuid.UUID) (string, error) {
 	user, err := s.userRepo.FindByID(ctx, userID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -105,11 +272,16 @@ func (s *DefaultUserService) LoginByID(ctx context.Context, userID uuid.UUID) (s
 		return "", err
 	}
 
-	return s.jwtSvc.GenerateToken(user.ID, user.Email)
+	grants, err := loadGrants(ctx, s.roleRepo, user.ID)
+	if err != nil {
+		return "", err
+	}
+
+	return s.jwtSvc.GenerateToken(user.ID, user.Email, sessionID, grants)
 }
 
 func (s *DefaultUserService) ValidateToken(ctx context.Context, tokenString string) (*dto.User, error) {
-	claims, err := s.jwtSvc.ValidateToken(tokenString)
+	claims, err := s.jwtSvc.ValidateToken(ctx, tokenString)
 	if err != nil {
 		return nil, err
 	}
@@ -137,21 +309,44 @@ func (s *DefaultUserService) GetProfile(ctx context.Context, userID uuid.UUID) (
 	return user, nil
 }
 
-func (s *DefaultUserService) UpdateEmail(ctx context.Context, userID uuid.UUID, email string) error {
-	_, err := mail.ParseAddress(email)
+func (s *DefaultUserService) UpdateProfile(ctx context.Context, userID uuid.UUID, update *ProfileUpdate) (*dto.User, error) {
+	user, err := s.GetProfile(ctx, userID)
 	if err != nil {
-		return ErrInvalidEmail
+		return nil, err
 	}
 
-	_, err = s.userRepo.FindByID(ctx, userID)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return ErrUserNotFound
+	profile := user.Profile
+	if update.Name != nil {
+		if err := validateName(*update.Name); err != nil {
+			return nil, err
 		}
-		return err
+		profile.Name = strings.TrimSpace(*update.Name)
+	}
+	if update.Locale != nil {
+		locale, err := normalizeLocale(*update.Locale)
+		if err != nil {
+			return nil, err
+		}
+		profile.Locale = locale
+	}
+	if update.Timezone != nil {
+		if err := validateTimezone(*update.Timezone); err != nil {
+			return nil, err
+		}
+		profile.Timezone = *update.Timezone
+	}
+	if update.AvatarURL != nil {
+		if err := validateAvatarURL(*update.AvatarURL); err != nil {
+			return nil, err
+		}
+		profile.AvatarURL = *update.AvatarURL
+	}
+
+	if err := s.userRepo.UpdateProfile(ctx, userID, &profile); err != nil {
+		return nil, err
 	}
 
-	return s.userRepo.UpdateEmail(ctx, userID, email)
+	return s.GetProfile(ctx, userID)
 }
 
 func (s *DefaultUserService) UpdatePassword(ctx context.Context, userID uuid.UUID, oldPassword, newPassword string) error {
@@ -190,3 +385,46 @@ func (s *DefaultUserService) GetUserByEmail(ctx context.Context, email string) (
 
 	return user, nil
 }
+
+func validateName(name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxNameLength {
+		return ErrInvalidName
+	}
+	return nil
+}
+
+// normalizeLocale returns the canonical BCP 47 form of locale, an empty locale clears it
+func normalizeLocale(locale string) (string, error) {
+	if locale == "" {
+		return "", nil
+	}
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return "", ErrInvalidLocale
+	}
+	return tag.String(), nil
+}
+
+// validateTimezone accepts IANA time zone names, an empty timezone clears it
+func validateTimezone(timezone string) error {
+	if timezone == "" {
+		return nil
+	}
+	if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+		return ErrInvalidTimezone
+	}
+	return nil
+}
+
+// validateAvatarURL accepts absolute http(s) URLs, an empty URL clears it
+func validateAvatarURL(avatarURL string) error {
+	if avatarURL == "" {
+		return nil
+	}
+	u, err := url.Parse(avatarURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return ErrInvalidAvatarURL
+	}
+	return nil
+}
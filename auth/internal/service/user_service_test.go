@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yoshapihoff/bricks/auth/internal/dto"
+	"github.com/yoshapihoff/bricks/auth/internal/ratelimit"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newLockoutTestService(t *testing.T) *DefaultUserService {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	userRepo := newFakeUserRepository(&dto.User{ID: uuid.New(), Email: "known@example.com", PasswordHash: string(hash)})
+	return NewUserService(userRepo, nil, nil, false, LockoutPolicy{
+		Threshold: 3,
+		Duration:  time.Minute,
+		DelayBase: 10 * time.Millisecond,
+		DelayMax:  20 * time.Millisecond,
+	}, ratelimit.NewMemoryFailureCounter())
+}
+
+func TestAuthenticateFailsAlikeForKnownAndUnknownEmails(t *testing.T) {
+	for _, email := range []string{"known@example.com", "unknown@example.com"} {
+		t.Run(email, func(t *testing.T) {
+			svc := newLockoutTestService(t)
+			ctx := context.Background()
+
+			for attempt, wantDelay := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond} {
+				start := time.Now()
+				_, err := svc.Authenticate(ctx, email, "wrong password")
+				if !errors.Is(err, ErrInvalidCredentials) {
+					t.Fatalf("attempt %d: error = %v, want %v", attempt+1, err, ErrInvalidCredentials)
+				}
+				if elapsed := time.Since(start); elapsed < wantDelay {
+					t.Errorf("attempt %d: answered after %s, want at least %s", attempt+1, elapsed, wantDelay)
+				}
+			}
+
+			var retryErr *RetryAfterError
+			_, err := svc.Authenticate(ctx, email, "wrong password")
+			if !errors.Is(err, ErrAccountLocked) || !errors.As(err, &retryErr) || retryErr.RetryAfter <= 0 {
+				t.Fatalf("error at the threshold = %v, want %v with a retry after", err, ErrAccountLocked)
+			}
+
+			// The lock is keyed on the normalized email and holds whatever password comes next
+			if _, err := svc.Authenticate(ctx, strings.ToUpper(email), "correct horse"); !errors.Is(err, ErrAccountLocked) {
+				t.Errorf("locked login error = %v, want %v", err, ErrAccountLocked)
+			}
+		})
+	}
+}
+
+func TestAuthenticateResetsFailuresOnSuccess(t *testing.T) {
+	svc := newLockoutTestService(t)
+	ctx := context.Background()
+
+	for range 2 {
+		if _, err := svc.Authenticate(ctx, "known@example.com", "wrong password"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("error = %v, want %v", err, ErrInvalidCredentials)
+		}
+	}
+	if _, err := svc.Authenticate(ctx, "known@example.com", "correct horse"); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	// Two more failures stay under the threshold because the success started the count over
+	for range 2 {
+		if _, err := svc.Authenticate(ctx, "known@example.com", "wrong password"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("error = %v, want %v", err, ErrInvalidCredentials)
+		}
+	}
+}
+
+func TestUnlockLoginLiftsTheLock(t *testing.T) {
+	svc := newLockoutTestService(t)
+	ctx := context.Background()
+
+	for range 3 {
+		_, _ = svc.Authenticate(ctx, "known@example.com", "wrong password")
+	}
+	if _, err := svc.Authenticate(ctx, "known@example.com", "correct horse"); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("error = %v, want %v", err, ErrAccountLocked)
+	}
+	userRepo := svc.userRepo.(*fakeUserRepository)
+	user, _ := userRepo.FindByEmail(ctx, "known@example.com")
+	if got := userRepo.lockouts[user.ID]; got.failedAttempts != 3 || got.lockedUntil == nil || !got.lockedUntil.After(time.Now()) {
+		t.Errorf("recorded lockout = %d failures until %v, want 3 failures and a lock", got.failedAttempts, got.lockedUntil)
+	}
+
+	if err := svc.UnlockLogin(ctx, "known@example.com"); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+	if got := userRepo.lockouts[user.ID]; got.failedAttempts != 0 || got.lockedUntil != nil {
+		t.Errorf("recorded lockout after unlock = %d failures until %v, want none", got.failedAttempts, got.lockedUntil)
+	}
+	if _, err := svc.Authenticate(ctx, "known@example.com", "correct horse"); err != nil {
+		t.Errorf("login after unlock: %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+package valkey
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/yoshapihoff/bricks/auth/internal/config"
+)
+
+// Init connects to Valkey and returns a client, Valkey speaks the Redis protocol
+func Init(cfg config.ValkeyConfig) (*redis.Client, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+
+	// Test the Valkey connection
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
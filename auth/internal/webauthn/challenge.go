@@ -0,0 +1,96 @@
+package webauthn
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const challengeKeyPrefix = "auth:webauthn:challenge:"
+
+// Session is the server side half of a ceremony
+type Session struct {
+	Ceremony string `json:"ceremony"`
+	// UserID is the user handle a registration is for, logins do not know the user yet
+	UserID []byte `json:"user_id,omitempty"`
+}
+
+// ChallengeStore keeps sessions between the begin and finish requests
+type ChallengeStore interface {
+	Save(ctx context.Context, challenge string, session *Session, ttl time.Duration) error
+	// Consume returns the session and removes it so a challenge can only be answered once
+	Consume(ctx context.Context, challenge string) (*Session, error)
+}
+
+type ValkeyChallengeStore struct {
+	client *redis.Client
+}
+
+func NewValkeyChallengeStore(client *redis.Client) *ValkeyChallengeStore {
+	return &ValkeyChallengeStore{client: client}
+}
+
+func (s *ValkeyChallengeStore) Save(ctx context.Context, challenge string, session *Session, ttl time.Duration) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, challengeKeyPrefix+challenge, data, ttl).Err()
+}
+
+func (s *ValkeyChallengeStore) Consume(ctx context.Context, challenge string) (*Session, error) {
+	data, err := s.client.GetDel(ctx, challengeKeyPrefix+challenge).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrInvalidChallenge
+		}
+		return nil, err
+	}
+
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+type memorySession struct {
+	session   Session
+	expiresAt time.Time
+}
+
+type MemoryChallengeStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+}
+
+func NewMemoryChallengeStore() *MemoryChallengeStore {
+	return &MemoryChallengeStore{sessions: make(map[string]memorySession)}
+}
+
+func (s *MemoryChallengeStore) Save(ctx context.Context, challenge string, session *Session, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[challenge] = memorySession{session: *session, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryChallengeStore) Consume(ctx context.Context, challenge string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.sessions[challenge]
+	if !ok {
+		return nil, ErrInvalidChallenge
+	}
+	delete(s.sessions, challenge)
+	if time.Now().After(stored.expiresAt) {
+		return nil, ErrInvalidChallenge
+	}
+	return &stored.session, nil
+}
@@ -0,0 +1,119 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers offered to authenticators, in order of preference
+const (
+	AlgEdDSA int64 = -8
+	AlgES256 int64 = -7
+	AlgRS256 int64 = -257
+)
+
+const (
+	coseKeyType  = 1
+	coseKeyAlg   = 3
+	coseKeyCurve = -1
+	// coseKeyX holds x for EC2 and OKP keys and the modulus for RSA keys
+	coseKeyX = -2
+	// coseKeyY holds y for EC2 keys and the exponent for RSA keys
+	coseKeyY = -3
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+
+	minRSAKeyBits = 2048
+)
+
+// publicKey is a credential public key decoded from its COSE form
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parsePublicKey decodes a COSE_Key, only the algorithms we offer are accepted
+func parsePublicKey(data []byte) (*publicKey, error) {
+	item, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errInvalidCBOR
+	}
+	fields, ok := item.(map[any]any)
+	if !ok {
+		return nil, errInvalidCBOR
+	}
+
+	keyType, _ := fields[int64(coseKeyType)].(int64)
+	alg, _ := fields[int64(coseKeyAlg)].(int64)
+	curve, _ := fields[int64(coseKeyCurve)].(int64)
+	x, _ := fields[int64(coseKeyX)].([]byte)
+	y, _ := fields[int64(coseKeyY)].([]byte)
+
+	switch {
+	case alg == AlgES256 && keyType == coseKeyTypeEC2 && curve == coseCurveP256:
+		if len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("%w: malformed P-256 key", ErrInvalidCredential)
+		}
+		// ecdh rejects points that are not on the curve
+		point := make([]byte, 0, 65)
+		point = append(point, 4)
+		point = append(point, x...)
+		point = append(point, y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCredential, err)
+		}
+		return &publicKey{alg: alg, key: &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}}, nil
+	case alg == AlgEdDSA && keyType == coseKeyTypeOKP && curve == coseCurveEd25519:
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: malformed Ed25519 key", ErrInvalidCredential)
+		}
+		return &publicKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+	case alg == AlgRS256 && keyType == coseKeyTypeRSA:
+		n := new(big.Int).SetBytes(x)
+		e := new(big.Int).SetBytes(y)
+		if n.BitLen() < minRSAKeyBits || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("%w: malformed RSA key", ErrInvalidCredential)
+		}
+		return &publicKey{alg: alg, key: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	}
+
+	return nil, fmt.Errorf("%w: unsupported key algorithm %d", ErrInvalidCredential, alg)
+}
+
+// verify checks signature over data with the key
+func (k *publicKey) verify(data, signature []byte) error {
+	digest := sha256.Sum256(data)
+
+	var ok bool
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, data, signature)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+	if !ok {
+		return fmt.Errorf("%w: bad signature", ErrInvalidCredential)
+	}
+	return nil
+}
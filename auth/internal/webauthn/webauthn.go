@@ -0,0 +1,398 @@
+package webauthn
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	ceremonyCreate = "webauthn.create"
+	ceremonyGet    = "webauthn.get"
+
+	challengeBytes = 32
+	// maxCredentialIDLength is the limit the WebAuthn spec puts on credential IDs
+	maxCredentialIDLength = 1023
+
+	flagUserPresent            = 0x01
+	flagUserVerified           = 0x04
+	flagAttestedCredentialData = 0x40
+
+	// authenticatorData starts with the RP ID hash, the flags and the signature counter
+	authenticatorDataMinLength = 37
+	// attestedCredentialData starts with the AAGUID and the credential ID length
+	attestedCredentialDataMinLength = 18
+)
+
+var (
+	ErrInvalidChallenge  = errors.New("invalid webauthn challenge")
+	ErrInvalidCredential = errors.New("invalid webauthn credential")
+)
+
+type Config struct {
+	// RPID is the domain credentials are scoped to
+	RPID   string
+	RPName string
+	// Origins lists the web origins allowed to run ceremonies
+	Origins      []string
+	ChallengeTTL time.Duration
+}
+
+// URLEncodedBytes is a byte slice carried as unpadded base64url in JSON, the encoding browsers use for WebAuthn
+type URLEncodedBytes []byte
+
+func (b URLEncodedBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *URLEncodedBytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// User describes the account a credential is registered for
+type User struct {
+	ID          []byte
+	Name        string
+	DisplayName string
+}
+
+// Credential is a registered public key credential
+type Credential struct {
+	ID []byte
+	// PublicKey is the COSE encoded key as the authenticator returned it
+	PublicKey []byte
+	SignCount uint32
+}
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          URLEncodedBytes `json:"id"`
+	Name        string          `json:"name"`
+	DisplayName string          `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string          `json:"type"`
+	ID   URLEncodedBytes `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// CreationOptions is the JSON form of PublicKeyCredentialCreationOptions
+type CreationOptions struct {
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	Challenge              URLEncodedBytes        `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions is the JSON form of PublicKeyCredentialRequestOptions
+type RequestOptions struct {
+	Challenge        URLEncodedBytes `json:"challenge"`
+	Timeout          int64           `json:"timeout"`
+	RPID             string          `json:"rpId"`
+	UserVerification string          `json:"userVerification"`
+}
+
+type AttestationResponse struct {
+	ClientDataJSON    URLEncodedBytes `json:"clientDataJSON"`
+	AttestationObject URLEncodedBytes `json:"attestationObject"`
+}
+
+// RegistrationResponse is the JSON form of the PublicKeyCredential returned by navigator.credentials.create
+type RegistrationResponse struct {
+	ID       string              `json:"id"`
+	RawID    URLEncodedBytes     `json:"rawId"`
+	Type     string              `json:"type"`
+	Response AttestationResponse `json:"response"`
+}
+
+type AssertionResponse struct {
+	ClientDataJSON    URLEncodedBytes `json:"clientDataJSON"`
+	AuthenticatorData URLEncodedBytes `json:"authenticatorData"`
+	Signature         URLEncodedBytes `json:"signature"`
+	UserHandle        URLEncodedBytes `json:"userHandle"`
+}
+
+// LoginResponse is the JSON form of the PublicKeyCredential returned by navigator.credentials.get
+type LoginResponse struct {
+	ID       string            `json:"id"`
+	RawID    URLEncodedBytes   `json:"rawId"`
+	Type     string            `json:"type"`
+	Response AssertionResponse `json:"response"`
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// RelyingParty runs registration and discoverable login ceremonies.
+// Credentials must be resident and user verified, so a passkey replaces both the password and the second factor.
+// Attestation is not requested, any authenticator model is accepted.
+type RelyingParty struct {
+	config     Config
+	challenges ChallengeStore
+	rpIDHash   [sha256.Size]byte
+}
+
+func NewRelyingParty(config Config, challenges ChallengeStore) *RelyingParty {
+	return &RelyingParty{
+		config:     config,
+		challenges: challenges,
+		rpIDHash:   sha256.Sum256([]byte(config.RPID)),
+	}
+}
+
+// BeginRegistration returns the options for navigator.credentials.create, exclude lists the user's existing credentials
+func (rp *RelyingParty) BeginRegistration(ctx context.Context, user User, exclude [][]byte) (*CreationOptions, error) {
+	challenge, err := rp.newChallenge(ctx, &Session{Ceremony: ceremonyCreate, UserID: user.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	excludeCredentials := make([]CredentialDescriptor, 0, len(exclude))
+	for _, id := range exclude {
+		excludeCredentials = append(excludeCredentials, CredentialDescriptor{Type: "public-key", ID: id})
+	}
+
+	return &CreationOptions{
+		RP:        RelyingPartyEntity{ID: rp.config.RPID, Name: rp.config.RPName},
+		User:      UserEntity{ID: user.ID, Name: user.Name, DisplayName: user.DisplayName},
+		Challenge: challenge,
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: AlgEdDSA},
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgRS256},
+		},
+		Timeout:            rp.config.ChallengeTTL.Milliseconds(),
+		ExcludeCredentials: excludeCredentials,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   "required",
+		},
+		Attestation: "none",
+	}, nil
+}
+
+// FinishRegistration verifies the new credential was created for the user's challenge and returns it
+func (rp *RelyingParty) FinishRegistration(ctx context.Context, userID []byte, response *RegistrationResponse) (*Credential, error) {
+	session, err := rp.verifyClientData(ctx, response.Response.ClientDataJSON, ceremonyCreate)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(session.UserID, userID) {
+		return nil, ErrInvalidChallenge
+	}
+
+	item, _, err := decodeCBOR(response.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredential, err)
+	}
+	attestation, ok := item.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: malformed attestation object", ErrInvalidCredential)
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing authenticator data", ErrInvalidCredential)
+	}
+
+	authData, err := rp.verifyAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if authData.flags&flagAttestedCredentialData == 0 {
+		return nil, fmt.Errorf("%w: missing attested credential data", ErrInvalidCredential)
+	}
+	if !bytes.Equal(authData.credentialID, response.RawID) {
+		return nil, fmt.Errorf("%w: credential id mismatch", ErrInvalidCredential)
+	}
+	if _, err := parsePublicKey(authData.publicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:        authData.credentialID,
+		PublicKey: authData.publicKey,
+		SignCount: authData.signCount,
+	}, nil
+}
+
+// BeginLogin returns the options for navigator.credentials.get, the authenticator picks the credential
+func (rp *RelyingParty) BeginLogin(ctx context.Context) (*RequestOptions, error) {
+	challenge, err := rp.newChallenge(ctx, &Session{Ceremony: ceremonyGet})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          rp.config.ChallengeTTL.Milliseconds(),
+		RPID:             rp.config.RPID,
+		UserVerification: "required",
+	}, nil
+}
+
+// FinishLogin verifies the assertion was signed by credential for a pending challenge and returns the new signature counter.
+// The caller looks the credential up by the response's raw ID and checks the user handle belongs to it.
+func (rp *RelyingParty) FinishLogin(ctx context.Context, response *LoginResponse, credential *Credential) (uint32, error) {
+	if _, err := rp.verifyClientData(ctx, response.Response.ClientDataJSON, ceremonyGet); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(response.RawID, credential.ID) {
+		return 0, fmt.Errorf("%w: credential id mismatch", ErrInvalidCredential)
+	}
+
+	authData, err := rp.verifyAuthenticatorData(response.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	key, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(response.Response.ClientDataJSON)
+	signed := make([]byte, 0, len(response.Response.AuthenticatorData)+len(clientDataHash))
+	signed = append(signed, response.Response.AuthenticatorData...)
+	signed = append(signed, clientDataHash[:]...)
+	if err := key.verify(signed, response.Response.Signature); err != nil {
+		return 0, err
+	}
+
+	// A counter that does not move forward means the key may have been cloned, authenticators without a counter always send zero
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return 0, fmt.Errorf("%w: signature counter did not increase", ErrInvalidCredential)
+	}
+
+	return authData.signCount, nil
+}
+
+func (rp *RelyingParty) newChallenge(ctx context.Context, session *Session) ([]byte, error) {
+	challenge := make([]byte, challengeBytes)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	if err := rp.challenges.Save(ctx, base64.RawURLEncoding.EncodeToString(challenge), session, rp.config.ChallengeTTL); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// verifyClientData consumes the challenge the client data answers and checks it belongs to the ceremony and an allowed origin
+func (rp *RelyingParty) verifyClientData(ctx context.Context, raw []byte, ceremony string) (*Session, error) {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredential, err)
+	}
+
+	session, err := rp.challenges.Consume(ctx, data.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	if data.Type != ceremony || session.Ceremony != ceremony {
+		return nil, ErrInvalidChallenge
+	}
+	if !slices.Contains(rp.config.Origins, data.Origin) {
+		return nil, fmt.Errorf("%w: origin %s is not allowed", ErrInvalidCredential, data.Origin)
+	}
+
+	return session, nil
+}
+
+// verifyAuthenticatorData parses the authenticator data and checks it is scoped to our RP ID with the user present and verified
+func (rp *RelyingParty) verifyAuthenticatorData(data []byte) (*authenticatorData, error) {
+	authData, err := parseAuthenticatorData(data)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(authData.rpIDHash, rp.rpIDHash[:]) {
+		return nil, fmt.Errorf("%w: rp id mismatch", ErrInvalidCredential)
+	}
+	if authData.flags&flagUserPresent == 0 || authData.flags&flagUserVerified == 0 {
+		return nil, fmt.Errorf("%w: user not verified", ErrInvalidCredential)
+	}
+	return authData, nil
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < authenticatorDataMinLength {
+		return nil, fmt.Errorf("%w: authenticator data too short", ErrInvalidCredential)
+	}
+
+	authData := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if authData.flags&flagAttestedCredentialData == 0 {
+		return authData, nil
+	}
+
+	rest := data[authenticatorDataMinLength:]
+	if len(rest) < attestedCredentialDataMinLength {
+		return nil, fmt.Errorf("%w: attested credential data too short", ErrInvalidCredential)
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[attestedCredentialDataMinLength:]
+	if idLength > maxCredentialIDLength || len(rest) < idLength {
+		return nil, fmt.Errorf("%w: malformed credential id", ErrInvalidCredential)
+	}
+	authData.credentialID = rest[:idLength]
+	rest = rest[idLength:]
+
+	// The public key is followed by extensions when the authenticator sent any
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredential, err)
+	}
+	authData.publicKey = rest[:len(rest)-len(after)]
+
+	return authData, nil
+}
@@ -0,0 +1,490 @@
+package webauthn
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+const (
+	testRPID   = "bricks.local"
+	testOrigin = "https://bricks.local"
+)
+
+// cborPair is a map entry, maps are encoded from a slice so the output is deterministic
+type cborPair struct {
+	key   any
+	value any
+}
+
+// encodeCBOR covers the items the software authenticator needs: integers, byte and text strings, maps and booleans
+func encodeCBOR(item any) []byte {
+	header := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= 0xff:
+			return []byte{major<<5 | 24, byte(n)}
+		case n <= 0xffff:
+			return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+		case n <= 0xffffffff:
+			return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+		}
+		return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+	}
+
+	switch v := item.(type) {
+	case int:
+		if v < 0 {
+			return header(1, uint64(-1-v))
+		}
+		return header(0, uint64(v))
+	case []byte:
+		return append(header(2, uint64(len(v))), v...)
+	case string:
+		return append(header(3, uint64(len(v))), v...)
+	case bool:
+		if v {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	case []cborPair:
+		out := header(5, uint64(len(v)))
+		for _, pair := range v {
+			out = append(out, encodeCBOR(pair.key)...)
+			out = append(out, encodeCBOR(pair.value)...)
+		}
+		return out
+	}
+	panic(fmt.Sprintf("encodeCBOR: unsupported %T", item))
+}
+
+// softwareAuthenticator holds one credential and answers ceremonies the way a browser and platform authenticator would
+type softwareAuthenticator struct {
+	credentialID []byte
+	alg          int64
+	signer       crypto.Signer
+	signCount    uint32
+
+	// The fields below default to a well behaved authenticator, tests change them to misbehave
+	origin string
+	rpID   string
+	flags  byte
+}
+
+func newSoftwareAuthenticator(t *testing.T, alg int64) *softwareAuthenticator {
+	t.Helper()
+
+	var signer crypto.Signer
+	var err error
+	switch alg {
+	case AlgES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("unsupported algorithm %d", alg)
+	}
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatalf("generate credential id: %v", err)
+	}
+
+	return &softwareAuthenticator{
+		credentialID: credentialID,
+		alg:          alg,
+		signer:       signer,
+		origin:       testOrigin,
+		rpID:         testRPID,
+		flags:        flagUserPresent | flagUserVerified,
+	}
+}
+
+func (a *softwareAuthenticator) coseKey() []byte {
+	switch key := a.signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		return encodeCBOR([]cborPair{
+			{coseKeyType, coseKeyTypeEC2},
+			{coseKeyAlg, int(AlgES256)},
+			{coseKeyCurve, coseCurveP256},
+			{coseKeyX, key.X.FillBytes(make([]byte, 32))},
+			{coseKeyY, key.Y.FillBytes(make([]byte, 32))},
+		})
+	case ed25519.PublicKey:
+		return encodeCBOR([]cborPair{
+			{coseKeyType, coseKeyTypeOKP},
+			{coseKeyAlg, int(AlgEdDSA)},
+			{coseKeyCurve, coseCurveEd25519},
+			{coseKeyX, []byte(key)},
+		})
+	}
+	panic("unsupported key")
+}
+
+func (a *softwareAuthenticator) clientDataJSON(ceremony string, challenge []byte) []byte {
+	data, err := json.Marshal(clientData{
+		Type:      ceremony,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    a.origin,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func (a *softwareAuthenticator) authenticatorData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	flags := a.flags
+	if attested {
+		flags |= flagAttestedCredentialData
+	}
+
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+// create answers the options the way navigator.credentials.create does, with "none" attestation
+func (a *softwareAuthenticator) create(options *CreationOptions) *RegistrationResponse {
+	attestationObject := encodeCBOR([]cborPair{
+		{"fmt", "none"},
+		{"attStmt", []cborPair{}},
+		{"authData", a.authenticatorData(true)},
+	})
+	return &RegistrationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		RawID: a.credentialID,
+		Type:  "public-key",
+		Response: AttestationResponse{
+			ClientDataJSON:    a.clientDataJSON(ceremonyCreate, options.Challenge),
+			AttestationObject: attestationObject,
+		},
+	}
+}
+
+// get answers the options the way navigator.credentials.get does, the counter moves forward on every call
+func (a *softwareAuthenticator) get(t *testing.T, options *RequestOptions) *LoginResponse {
+	t.Helper()
+
+	a.signCount++
+	authData := a.authenticatorData(false)
+	clientDataJSON := a.clientDataJSON(ceremonyGet, options.Challenge)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authData...), clientDataHash[:]...)
+
+	var signature []byte
+	var err error
+	switch signer := a.signer.(type) {
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(signed)
+		signature, err = ecdsa.SignASN1(rand.Reader, signer, digest[:])
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(signer, signed)
+	}
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	return &LoginResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		RawID: a.credentialID,
+		Type:  "public-key",
+		Response: AssertionResponse{
+			ClientDataJSON:    clientDataJSON,
+			AuthenticatorData: authData,
+			Signature:         signature,
+		},
+	}
+}
+
+func newTestRelyingParty() *RelyingParty {
+	return NewRelyingParty(Config{
+		RPID:         testRPID,
+		RPName:       "Bricks",
+		Origins:      []string{testOrigin},
+		ChallengeTTL: time.Minute,
+	}, NewMemoryChallengeStore())
+}
+
+var testUser = User{ID: []byte("user-handle"), Name: "user@example.com", DisplayName: "User"}
+
+// register runs a registration ceremony that is expected to succeed
+func register(t *testing.T, rp *RelyingParty, authenticator *softwareAuthenticator) *Credential {
+	t.Helper()
+
+	options, err := rp.BeginRegistration(context.Background(), testUser, nil)
+	if err != nil {
+		t.Fatalf("begin registration: %v", err)
+	}
+	credential, err := rp.FinishRegistration(context.Background(), testUser.ID, authenticator.create(options))
+	if err != nil {
+		t.Fatalf("finish registration: %v", err)
+	}
+	return credential
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	for name, alg := range map[string]int64{"ES256": AlgES256, "EdDSA": AlgEdDSA} {
+		t.Run(name, func(t *testing.T) {
+			rp := newTestRelyingParty()
+			authenticator := newSoftwareAuthenticator(t, alg)
+			ctx := context.Background()
+
+			credential := register(t, rp, authenticator)
+			if string(credential.ID) != string(authenticator.credentialID) {
+				t.Errorf("credential id = %x, want %x", credential.ID, authenticator.credentialID)
+			}
+
+			for range 2 {
+				options, err := rp.BeginLogin(ctx)
+				if err != nil {
+					t.Fatalf("begin login: %v", err)
+				}
+				signCount, err := rp.FinishLogin(ctx, authenticator.get(t, options), credential)
+				if err != nil {
+					t.Fatalf("finish login: %v", err)
+				}
+				if signCount != authenticator.signCount {
+					t.Errorf("sign count = %d, want %d", signCount, authenticator.signCount)
+				}
+				credential.SignCount = signCount
+			}
+		})
+	}
+}
+
+func TestFinishRegistrationRejects(t *testing.T) {
+	tests := map[string]struct {
+		misbehave func(a *softwareAuthenticator)
+		wantErr   error
+	}{
+		"wrong origin":        {func(a *softwareAuthenticator) { a.origin = "https://evil.example" }, ErrInvalidCredential},
+		"wrong rp id hash":    {func(a *softwareAuthenticator) { a.rpID = "evil.example" }, ErrInvalidCredential},
+		"user not present":    {func(a *softwareAuthenticator) { a.flags &^= flagUserPresent }, ErrInvalidCredential},
+		"user not verified":   {func(a *softwareAuthenticator) { a.flags &^= flagUserVerified }, ErrInvalidCredential},
+		"no user interaction": {func(a *softwareAuthenticator) { a.flags = 0 }, ErrInvalidCredential},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rp := newTestRelyingParty()
+			authenticator := newSoftwareAuthenticator(t, AlgES256)
+			tt.misbehave(authenticator)
+
+			options, err := rp.BeginRegistration(context.Background(), testUser, nil)
+			if err != nil {
+				t.Fatalf("begin registration: %v", err)
+			}
+			if _, err := rp.FinishRegistration(context.Background(), testUser.ID, authenticator.create(options)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFinishRegistrationRejectsReplayedChallenge(t *testing.T) {
+	rp := newTestRelyingParty()
+	authenticator := newSoftwareAuthenticator(t, AlgEdDSA)
+	ctx := context.Background()
+
+	options, err := rp.BeginRegistration(ctx, testUser, nil)
+	if err != nil {
+		t.Fatalf("begin registration: %v", err)
+	}
+	response := authenticator.create(options)
+	if _, err := rp.FinishRegistration(ctx, testUser.ID, response); err != nil {
+		t.Fatalf("finish registration: %v", err)
+	}
+	if _, err := rp.FinishRegistration(ctx, testUser.ID, response); !errors.Is(err, ErrInvalidChallenge) {
+		t.Errorf("replay error = %v, want %v", err, ErrInvalidChallenge)
+	}
+}
+
+func TestFinishRegistrationRejectsAnotherUsersChallenge(t *testing.T) {
+	rp := newTestRelyingParty()
+	authenticator := newSoftwareAuthenticator(t, AlgES256)
+
+	options, err := rp.BeginRegistration(context.Background(), testUser, nil)
+	if err != nil {
+		t.Fatalf("begin registration: %v", err)
+	}
+	if _, err := rp.FinishRegistration(context.Background(), []byte("someone-else"), authenticator.create(options)); !errors.Is(err, ErrInvalidChallenge) {
+		t.Errorf("error = %v, want %v", err, ErrInvalidChallenge)
+	}
+}
+
+func TestFinishLoginRejects(t *testing.T) {
+	tests := map[string]struct {
+		misbehave func(a *softwareAuthenticator)
+		wantErr   error
+	}{
+		"wrong origin":        {func(a *softwareAuthenticator) { a.origin = "https://evil.example" }, ErrInvalidCredential},
+		"wrong rp id hash":    {func(a *softwareAuthenticator) { a.rpID = "evil.example" }, ErrInvalidCredential},
+		"user not present":    {func(a *softwareAuthenticator) { a.flags &^= flagUserPresent }, ErrInvalidCredential},
+		"user not verified":   {func(a *softwareAuthenticator) { a.flags &^= flagUserVerified }, ErrInvalidCredential},
+		"sign count rollback": {func(a *softwareAuthenticator) { a.signCount -= 2 }, ErrInvalidCredential},
+		"sign count repeated": {func(a *softwareAuthenticator) { a.signCount-- }, ErrInvalidCredential},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rp := newTestRelyingParty()
+			authenticator := newSoftwareAuthenticator(t, AlgES256)
+			credential := register(t, rp, authenticator)
+			credential.SignCount = 5
+			authenticator.signCount = 5
+			tt.misbehave(authenticator)
+
+			options, err := rp.BeginLogin(context.Background())
+			if err != nil {
+				t.Fatalf("begin login: %v", err)
+			}
+			if _, err := rp.FinishLogin(context.Background(), authenticator.get(t, options), credential); !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFinishLoginRejectsReplayedChallenge(t *testing.T) {
+	rp := newTestRelyingParty()
+	authenticator := newSoftwareAuthenticator(t, AlgEdDSA)
+	credential := register(t, rp, authenticator)
+	ctx := context.Background()
+
+	options, err := rp.BeginLogin(ctx)
+	if err != nil {
+		t.Fatalf("begin login: %v", err)
+	}
+	response := authenticator.get(t, options)
+	if _, err := rp.FinishLogin(ctx, response, credential); err != nil {
+		t.Fatalf("finish login: %v", err)
+	}
+	if _, err := rp.FinishLogin(ctx, response, credential); !errors.Is(err, ErrInvalidChallenge) {
+		t.Errorf("replay error = %v, want %v", err, ErrInvalidChallenge)
+	}
+}
+
+func TestFinishLoginRejectsForeignSignature(t *testing.T) {
+	rp := newTestRelyingParty()
+	authenticator := newSoftwareAuthenticator(t, AlgES256)
+	credential := register(t, rp, authenticator)
+
+	// Another key answers for the registered credential ID
+	impostor := newSoftwareAuthenticator(t, AlgES256)
+	impostor.credentialID = authenticator.credentialID
+
+	options, err := rp.BeginLogin(context.Background())
+	if err != nil {
+		t.Fatalf("begin login: %v", err)
+	}
+	if _, err := rp.FinishLogin(context.Background(), impostor.get(t, options), credential); !errors.Is(err, ErrInvalidCredential) {
+		t.Errorf("error = %v, want %v", err, ErrInvalidCredential)
+	}
+}
+
+func TestFinishRegistrationRejectsTruncatedAttestation(t *testing.T) {
+	authenticator := newSoftwareAuthenticator(t, AlgES256)
+	attestationObject := authenticator.create(&CreationOptions{}).Response.AttestationObject
+
+	for length := range len(attestationObject) {
+		rp := newTestRelyingParty()
+		options, err := rp.BeginRegistration(context.Background(), testUser, nil)
+		if err != nil {
+			t.Fatalf("begin registration: %v", err)
+		}
+		response := authenticator.create(options)
+		response.Response.AttestationObject = attestationObject[:length]
+
+		if _, err := rp.FinishRegistration(context.Background(), testUser.ID, response); err == nil {
+			t.Errorf("attestation object truncated to %d bytes was accepted", length)
+		}
+	}
+}
+
+func TestParseAuthenticatorDataRejectsTruncated(t *testing.T) {
+	authData := newSoftwareAuthenticator(t, AlgEdDSA).authenticatorData(true)
+
+	for length := range len(authData) {
+		if _, err := parseAuthenticatorData(authData[:length]); !errors.Is(err, ErrInvalidCredential) {
+			t.Errorf("authenticator data truncated to %d bytes: error = %v, want %v", length, err, ErrInvalidCredential)
+		}
+	}
+}
+
+func TestDecodeCBORRejectsMalformed(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":                       {},
+		"truncated argument":          {0x19, 0x01},
+		"reserved argument":           {0x1c},
+		"indefinite length":           {0x5f, 0x41, 0x00, 0xff},
+		"byte string past the end":    {0x45, 0x01, 0x02},
+		"huge byte string":            {0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"huge array":                  {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"huge map":                    {0xbb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"negative integer overflow":   {0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"map with byte string key":    {0xa1, 0x41, 0x00, 0x00},
+		"map with array key":          {0xa1, 0x80, 0x00},
+		"map with map key":            {0xa1, 0xa0, 0x00, 0x00},
+		"map missing value":           {0xa1, 0x01},
+		"duplicate map key":           {0xa2, 0x01, 0x00, 0x01, 0x00},
+		"tag":                         {0xc0, 0x00},
+		"float":                       {0xfa, 0x00, 0x00, 0x00, 0x00},
+		"nested too deep":             append(bytes.Repeat([]byte{0x81}, maxCBORDepth+2), 0x00),
+		"truncated nested array item": {0x82, 0x00},
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := decodeCBOR(data); !errors.Is(err, errInvalidCBOR) {
+				t.Errorf("error = %v, want %v", err, errInvalidCBOR)
+			}
+		})
+	}
+}
+
+// FuzzParseAuthenticatorData checks arbitrary authenticator data and COSE keys never panic the parsers
+func FuzzParseAuthenticatorData(f *testing.F) {
+	for _, alg := range []int64{AlgES256, AlgEdDSA} {
+		authenticator := &softwareAuthenticator{credentialID: []byte{1, 2, 3, 4}, alg: alg, rpID: testRPID, flags: flagUserPresent | flagUserVerified}
+		switch alg {
+		case AlgES256:
+			key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			authenticator.signer = key
+		case AlgEdDSA:
+			_, key, _ := ed25519.GenerateKey(rand.Reader)
+			authenticator.signer = key
+		}
+		f.Add(authenticator.authenticatorData(true))
+		f.Add(authenticator.coseKey())
+	}
+	f.Add([]byte{0xbf, 0x01, 0x02, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if authData, err := parseAuthenticatorData(data); err == nil && authData.publicKey != nil {
+			_, _ = parsePublicKey(authData.publicKey)
+		}
+		_, _ = parsePublicKey(data)
+		_, _, _ = decodeCBOR(data)
+	})
+}
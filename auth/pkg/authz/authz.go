@@ -0,0 +1,122 @@
+// Package authz verifies access tokens issued by the auth service and enforces the permissions they carry.
+// Other services put Middleware in front of their routes, with a JWKSVerifier pointed at the auth service,
+// and guard individual routes with RequirePermission.
+package authz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token has expired")
+
+	ErrMissingAuthorization   = errors.New("authorization header is required")
+	ErrMalformedAuthorization = errors.New("invalid authorization header format")
+)
+
+// Claims are the contents of an access token
+type Claims struct {
+	UserID uuid.UUID `json:"user_id"`
+	Email  string    `json:"email"`
+	// SessionID is the login session the token was issued for
+	SessionID   uuid.UUID `json:"sid"`
+	Roles       []string  `json:"roles,omitempty"`
+	Permissions []string  `json:"permissions,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Grants are the roles of a user and the permissions those roles give, they are embedded in access tokens
+type Grants struct {
+	Roles       []string
+	Permissions []string
+}
+
+// HasPermission reports whether the token grants the permission
+func (c *Claims) HasPermission(permission string) bool {
+	return slices.Contains(c.Permissions, permission)
+}
+
+// Verifier checks an access token and returns its claims
+type Verifier interface {
+	ValidateToken(ctx context.Context, tokenString string) (*Claims, error)
+}
+
+// contextKey is unexported so only this package can set or read the claims
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the claims of the authenticated request
+func NewContext(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// ClaimsFromContext returns the claims Middleware put in the context
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(*Claims)
+	return claims, ok && claims != nil
+}
+
+// BearerToken returns the token of the request's Bearer authorization header
+func BearerToken(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", ErrMissingAuthorization
+	}
+
+	tokenString, ok := strings.CutPrefix(authHeader, "Bearer ")
+	if !ok || tokenString == "" {
+		return "", ErrMalformedAuthorization
+	}
+	return tokenString, nil
+}
+
+// Middleware only lets requests through with a valid Bearer token and puts its claims in the context
+func Middleware(verifier Verifier) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenString, err := BearerToken(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			claims, err := verifier.ValidateToken(r.Context(), tokenString)
+			if err != nil {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), claims)))
+		})
+	}
+}
+
+// RequirePermission only lets requests through whose token grants every given permission.
+// It reads the claims Middleware put in the context, so it has to run after it.
+func RequirePermission(permissions ...string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			for _, permission := range permissions {
+				if !claims.HasPermission(permission) {
+					http.Error(w, "forbidden", http.StatusForbidden)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// staticVerifier accepts a single token
+type staticVerifier struct {
+	token  string
+	claims *Claims
+}
+
+func (v staticVerifier) ValidateToken(ctx context.Context, tokenString string) (*Claims, error) {
+	if tokenString != v.token {
+		return nil, ErrInvalidToken
+	}
+	return v.claims, nil
+}
+
+func TestMiddlewareRequiresBearerToken(t *testing.T) {
+	claims := &Claims{UserID: uuid.New(), Permissions: []string{"roles:read"}}
+	handler := Middleware(staticVerifier{token: "good", claims: claims})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := ClaimsFromContext(r.Context())
+		if !ok || got != claims {
+			t.Errorf("ClaimsFromContext() = %v, %v, want the verified claims", got, ok)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := map[string]struct {
+		header string
+		want   int
+	}{
+		"valid token":     {header: "Bearer good", want: http.StatusNoContent},
+		"missing header":  {header: "", want: http.StatusUnauthorized},
+		"no bearer token": {header: "Bearer ", want: http.StatusUnauthorized},
+		"other scheme":    {header: "Basic dXNlcjpwYXNz", want: http.StatusUnauthorized},
+		"bare token":      {header: "good", want: http.StatusUnauthorized},
+		"invalid token":   {header: "Bearer bad", want: http.StatusUnauthorized},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	tests := map[string]struct {
+		claims *Claims
+		want   int
+	}{
+		"all permissions":     {claims: &Claims{Permissions: []string{"roles:read", "roles:write"}}, want: http.StatusNoContent},
+		"missing permission":  {claims: &Claims{Permissions: []string{"roles:read"}}, want: http.StatusForbidden},
+		"without middleware":  {claims: nil, want: http.StatusUnauthorized},
+		"without permissions": {claims: &Claims{}, want: http.StatusForbidden},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			handler := RequirePermission("roles:read", "roles:write")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.claims != nil {
+				req = req.WithContext(NewContext(req.Context(), tt.claims))
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, err := BearerToken(req); !errors.Is(err, ErrMissingAuthorization) {
+		t.Errorf("error = %v, want %v", err, ErrMissingAuthorization)
+	}
+
+	req.Header.Set("Authorization", "Token abc")
+	if _, err := BearerToken(req); !errors.Is(err, ErrMalformedAuthorization) {
+		t.Errorf("error = %v, want %v", err, ErrMalformedAuthorization)
+	}
+
+	req.Header.Set("Authorization", "Bearer abc")
+	if token, err := BearerToken(req); err != nil || token != "abc" {
+		t.Errorf("BearerToken() = %q, %v, want abc", token, err)
+	}
+}
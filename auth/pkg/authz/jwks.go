@@ -0,0 +1,267 @@
+package authz
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	defaultJWKSRefreshInterval = 5 * time.Minute
+	// minJWKSFetchInterval keeps tokens with made up key IDs from making us fetch the key set on every request
+	minJWKSFetchInterval = 10 * time.Second
+	defaultJWKSTimeout   = 10 * time.Second
+	maxJWKSBytes         = 1 << 20
+	minRSAKeyBits        = 2048
+)
+
+var (
+	ErrUnknownKey     = errors.New("unknown signing key")
+	ErrUnsupportedKey = errors.New("unsupported key")
+)
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicKey decodes the key, only the algorithms the auth service signs with are supported
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch {
+	case k.Algorithm == "RS256" && k.KeyType == "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedKey, err)
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedKey, err)
+		}
+		modulus := new(big.Int).SetBytes(n)
+		exponent := new(big.Int).SetBytes(e)
+		if modulus.BitLen() < minRSAKeyBits || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("%w: malformed RSA key", ErrUnsupportedKey)
+		}
+		return &rsa.PublicKey{N: modulus, E: int(exponent.Int64())}, nil
+	case k.Algorithm == "ES256" && k.KeyType == "EC" && k.Curve == "P-256":
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedKey, err)
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedKey, err)
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("%w: malformed P-256 key", ErrUnsupportedKey)
+		}
+		// ecdh rejects points that are not on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedKey, err)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case k.Algorithm == "EdDSA" && k.KeyType == "OKP" && k.Curve == "Ed25519":
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedKey, err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: malformed Ed25519 key", ErrUnsupportedKey)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("%w: %s %s", ErrUnsupportedKey, k.KeyType, k.Algorithm)
+}
+
+type JWKSConfig struct {
+	// URL is the auth service's /.well-known/jwks.json
+	URL string
+	// Issuer is checked against the iss claim when set
+	Issuer string
+	// RefreshInterval is how long a fetched key set is used, keys the auth service rotates in show up within it
+	RefreshInterval time.Duration
+	// Client fetches the key set, a client with a timeout is used when nil
+	Client *http.Client
+}
+
+func (c JWKSConfig) withDefaults() JWKSConfig {
+	if c.RefreshInterval <= 0 {
+		c.RefreshInterval = defaultJWKSRefreshInterval
+	}
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: defaultJWKSTimeout}
+	}
+	return c
+}
+
+type verificationKey struct {
+	algorithm string
+	public    crypto.PublicKey
+}
+
+// JWKSVerifier verifies tokens against the key set the auth service publishes. It fetches the set lazily,
+// again once it is older than the refresh interval and when a token names a key it does not know yet.
+// Revoked tokens are only rejected by the auth service itself, they stay valid here until they expire.
+type JWKSVerifier struct {
+	config JWKSConfig
+
+	// fetchMu lets one request fetch the key set while the others wait for it
+	fetchMu     sync.Mutex
+	mu          sync.RWMutex
+	keys        map[string]verificationKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+}
+
+func NewJWKSVerifier(config JWKSConfig) *JWKSVerifier {
+	return &JWKSVerifier{config: config.withDefaults()}
+}
+
+// ValidateToken checks the token's signature and expiry and returns its claims
+func (v *JWKSVerifier) ValidateToken(ctx context.Context, tokenString string) (*Claims, error) {
+	keyFunc := func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := v.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		// The key pins the algorithm so a token cannot pick a weaker one
+		if token.Method.Alg() != key.algorithm {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.public, nil
+	}
+
+	options := []jwt.ParserOption{jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"})}
+	if v.config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(v.config.Issuer))
+	}
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, keyFunc, options...)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, ErrExpiredToken
+		}
+		return nil, ErrInvalidToken
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (v *JWKSVerifier) key(ctx context.Context, kid string) (verificationKey, error) {
+	v.mu.RLock()
+	key, ok := v.keys[kid]
+	fresh := time.Since(v.fetchedAt) < v.config.RefreshInterval
+	v.mu.RUnlock()
+	if ok && fresh {
+		return key, nil
+	}
+
+	if err := v.fetch(ctx); err != nil {
+		// A stale key set beats failing every request while the auth service is unreachable
+		if ok {
+			return key, nil
+		}
+		return verificationKey{}, err
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	key, ok = v.keys[kid]
+	if !ok {
+		return verificationKey{}, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+	}
+	return key, nil
+}
+
+// fetch replaces the key set, unless it was attempted within minJWKSFetchInterval
+func (v *JWKSVerifier) fetch(ctx context.Context) error {
+	v.fetchMu.Lock()
+	defer v.fetchMu.Unlock()
+
+	v.mu.RLock()
+	recent := time.Since(v.attemptedAt) < minJWKSFetchInterval
+	v.mu.RUnlock()
+	if recent {
+		return nil
+	}
+
+	keys, err := v.download(ctx)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.attemptedAt = time.Now()
+	if err != nil {
+		return err
+	}
+	v.keys = keys
+	v.fetchedAt = v.attemptedAt
+	return nil
+}
+
+func (v *JWKSVerifier) download(ctx context.Context) (map[string]verificationKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.config.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.config.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch jwks: unexpected status %s", resp.Status)
+	}
+
+	var set JWKSet
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSBytes)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+
+	keys := make(map[string]verificationKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// Keys we cannot use are skipped, tokens signed with them fail as unknown
+		public, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = verificationKey{algorithm: jwk.Algorithm, public: public}
+	}
+	return keys, nil
+}
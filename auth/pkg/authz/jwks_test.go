@@ -0,0 +1,285 @@
+package authz
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const testIssuer = "auth-service"
+
+type testKey struct {
+	id     string
+	method jwt.SigningMethod
+	signer crypto.Signer
+}
+
+func newTestKey(t *testing.T, id string, method jwt.SigningMethod) testKey {
+	t.Helper()
+
+	var signer crypto.Signer
+	var err error
+	switch method {
+	case jwt.SigningMethodES256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwt.SigningMethodEdDSA:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("unsupported method %s", method.Alg())
+	}
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return testKey{id: id, method: method, signer: signer}
+}
+
+func (k testKey) jwk() JWK {
+	jwk := JWK{KeyID: k.id, Use: "sig", Algorithm: k.method.Alg()}
+	switch public := k.signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		jwk.KeyType = "EC"
+		jwk.Curve = "P-256"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, 32)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, 32)))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+	return jwk
+}
+
+func (k testKey) sign(t *testing.T, claims *Claims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = k.id
+	signed, err := token.SignedString(k.signer)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+func validClaims() *Claims {
+	return &Claims{
+		UserID:      uuid.New(),
+		Email:       "user@example.com",
+		SessionID:   uuid.New(),
+		Roles:       []string{"admin"},
+		Permissions: []string{"roles:read"},
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    testIssuer,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+}
+
+// jwksServer publishes the keys it holds and counts the fetches
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    []JWK
+	fetches atomic.Int32
+	down    atomic.Bool
+}
+
+func newJWKSServer(t *testing.T, keys ...testKey) *jwksServer {
+	t.Helper()
+
+	s := &jwksServer{}
+	s.publish(keys...)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		if s.down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		json.NewEncoder(w).Encode(JWKSet{Keys: s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) publish(keys ...testKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = nil
+	for _, key := range keys {
+		s.keys = append(s.keys, key.jwk())
+	}
+}
+
+// expire lets the verifier fetch again at once instead of waiting for the fetch interval
+func expire(v *JWKSVerifier) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.attemptedAt = time.Time{}
+}
+
+func TestJWKSVerifierValidatesTokens(t *testing.T) {
+	for _, method := range []jwt.SigningMethod{jwt.SigningMethodES256, jwt.SigningMethodEdDSA} {
+		t.Run(method.Alg(), func(t *testing.T) {
+			key := newTestKey(t, "current", method)
+			server := newJWKSServer(t, key)
+			verifier := NewJWKSVerifier(JWKSConfig{URL: server.URL, Issuer: testIssuer})
+
+			want := validClaims()
+			got, err := verifier.ValidateToken(context.Background(), key.sign(t, want))
+			if err != nil {
+				t.Fatalf("ValidateToken() error = %v", err)
+			}
+			if got.UserID != want.UserID || got.SessionID != want.SessionID || !got.HasPermission("roles:read") {
+				t.Errorf("claims = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestJWKSVerifierRejects(t *testing.T) {
+	key := newTestKey(t, "current", jwt.SigningMethodES256)
+	// other is not published, it signs under the published key ID
+	other := newTestKey(t, "current", jwt.SigningMethodES256)
+	server := newJWKSServer(t, key)
+
+	tests := map[string]struct {
+		token   func(t *testing.T) string
+		wantErr error
+	}{
+		"expired": {func(t *testing.T) string {
+			claims := validClaims()
+			claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+			return key.sign(t, claims)
+		}, ErrExpiredToken},
+		"other issuer": {func(t *testing.T) string {
+			claims := validClaims()
+			claims.Issuer = "someone-else"
+			return key.sign(t, claims)
+		}, ErrInvalidToken},
+		"forged signature": {func(t *testing.T) string {
+			return other.sign(t, validClaims())
+		}, ErrInvalidToken},
+		"unknown key": {func(t *testing.T) string {
+			return newTestKey(t, "unknown", jwt.SigningMethodES256).sign(t, validClaims())
+		}, ErrInvalidToken},
+		"hmac with the public key": {func(t *testing.T) string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims())
+			token.Header["kid"] = key.id
+			signed, err := token.SignedString([]byte(key.jwk().X))
+			if err != nil {
+				t.Fatalf("sign token: %v", err)
+			}
+			return signed
+		}, ErrInvalidToken},
+		"unsigned": {func(t *testing.T) string {
+			token := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims())
+			token.Header["kid"] = key.id
+			signed, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+			if err != nil {
+				t.Fatalf("sign token: %v", err)
+			}
+			return signed
+		}, ErrInvalidToken},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			verifier := NewJWKSVerifier(JWKSConfig{URL: server.URL, Issuer: testIssuer})
+			if _, err := verifier.ValidateToken(context.Background(), tt.token(t)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJWKSVerifierPicksUpRotatedKeys(t *testing.T) {
+	current := newTestKey(t, "current", jwt.SigningMethodEdDSA)
+	server := newJWKSServer(t, current)
+	verifier := NewJWKSVerifier(JWKSConfig{URL: server.URL})
+	ctx := context.Background()
+
+	if _, err := verifier.ValidateToken(ctx, current.sign(t, validClaims())); err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+
+	next := newTestKey(t, "next", jwt.SigningMethodEdDSA)
+	server.publish(current, next)
+	token := next.sign(t, validClaims())
+
+	// Right after a fetch an unknown key ID does not make the verifier fetch again
+	if _, err := verifier.ValidateToken(ctx, token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("error = %v, want %v", err, ErrInvalidToken)
+	}
+	if fetches := server.fetches.Load(); fetches != 1 {
+		t.Errorf("fetches = %d, want 1", fetches)
+	}
+
+	expire(verifier)
+	if _, err := verifier.ValidateToken(ctx, token); err != nil {
+		t.Errorf("ValidateToken() with the rotated key error = %v", err)
+	}
+	if fetches := server.fetches.Load(); fetches != 2 {
+		t.Errorf("fetches = %d, want 2", fetches)
+	}
+}
+
+func TestJWKSVerifierKeepsStaleKeysWhileUnreachable(t *testing.T) {
+	key := newTestKey(t, "current", jwt.SigningMethodES256)
+	server := newJWKSServer(t, key)
+	verifier := NewJWKSVerifier(JWKSConfig{URL: server.URL, RefreshInterval: time.Nanosecond})
+	ctx := context.Background()
+
+	if _, err := verifier.ValidateToken(ctx, key.sign(t, validClaims())); err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+
+	server.down.Store(true)
+	expire(verifier)
+	if _, err := verifier.ValidateToken(ctx, key.sign(t, validClaims())); err != nil {
+		t.Errorf("ValidateToken() with the auth service down error = %v", err)
+	}
+	if fetches := server.fetches.Load(); fetches != 2 {
+		t.Errorf("fetches = %d, want 2", fetches)
+	}
+}
+
+func TestJWKPublicKeyRejectsMalformed(t *testing.T) {
+	valid := newTestKey(t, "current", jwt.SigningMethodES256).jwk()
+
+	tests := map[string]func(jwk *JWK){
+		"unsupported algorithm": func(jwk *JWK) { jwk.Algorithm = "HS256" },
+		"wrong curve":           func(jwk *JWK) { jwk.Curve = "P-384" },
+		"short coordinate":      func(jwk *JWK) { jwk.X = jwk.X[:10] },
+		"not base64url":         func(jwk *JWK) { jwk.Y = "!!" },
+		"point off the curve":   func(jwk *JWK) { jwk.Y = base64.RawURLEncoding.EncodeToString(make([]byte, 32)) },
+	}
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			jwk := valid
+			mutate(&jwk)
+			if _, err := jwk.PublicKey(); !errors.Is(err, ErrUnsupportedKey) {
+				t.Errorf("error = %v, want %v", err, ErrUnsupportedKey)
+			}
+		})
+	}
+}
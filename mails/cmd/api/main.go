@@ -1 +1,85 @@
 package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/yoshapihoff/bricks/mails/internal/config"
+	"github.com/yoshapihoff/bricks/mails/internal/kafka"
+	"github.com/yoshapihoff/bricks/mails/internal/service"
+	"github.com/yoshapihoff/bricks/mails/internal/templates"
+	"github.com/yoshapihoff/bricks/mails/internal/transport"
+	sendEmail "github.com/yoshapihoff/bricks/mails/pkg/sendEmail.v1"
+)
+
+func main() {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Load email templates
+	var templateRegistry *templates.Registry
+	if cfg.TemplatesDir != "" {
+		templateRegistry, err = templates.Load(os.DirFS(cfg.TemplatesDir))
+	} else {
+		templateRegistry, err = templates.LoadEmbedded()
+	}
+	if err != nil {
+		log.Fatalf("Failed to load email templates: %v", err)
+	}
+
+	// Initialize email transport
+	mailTransport, err := transport.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize mail transport: %v", err)
+	}
+
+	// Initialize services
+	emailSvc := service.NewEmailService(mailTransport, templateRegistry, cfg.SMTP.From)
+
+	// Initialize email Kafka consumer
+	emailConsumer, err := kafka.NewConsumer(cfg.Kafka.KafkaUrl, cfg.Kafka.SchemaRegistryUrl, cfg.Kafka.GroupID, kafka.DefaultConsumerConfig())
+	if err != nil {
+		log.Fatalf("Failed to initialize email Kafka consumer: %v", err)
+	}
+	defer emailConsumer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Run consumer in a goroutine
+	done := make(chan error, 1)
+	go func() {
+		log.Printf("Consuming %v\n", cfg.EmailSendingTopics())
+		done <- emailConsumer.Run(
+			ctx,
+			(&sendEmail.SendEmail{}).ProtoReflect().Type(),
+			cfg.EmailSendingTopics(),
+			emailSvc.HandleMessage,
+		)
+	}()
+
+	// Graceful shutdown
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case <-quit:
+		log.Println("Shutting down consumer...")
+		cancel()
+		if err := <-done; err != nil {
+			log.Printf("Consumer stopped with error: %v\n", err)
+		}
+	case err := <-done:
+		if err != nil {
+			log.Fatalf("Consumer stopped with error: %v\n", err)
+		}
+	}
+
+	log.Println("Consumer stopped")
+}
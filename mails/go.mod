@@ -2,4 +2,17 @@ module github.com/yoshapihoff/bricks/mails
 
 go 1.24.2
 
-require google.golang.org/protobuf v1.36.8
+require (
+	github.com/confluentinc/confluent-kafka-go v1.9.2
+	github.com/joho/godotenv v1.5.1
+	google.golang.org/protobuf v1.36.8
+)
+
+require (
+	github.com/golang/protobuf v1.5.4 // indirect
+	github.com/google/go-cmp v0.7.0 // indirect
+	github.com/jhump/protoreflect v1.12.0 // indirect
+	google.golang.org/genproto v0.0.0-20230530153820-e85fd2cbaebc // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250728155136-f173205681a0 // indirect
+	google.golang.org/grpc v1.74.2 // indirect
+)
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+var ErrNoEmailSendingTopics = errors.New("no email sending topic is configured")
+
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	// TLSMode is one of "none", "starttls" or "tls" (implicit TLS)
+	TLSMode string
+	// AuthMechanism is one of "plain" or "login"
+	AuthMechanism string
+}
+
+type TransportConfig struct {
+	// Kind is one of "smtp", "file" or "memory"
+	Kind    string
+	DropDir string
+}
+
+type KafkaConfig struct {
+	KafkaUrl          string
+	SchemaRegistryUrl string
+	GroupID           string
+}
+
+type Config struct {
+	SMTP                               SMTPConfig
+	Transport                          TransportConfig
+	Kafka                              KafkaConfig
+	TemplatesDir                       string
+	ForgotPasswordEmailSendingTopic    string
+	EmailChangeEmailSendingTopic       string
+	EmailVerificationEmailSendingTopic string
+	MagicLinkEmailSendingTopic         string
+}
+
+func Load() (*Config, error) {
+	// Load environment variables
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found, using environment variables")
+	}
+
+	cfg := &Config{
+		SMTP: SMTPConfig{
+			Host:          getEnv("SMTP_HOST"),
+			Port:          getEnv("SMTP_PORT"),
+			Username:      getEnv("SMTP_USERNAME"),
+			Password:      getEnv("SMTP_PASSWORD"),
+			From:          getEnv("SMTP_FROM"),
+			TLSMode:       getEnv("SMTP_TLS_MODE"),
+			AuthMechanism: getEnv("SMTP_AUTH_MECHANISM"),
+		},
+		Transport: TransportConfig{
+			Kind:    getEnv("MAIL_TRANSPORT"),
+			DropDir: getEnv("MAIL_DROP_DIR"),
+		},
+		Kafka: KafkaConfig{
+			KafkaUrl:          getEnv("KAFKA_URL"),
+			SchemaRegistryUrl: getEnv("SCHEMA_REGISTRY_URL"),
+			GroupID:           getEnv("KAFKA_GROUP_ID"),
+		},
+		TemplatesDir:                       getEnv("TEMPLATES_DIR"),
+		ForgotPasswordEmailSendingTopic:    getEnv("FORGOT_PASSWORD_EMAIL_SENDING_TOPIC"),
+		EmailChangeEmailSendingTopic:       getEnv("EMAIL_CHANGE_EMAIL_SENDING_TOPIC"),
+		EmailVerificationEmailSendingTopic: getEnv("EMAIL_VERIFICATION_EMAIL_SENDING_TOPIC"),
+		MagicLinkEmailSendingTopic:         getEnv("MAGIC_LINK_EMAIL_SENDING_TOPIC"),
+	}
+
+	if len(cfg.EmailSendingTopics()) == 0 {
+		return nil, ErrNoEmailSendingTopics
+	}
+
+	return cfg, nil
+}
+
+// EmailSendingTopics returns every configured topic that carries SendEmail messages, unset ones are skipped
+func (c *Config) EmailSendingTopics() []string {
+	var topics []string
+	for _, topic := range []string{
+		c.ForgotPasswordEmailSendingTopic,
+		c.EmailChangeEmailSendingTopic,
+		c.EmailVerificationEmailSendingTopic,
+		c.MagicLinkEmailSendingTopic,
+	} {
+		if topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
+
+// GetAddr returns the SMTP server address
+func (c *SMTPConfig) GetAddr() string {
+	return c.Host + ":" + c.Port
+}
+
+// getEnv gets an environment variable, an unset variable reads as empty
+func getEnv(key string) string {
+	return os.Getenv(key)
+}
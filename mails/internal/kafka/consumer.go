@@ -0,0 +1,283 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/confluentinc/confluent-kafka-go/schemaregistry"
+	"github.com/confluentinc/confluent-kafka-go/schemaregistry/serde"
+	"github.com/confluentinc/confluent-kafka-go/schemaregistry/serde/protobuf"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	defaultSessionTimeout = 6000
+	pollTimeout           = 100 * time.Millisecond
+
+	defaultMaxRetries            = 3
+	defaultInitialBackoff        = 100 * time.Millisecond
+	defaultMaxBackoff            = 10 * time.Second
+	defaultDeadLetterTopicSuffix = ".dlq"
+
+	HeaderError             = "x-error"
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+)
+
+var ErrUnexpectedMessage = errors.New("unexpected message type")
+
+// Result tells the consumer what to do with a message once the handler returns
+type Result int
+
+const (
+	// Ack commits the message
+	Ack Result = iota
+	// Retry runs the handler again after a backoff
+	Retry
+	// DeadLetter publishes the message to the dead-letter topic and commits it
+	DeadLetter
+)
+
+// HandlerError carries the result the handler wants for a failed message
+type HandlerError struct {
+	Result Result
+	Err    error
+}
+
+func (e *HandlerError) Error() string {
+	if e.Err == nil {
+		return "handler failed"
+	}
+	return e.Err.Error()
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// RetryError asks the consumer to retry the message
+func RetryError(err error) error {
+	return &HandlerError{Result: Retry, Err: err}
+}
+
+// DeadLetterError asks the consumer to dead-letter the message without retrying
+func DeadLetterError(err error) error {
+	return &HandlerError{Result: DeadLetter, Err: err}
+}
+
+// ResultOf maps a handler error to a Result, plain errors are retried
+func ResultOf(err error) Result {
+	if err == nil {
+		return Ack
+	}
+	var handlerErr *HandlerError
+	if errors.As(err, &handlerErr) {
+		return handlerErr.Result
+	}
+	return Retry
+}
+
+// MessageHandler processes a single deserialized message
+type MessageHandler func(ctx context.Context, message proto.Message) error
+
+type ConsumerConfig struct {
+	// MaxRetries is the number of times a message is retried before it is dead-lettered
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// DeadLetterTopicSuffix is appended to the source topic to build the dead-letter topic
+	DeadLetterTopicSuffix string
+}
+
+func DefaultConsumerConfig() ConsumerConfig {
+	return ConsumerConfig{
+		MaxRetries:            defaultMaxRetries,
+		InitialBackoff:        defaultInitialBackoff,
+		MaxBackoff:            defaultMaxBackoff,
+		DeadLetterTopicSuffix: defaultDeadLetterTopicSuffix,
+	}
+}
+
+// withDefaults fills the fields left empty, an empty suffix would dead-letter onto the source topic
+func (c ConsumerConfig) withDefaults() ConsumerConfig {
+	if c.DeadLetterTopicSuffix == "" {
+		c.DeadLetterTopicSuffix = defaultDeadLetterTopicSuffix
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = defaultInitialBackoff
+	}
+	if c.MaxBackoff < c.InitialBackoff {
+		c.MaxBackoff = max(defaultMaxBackoff, c.InitialBackoff)
+	}
+	return c
+}
+
+type SRConsumer interface {
+	Run(ctx context.Context, messageType protoreflect.MessageType, topics []string, handler MessageHandler) error
+	Close()
+}
+
+type srConsumer struct {
+	consumer     *kafka.Consumer
+	dlqProducer  *kafka.Producer
+	deserializer *protobuf.Deserializer
+	config       ConsumerConfig
+}
+
+func NewConsumer(kafkaURL, srURL string, groupID string, config ConsumerConfig) (SRConsumer, error) {
+	c, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":  kafkaURL,
+		"group.id":           groupID,
+		"session.timeout.ms": defaultSessionTimeout,
+		"enable.auto.commit": false,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": kafkaURL})
+	if err != nil {
+		return nil, err
+	}
+
+	sr, err := schemaregistry.NewClient(schemaregistry.NewConfig(srURL))
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := protobuf.NewDeserializer(sr, serde.ValueSerde, protobuf.NewDeserializerConfig())
+	if err != nil {
+		return nil, err
+	}
+	return &srConsumer{
+		consumer:     c,
+		dlqProducer:  p,
+		deserializer: d,
+		config:       config.withDefaults(),
+	}, nil
+}
+
+// Run consumes the topics until the context is cancelled. A message is only committed once it was
+// handled or dead-lettered, so a crash or shutdown in between redelivers it.
+func (c *srConsumer) Run(ctx context.Context, messageType protoreflect.MessageType, topics []string, handler MessageHandler) error {
+	if err := c.consumer.SubscribeTopics(topics, nil); err != nil {
+		return err
+	}
+	defer c.consumer.Unsubscribe()
+	if err := c.deserializer.ProtoRegistry.RegisterMessage(messageType); err != nil {
+		return err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil
+		}
+		kafkaMsg, err := c.consumer.ReadMessage(pollTimeout)
+		if err != nil {
+			var kafkaErr kafka.Error
+			if errors.As(err, &kafkaErr) && kafkaErr.Code() == kafka.ErrTimedOut {
+				continue
+			}
+			return err
+		}
+
+		if err := c.handleMessage(ctx, messageType, handler, kafkaMsg); err != nil {
+			if ctx.Err() != nil {
+				// Leave the message uncommitted so it is redelivered after restart
+				return nil
+			}
+			return err
+		}
+		if _, err = c.consumer.CommitMessage(kafkaMsg); err != nil {
+			return err
+		}
+	}
+}
+
+// handleMessage runs the handler with retries and dead-letters the message when it cannot be processed.
+// The returned error is only non-nil when the message could not be dead-lettered either.
+func (c *srConsumer) handleMessage(ctx context.Context, messageType protoreflect.MessageType, handler MessageHandler, kafkaMsg *kafka.Message) error {
+	msg, err := c.deserializer.Deserialize(*kafkaMsg.TopicPartition.Topic, kafkaMsg.Value)
+	if err != nil {
+		return c.deadLetter(kafkaMsg, err)
+	}
+	protoMsg, ok := msg.(proto.Message)
+	if !ok || protoMsg.ProtoReflect().Descriptor().FullName() != messageType.Descriptor().FullName() {
+		return c.deadLetter(kafkaMsg, ErrUnexpectedMessage)
+	}
+
+	if err := handleWithRetry(ctx, c.config, handler, protoMsg); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return c.deadLetter(kafkaMsg, err)
+	}
+	return nil
+}
+
+// deadLetter publishes the original payload to the dead-letter topic and waits for the broker ack
+func (c *srConsumer) deadLetter(kafkaMsg *kafka.Message, cause error) error {
+	topic := *kafkaMsg.TopicPartition.Topic + c.config.DeadLetterTopicSuffix
+	log.Printf("Dead-lettering message at %v to %s: %v", kafkaMsg.TopicPartition, topic, cause)
+
+	deliveryChan := make(chan kafka.Event, 1)
+	if err := c.dlqProducer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            kafkaMsg.Key,
+		Value:          kafkaMsg.Value,
+		Headers: append(kafkaMsg.Headers,
+			kafka.Header{Key: HeaderError, Value: []byte(cause.Error())},
+			kafka.Header{Key: HeaderOriginalTopic, Value: []byte(*kafkaMsg.TopicPartition.Topic)},
+			kafka.Header{Key: HeaderOriginalPartition, Value: []byte(strconv.Itoa(int(kafkaMsg.TopicPartition.Partition)))},
+			kafka.Header{Key: HeaderOriginalOffset, Value: []byte(strconv.FormatInt(int64(kafkaMsg.TopicPartition.Offset), 10))},
+		),
+	}, deliveryChan); err != nil {
+		return err
+	}
+
+	e := <-deliveryChan
+	if m, ok := e.(*kafka.Message); ok {
+		return m.TopicPartition.Error
+	}
+	return fmt.Errorf("unexpected delivery event: %v", e)
+}
+
+func (c *srConsumer) Close() {
+	if err := c.consumer.Close(); err != nil {
+		log.Fatal(err)
+	}
+	c.dlqProducer.Close()
+	c.deserializer.Close()
+}
+
+// handleWithRetry runs the handler until it succeeds, asks for a dead letter or runs out of retries.
+// It returns nil once the message was handled and otherwise the error to dead-letter it with.
+func handleWithRetry(ctx context.Context, config ConsumerConfig, handler MessageHandler, msg proto.Message) error {
+	backoff := config.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		err := handler(ctx, msg)
+		switch ResultOf(err) {
+		case Ack:
+			return nil
+		case DeadLetter:
+			return err
+		}
+
+		if attempt >= config.MaxRetries {
+			return fmt.Errorf("retries exhausted: %w", err)
+		}
+		log.Printf("Retrying message in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, config.MaxBackoff)
+	}
+}
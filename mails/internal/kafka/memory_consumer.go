@@ -0,0 +1,82 @@
+package kafka
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// DeadLetteredMessage is a message the memory consumer gave up on along with the reason
+type DeadLetteredMessage struct {
+	Message proto.Message
+	Err     error
+}
+
+// MemoryConsumer is an in-process SRConsumer fed through Push, it retries and dead-letters like the Kafka one
+type MemoryConsumer struct {
+	messages chan proto.Message
+	config   ConsumerConfig
+
+	mu          sync.Mutex
+	deadLetters []DeadLetteredMessage
+}
+
+func NewMemoryConsumer(bufferSize int, config ConsumerConfig) *MemoryConsumer {
+	return &MemoryConsumer{
+		messages: make(chan proto.Message, bufferSize),
+		config:   config.withDefaults(),
+	}
+}
+
+// Push enqueues a message for the running consumer
+func (c *MemoryConsumer) Push(msg proto.Message) {
+	c.messages <- msg
+}
+
+// Run dispatches pushed messages until the context is cancelled or the consumer is closed
+func (c *MemoryConsumer) Run(ctx context.Context, messageType protoreflect.MessageType, topics []string, handler MessageHandler) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-c.messages:
+			if !ok {
+				return nil
+			}
+			if msg.ProtoReflect().Descriptor().FullName() != messageType.Descriptor().FullName() {
+				c.deadLetter(topics, msg, ErrUnexpectedMessage)
+				continue
+			}
+			if err := handleWithRetry(ctx, c.config, handler, msg); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				c.deadLetter(topics, msg, err)
+			}
+		}
+	}
+}
+
+// DeadLetters returns every message that was dead-lettered so far
+func (c *MemoryConsumer) DeadLetters() []DeadLetteredMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]DeadLetteredMessage(nil), c.deadLetters...)
+}
+
+func (c *MemoryConsumer) Close() {
+	close(c.messages)
+}
+
+func (c *MemoryConsumer) deadLetter(topics []string, msg proto.Message, cause error) {
+	log.Printf("Dead-lettering message on %v: %v", topics, cause)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deadLetters = append(c.deadLetters, DeadLetteredMessage{Message: msg, Err: cause})
+}
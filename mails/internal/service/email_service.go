@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/yoshapihoff/bricks/mails/internal/kafka"
+	"github.com/yoshapihoff/bricks/mails/internal/templates"
+	"github.com/yoshapihoff/bricks/mails/internal/transport"
+	sendEmail "github.com/yoshapihoff/bricks/mails/pkg/sendEmail.v1"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	ErrNoRecipients   = errors.New("no recipients")
+	ErrUnexpectedType = errors.New("unexpected message type")
+)
+
+type EmailService interface {
+	Send(ctx context.Context, msg *sendEmail.SendEmail) error
+	HandleMessage(ctx context.Context, msg proto.Message) error
+}
+
+type DefaultEmailService struct {
+	transport transport.Transport
+	templates *templates.Registry
+	from      string
+}
+
+func NewEmailService(transport transport.Transport, templates *templates.Registry, from string) *DefaultEmailService {
+	return &DefaultEmailService{
+		transport: transport,
+		templates: templates,
+		from:      from,
+	}
+}
+
+// HandleMessage adapts Send to the Kafka consumer handler signature.
+// Messages that can never be sent are dead-lettered, other failures are retried.
+func (s *DefaultEmailService) HandleMessage(ctx context.Context, msg proto.Message) error {
+	sendEmailMsg, ok := msg.(*sendEmail.SendEmail)
+	if !ok {
+		return kafka.DeadLetterError(ErrUnexpectedType)
+	}
+
+	err := s.Send(ctx, sendEmailMsg)
+	if isPermanent(err) {
+		return kafka.DeadLetterError(err)
+	}
+	return err
+}
+
+// Send renders the requested template with the message params and delivers it
+func (s *DefaultEmailService) Send(ctx context.Context, msg *sendEmail.SendEmail) error {
+	if len(msg.GetTo()) == 0 {
+		return ErrNoRecipients
+	}
+
+	rendered, err := s.templates.Render(msg.GetTemplate(), msg.GetParams())
+	if err != nil {
+		return err
+	}
+
+	return s.transport.Send(ctx, &transport.Message{
+		From:     s.from,
+		To:       msg.GetTo(),
+		Subject:  msg.GetSubject(),
+		HTMLBody: rendered.HTML,
+		TextBody: rendered.Text,
+	})
+}
+
+// isPermanent reports whether the message itself is at fault, so retrying it cannot help
+func isPermanent(err error) bool {
+	return errors.Is(err, ErrNoRecipients) ||
+		errors.Is(err, templates.ErrTemplateNotFound) ||
+		errors.Is(err, templates.ErrMissingParams) ||
+		transport.IsPermanent(err)
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/yoshapihoff/bricks/mails/internal/kafka"
+	"github.com/yoshapihoff/bricks/mails/internal/templates"
+	"github.com/yoshapihoff/bricks/mails/internal/transport"
+	sendEmail "github.com/yoshapihoff/bricks/mails/pkg/sendEmail.v1"
+)
+
+// failingTransport fails with the queued errors before handing messages to the memory transport
+type failingTransport struct {
+	errs     []error
+	attempts int
+	*transport.MemoryTransport
+}
+
+func (t *failingTransport) Send(ctx context.Context, msg *transport.Message) error {
+	t.attempts++
+	if len(t.errs) > 0 {
+		err := t.errs[0]
+		t.errs = t.errs[1:]
+		return err
+	}
+	return t.MemoryTransport.Send(ctx, msg)
+}
+
+func testConsumerConfig() kafka.ConsumerConfig {
+	config := kafka.DefaultConsumerConfig()
+	config.InitialBackoff = time.Millisecond
+	config.MaxBackoff = time.Millisecond
+	return config
+}
+
+// consume pushes the messages through an in-memory consumer into the email service and waits until all are processed
+func consume(t *testing.T, mailTransport transport.Transport, messages ...*sendEmail.SendEmail) *kafka.MemoryConsumer {
+	t.Helper()
+
+	registry, err := templates.LoadEmbedded()
+	if err != nil {
+		t.Fatalf("load templates: %v", err)
+	}
+	svc := NewEmailService(mailTransport, registry, "no-reply@bricks.local")
+
+	consumer := kafka.NewMemoryConsumer(len(messages), testConsumerConfig())
+	for _, msg := range messages {
+		consumer.Push(msg)
+	}
+	consumer.Close()
+
+	if err := consumer.Run(context.Background(), (&sendEmail.SendEmail{}).ProtoReflect().Type(), []string{"magic-link-email-sending"}, svc.HandleMessage); err != nil {
+		t.Fatalf("run consumer: %v", err)
+	}
+	return consumer
+}
+
+func magicLinkEmail() *sendEmail.SendEmail {
+	return &sendEmail.SendEmail{
+		To:       []string{"user@example.com"},
+		Subject:  "Sign in",
+		Template: "magic-link",
+		Params:   map[string]string{"magic_link_token": "token-123"},
+	}
+}
+
+func TestHandleMessageDeliversEmail(t *testing.T) {
+	mailTransport := transport.NewMemoryTransport()
+	consumer := consume(t, mailTransport, magicLinkEmail())
+
+	if dead := consumer.DeadLetters(); len(dead) != 0 {
+		t.Fatalf("dead letters = %v, want none", dead)
+	}
+	sent := mailTransport.Messages()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sent))
+	}
+	if sent[0].To[0] != "user@example.com" || sent[0].Subject != "Sign in" {
+		t.Errorf("sent message = %+v", sent[0])
+	}
+}
+
+func TestHandleMessageRetriesTransientFailures(t *testing.T) {
+	mailTransport := &failingTransport{
+		errs:            []error{errors.New("connection reset"), &textproto.Error{Code: 421, Msg: "try again later"}},
+		MemoryTransport: transport.NewMemoryTransport(),
+	}
+	consumer := consume(t, mailTransport, magicLinkEmail())
+
+	if dead := consumer.DeadLetters(); len(dead) != 0 {
+		t.Fatalf("dead letters = %v, want none", dead)
+	}
+	if mailTransport.attempts != 3 {
+		t.Errorf("attempts = %d, want 3", mailTransport.attempts)
+	}
+	if len(mailTransport.Messages()) != 1 {
+		t.Errorf("sent %d messages, want 1", len(mailTransport.Messages()))
+	}
+}
+
+func TestHandleMessageDeadLettersAfterRetries(t *testing.T) {
+	errs := make([]error, 10)
+	for i := range errs {
+		errs[i] = errors.New("connection refused")
+	}
+	mailTransport := &failingTransport{errs: errs, MemoryTransport: transport.NewMemoryTransport()}
+	consumer := consume(t, mailTransport, magicLinkEmail())
+
+	if dead := consumer.DeadLetters(); len(dead) != 1 {
+		t.Fatalf("dead letters = %d, want 1", len(dead))
+	}
+	if want := testConsumerConfig().MaxRetries + 1; mailTransport.attempts != want {
+		t.Errorf("attempts = %d, want %d", mailTransport.attempts, want)
+	}
+}
+
+func TestHandleMessageDeadLettersPermanentFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport *failingTransport
+		msg       *sendEmail.SendEmail
+		wantErr   error
+	}{
+		{
+			name:      "rejected recipient",
+			transport: &failingTransport{errs: []error{&textproto.Error{Code: 550, Msg: "no such user"}}},
+			msg:       magicLinkEmail(),
+		},
+		{
+			name:      "no recipients",
+			transport: &failingTransport{},
+			msg: &sendEmail.SendEmail{
+				Template: "magic-link",
+				Params:   map[string]string{"magic_link_token": "token-123"},
+			},
+			wantErr: ErrNoRecipients,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.transport.MemoryTransport = transport.NewMemoryTransport()
+			consumer := consume(t, tt.transport, tt.msg)
+
+			dead := consumer.DeadLetters()
+			if len(dead) != 1 {
+				t.Fatalf("dead letters = %d, want 1", len(dead))
+			}
+			if tt.wantErr != nil && !errors.Is(dead[0].Err, tt.wantErr) {
+				t.Errorf("dead letter error = %v, want %v", dead[0].Err, tt.wantErr)
+			}
+			if tt.transport.attempts > 1 {
+				t.Errorf("attempts = %d, permanent failures must not be retried", tt.transport.attempts)
+			}
+		})
+	}
+}
+
+func TestHandleMessageDeadLettersInvalidTemplateRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		template    string
+		params      map[string]string
+		wantErr     error
+		wantMissing []string
+	}{
+		{
+			name:        "missing params",
+			template:    "magic-link",
+			params:      map[string]string{},
+			wantErr:     templates.ErrMissingParams,
+			wantMissing: []string{"magic_link_token"},
+		},
+		{
+			name:     "unknown template",
+			template: "no-such-template",
+			params:   map[string]string{"magic_link_token": "token-123"},
+			wantErr:  templates.ErrTemplateNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mailTransport := &failingTransport{MemoryTransport: transport.NewMemoryTransport()}
+			consumer := consume(t, mailTransport, &sendEmail.SendEmail{
+				To:       []string{"user@example.com"},
+				Subject:  "Sign in",
+				Template: tt.template,
+				Params:   tt.params,
+			})
+
+			dead := consumer.DeadLetters()
+			if len(dead) != 1 {
+				t.Fatalf("dead letters = %d, want 1", len(dead))
+			}
+			if !errors.Is(dead[0].Err, tt.wantErr) {
+				t.Errorf("dead letter error = %v, want %v", dead[0].Err, tt.wantErr)
+			}
+			var missingErr *templates.MissingParamsError
+			if tt.wantMissing != nil && (!errors.As(dead[0].Err, &missingErr) || !slices.Equal(missingErr.Params, tt.wantMissing)) {
+				t.Errorf("dead letter error = %v, want missing %v", dead[0].Err, tt.wantMissing)
+			}
+			if mailTransport.attempts != 0 {
+				t.Errorf("transport was called %d times, want 0", mailTransport.attempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package templates
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"text/template/parse"
+)
+
+const (
+	htmlExt = ".html"
+	textExt = ".txt"
+)
+
+var (
+	ErrTemplateNotFound = errors.New("template not found")
+	ErrMissingParams    = errors.New("missing template params")
+)
+
+//go:embed files
+var embedded embed.FS
+
+// MissingParamsError reports the placeholders a template uses that were not supplied
+type MissingParamsError struct {
+	Template string
+	Params   []string
+}
+
+func (e *MissingParamsError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", ErrMissingParams, e.Template, strings.Join(e.Params, ", "))
+}
+
+func (e *MissingParamsError) Is(target error) bool {
+	return target == ErrMissingParams
+}
+
+// Rendered holds the bodies of a rendered template
+type Rendered struct {
+	HTML string
+	Text string
+}
+
+type Template struct {
+	html   *htmltemplate.Template
+	text   *texttemplate.Template
+	params []string
+}
+
+// Params returns the placeholders used by the template
+func (t *Template) Params() []string {
+	return t.params
+}
+
+type Registry struct {
+	templates map[string]*Template
+}
+
+// LoadEmbedded loads the templates bundled with the service
+func LoadEmbedded() (*Registry, error) {
+	fsys, err := fs.Sub(embedded, "files")
+	if err != nil {
+		return nil, err
+	}
+	return Load(fsys)
+}
+
+// Load parses every <name>.html and <name>.txt file at the root of fsys
+func Load(fsys fs.FS) (*Registry, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Registry{templates: make(map[string]*Template)}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := path.Ext(entry.Name())
+		if ext != htmlExt && ext != textExt {
+			continue
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimSuffix(entry.Name(), ext)
+		t, ok := r.templates[name]
+		if !ok {
+			t = &Template{}
+			r.templates[name] = t
+		}
+
+		switch ext {
+		case htmlExt:
+			t.html, err = htmltemplate.New(entry.Name()).Option("missingkey=error").Parse(string(content))
+			if err == nil {
+				t.params = mergeParams(t.params, collectParams(t.html.Tree))
+			}
+		case textExt:
+			t.text, err = texttemplate.New(entry.Name()).Option("missingkey=error").Parse(string(content))
+			if err == nil {
+				t.params = mergeParams(t.params, collectParams(t.text.Tree))
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", entry.Name(), err)
+		}
+	}
+
+	return r, nil
+}
+
+// Get returns the template registered under name
+func (r *Registry) Get(name string) (*Template, error) {
+	t, ok := r.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	return t, nil
+}
+
+// Render validates params against the template placeholders and renders both bodies
+func (r *Registry) Render(name string, params map[string]string) (*Rendered, error) {
+	t, err := r.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, param := range t.params {
+		if _, ok := params[param]; !ok {
+			missing = append(missing, param)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &MissingParamsError{Template: name, Params: missing}
+	}
+
+	rendered := &Rendered{}
+	if t.html != nil {
+		var buf bytes.Buffer
+		if err := t.html.Execute(&buf, params); err != nil {
+			return nil, err
+		}
+		rendered.HTML = buf.String()
+	}
+	if t.text != nil {
+		var buf bytes.Buffer
+		if err := t.text.Execute(&buf, params); err != nil {
+			return nil, err
+		}
+		rendered.Text = buf.String()
+	}
+
+	return rendered, nil
+}
+
+// collectParams returns the top-level fields referenced by the template
+func collectParams(tree *parse.Tree) []string {
+	if tree == nil || tree.Root == nil {
+		return nil
+	}
+	seen := make(map[string]struct{})
+	walkNode(tree.Root, seen)
+
+	params := make([]string, 0, len(seen))
+	for param := range seen {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	return params
+}
+
+func walkNode(node parse.Node, seen map[string]struct{}) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkNode(child, seen)
+		}
+	case *parse.ActionNode:
+		walkNode(n.Pipe, seen)
+	case *parse.IfNode:
+		walkNode(n.Pipe, seen)
+		walkNode(n.List, seen)
+		walkNode(n.ElseList, seen)
+	case *parse.RangeNode:
+		// Dot is rebound inside the body, only the pipeline refers to params
+		walkNode(n.Pipe, seen)
+	case *parse.WithNode:
+		walkNode(n.Pipe, seen)
+	case *parse.TemplateNode:
+		walkNode(n.Pipe, seen)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walkNode(cmd, seen)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			walkNode(arg, seen)
+		}
+	case *parse.FieldNode:
+		seen[n.Ident[0]] = struct{}{}
+	}
+}
+
+func mergeParams(a, b []string) []string {
+	seen := make(map[string]struct{}, len(a)+len(b))
+	merged := make([]string, 0, len(a)+len(b))
+	for _, param := range append(a, b...) {
+		if _, ok := seen[param]; ok {
+			continue
+		}
+		seen[param] = struct{}{}
+		merged = append(merged, param)
+	}
+	sort.Strings(merged)
+	return merged
+}
@@ -0,0 +1,111 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileTransportWritesEML(t *testing.T) {
+	dir := t.TempDir()
+	fileTransport, err := NewFileTransport(dir)
+	if err != nil {
+		t.Fatalf("new transport: %v", err)
+	}
+
+	if err := fileTransport.Send(context.Background(), testMessage()); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	if tmp, _ := os.ReadDir(filepath.Join(dir, "tmp")); len(tmp) != 0 {
+		t.Errorf("tmp holds %d files, want none", len(tmp))
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		t.Fatalf("read new: %v", err)
+	}
+	if len(entries) != 1 || !strings.HasSuffix(entries[0].Name(), ".eml") {
+		t.Fatalf("new holds %v, want one .eml file", entries)
+	}
+
+	file, err := os.Open(filepath.Join(dir, "new", entries[0].Name()))
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer file.Close()
+	parsed, err := mail.ReadMessage(file)
+	if err != nil {
+		t.Fatalf("parse eml: %v", err)
+	}
+
+	if got := parsed.Header.Get("From"); got != "no-reply@bricks.local" {
+		t.Errorf("From = %q", got)
+	}
+	if got := parsed.Header.Get("To"); got != "user@example.com, other@example.com" {
+		t.Errorf("To = %q", got)
+	}
+	if got := parsed.Header.Get("Subject"); got != "Verify your email" {
+		t.Errorf("Subject = %q", got)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, %v", parsed.Header.Get("Content-Type"), err)
+	}
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	var contentTypes []string
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("next part: %v", err)
+		}
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("read part: %v", err)
+		}
+		if !strings.Contains(string(body), "Your token is abc") {
+			t.Errorf("part body = %q", body)
+		}
+		contentTypes = append(contentTypes, strings.Split(part.Header.Get("Content-Type"), ";")[0])
+	}
+	if strings.Join(contentTypes, ",") != "text/plain,text/html" {
+		t.Errorf("parts = %v, want text/plain then text/html", contentTypes)
+	}
+}
+
+func TestFileTransportRejectsHeaderInjection(t *testing.T) {
+	dir := t.TempDir()
+	fileTransport, err := NewFileTransport(dir)
+	if err != nil {
+		t.Fatalf("new transport: %v", err)
+	}
+
+	tests := map[string]func(msg *Message){
+		"from":    func(msg *Message) { msg.From = "a@example.com\r\nBcc: victim@example.com" },
+		"to":      func(msg *Message) { msg.To = []string{"a@example.com\nBcc: victim@example.com"} },
+		"subject": func(msg *Message) { msg.Subject = "Hi\rBcc: victim@example.com" },
+	}
+	for name, mutate := range tests {
+		t.Run(name, func(t *testing.T) {
+			msg := testMessage()
+			mutate(msg)
+			if err := fileTransport.Send(context.Background(), msg); !errors.Is(err, ErrInvalidHeader) {
+				t.Errorf("error = %v, want %v", err, ErrInvalidHeader)
+			}
+		})
+	}
+
+	if entries, _ := os.ReadDir(filepath.Join(dir, "new")); len(entries) != 0 {
+		t.Errorf("new holds %d files, want none", len(entries))
+	}
+}
@@ -0,0 +1,46 @@
+package transport
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryTransport keeps sent messages in memory so they can be inspected
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+func (t *MemoryTransport) Send(ctx context.Context, msg *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sent := *msg
+	sent.To = append([]string(nil), msg.To...)
+	t.messages = append(t.messages, sent)
+	return nil
+}
+
+// Messages returns a copy of every message sent so far
+func (t *MemoryTransport) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]Message(nil), t.messages...)
+}
+
+// Reset forgets all sent messages
+func (t *MemoryTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = nil
+}
@@ -0,0 +1,156 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+
+	"github.com/yoshapihoff/bricks/mails/internal/config"
+)
+
+const (
+	TLSModeNone     = "none"
+	TLSModeStartTLS = "starttls"
+	TLSModeImplicit = "tls"
+
+	AuthPlain = "plain"
+	AuthLogin = "login"
+)
+
+var (
+	ErrUnknownTLSMode       = errors.New("unknown SMTP TLS mode")
+	ErrUnknownAuthMechanism = errors.New("unknown SMTP auth mechanism")
+	ErrStartTLSUnsupported  = errors.New("SMTP server does not support STARTTLS")
+)
+
+type SMTPTransport struct {
+	config config.SMTPConfig
+	// rootCAs verifies the server certificate, nil uses the system roots
+	rootCAs *x509.CertPool
+}
+
+func NewSMTPTransport(config config.SMTPConfig) (*SMTPTransport, error) {
+	switch config.TLSMode {
+	case "", TLSModeNone, TLSModeStartTLS, TLSModeImplicit:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTLSMode, config.TLSMode)
+	}
+	switch config.AuthMechanism {
+	case "", AuthPlain, AuthLogin:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAuthMechanism, config.AuthMechanism)
+	}
+	return &SMTPTransport{
+		config: config,
+	}, nil
+}
+
+// Send delivers the message through the configured SMTP server
+func (t *SMTPTransport) Send(ctx context.Context, msg *Message) error {
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	conn, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	client, err := smtp.NewClient(conn, t.config.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if t.config.TLSMode == TLSModeStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return ErrStartTLSUnsupported
+		}
+		if err := client.StartTLS(t.tlsConfig()); err != nil {
+			return err
+		}
+	}
+
+	if t.config.Username != "" {
+		if err := client.Auth(t.auth()); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(msg.From); err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func (t *SMTPTransport) dial(ctx context.Context) (net.Conn, error) {
+	if t.config.TLSMode == TLSModeImplicit {
+		dialer := &tls.Dialer{Config: t.tlsConfig()}
+		return dialer.DialContext(ctx, "tcp", t.config.GetAddr())
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", t.config.GetAddr())
+}
+
+func (t *SMTPTransport) tlsConfig() *tls.Config {
+	return &tls.Config{ServerName: t.config.Host, RootCAs: t.rootCAs}
+}
+
+func (t *SMTPTransport) auth() smtp.Auth {
+	if t.config.AuthMechanism == AuthLogin {
+		return &loginAuth{username: t.config.Username, password: t.config.Password}
+	}
+	return smtp.PlainAuth("", t.config.Username, t.config.Password, t.config.Host)
+}
+
+// loginAuth implements the AUTH LOGIN mechanism which net/smtp does not provide
+type loginAuth struct {
+	username string
+	password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch string(fromServer) {
+	case "Username:":
+		return []byte(a.username), nil
+	case "Password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+}
@@ -0,0 +1,361 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yoshapihoff/bricks/mails/internal/config"
+)
+
+// smtpStandIn is a minimal SMTP server that offers STARTTLS and, once the connection is
+// encrypted, AUTH PLAIN and LOGIN. It records the envelope and data of every delivery.
+type smtpStandIn struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	username  string
+	password  string
+
+	mu         sync.Mutex
+	deliveries []delivery
+}
+
+type delivery struct {
+	mechanism string
+	from      string
+	to        []string
+	data      string
+}
+
+func newSMTPStandIn(t *testing.T, username, password string) (*smtpStandIn, *x509.CertPool) {
+	t.Helper()
+
+	cert, pool := selfSignedCertificate(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &smtpStandIn{
+		listener:  listener,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		username:  username,
+		password:  password,
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, pool
+}
+
+func (s *smtpStandIn) config(tlsMode, mechanism string) config.SMTPConfig {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return config.SMTPConfig{
+		Host:          host,
+		Port:          port,
+		Username:      s.username,
+		Password:      s.password,
+		TLSMode:       tlsMode,
+		AuthMechanism: mechanism,
+	}
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	text := textproto.NewConn(conn)
+	encrypted := false
+	var current delivery
+
+	reply := func(format string, args ...any) bool {
+		return text.PrintfLine(format, args...) == nil
+	}
+	readBase64 := func() (string, bool) {
+		line, err := text.ReadLine()
+		if err != nil {
+			return "", false
+		}
+		decoded, err := base64.StdEncoding.DecodeString(line)
+		return string(decoded), err == nil
+	}
+
+	if !reply("220 localhost ESMTP stand-in") {
+		return
+	}
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			if encrypted {
+				reply("250-localhost\r\n250 AUTH PLAIN LOGIN")
+			} else {
+				reply("250-localhost\r\n250 STARTTLS")
+			}
+		case "STARTTLS":
+			reply("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+			encrypted = true
+		case "AUTH":
+			if !encrypted {
+				reply("538 encryption required")
+				continue
+			}
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			var username, password string
+			switch strings.ToUpper(mechanism) {
+			case "PLAIN":
+				decoded, err := base64.StdEncoding.DecodeString(initial)
+				if err != nil {
+					reply("501 malformed response")
+					continue
+				}
+				parts := strings.Split(string(decoded), "\x00")
+				if len(parts) == 3 {
+					username, password = parts[1], parts[2]
+				}
+			case "LOGIN":
+				reply("334 %s", base64.StdEncoding.EncodeToString([]byte("Username:")))
+				var ok bool
+				if username, ok = readBase64(); !ok {
+					return
+				}
+				reply("334 %s", base64.StdEncoding.EncodeToString([]byte("Password:")))
+				if password, ok = readBase64(); !ok {
+					return
+				}
+			default:
+				reply("504 unsupported mechanism")
+				continue
+			}
+			if username != s.username || password != s.password {
+				reply("535 authentication failed")
+				continue
+			}
+			current.mechanism = strings.ToUpper(mechanism)
+			reply("235 authenticated")
+		case "MAIL":
+			if current.mechanism == "" {
+				reply("530 authentication required")
+				continue
+			}
+			current.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			reply("250 ok")
+		case "RCPT":
+			to := strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>")
+			if strings.HasPrefix(to, "unknown@") {
+				reply("550 no such user")
+				continue
+			}
+			current.to = append(current.to, to)
+			reply("250 ok")
+		case "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+			current.data = string(data)
+			s.mu.Lock()
+			s.deliveries = append(s.deliveries, current)
+			s.mu.Unlock()
+			current = delivery{mechanism: current.mechanism}
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (s *smtpStandIn) delivered() []delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]delivery(nil), s.deliveries...)
+}
+
+func selfSignedCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "smtp stand-in"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func testMessage() *Message {
+	return &Message{
+		From:     "no-reply@bricks.local",
+		To:       []string{"user@example.com", "other@example.com"},
+		Subject:  "Verify your email",
+		TextBody: "Your token is abc",
+		HTMLBody: "<p>Your token is abc</p>",
+	}
+}
+
+func TestSMTPTransportStartTLSAuth(t *testing.T) {
+	for _, mechanism := range []string{AuthPlain, AuthLogin} {
+		t.Run(mechanism, func(t *testing.T) {
+			server, pool := newSMTPStandIn(t, "mailer", "secret")
+			smtpTransport, err := NewSMTPTransport(server.config(TLSModeStartTLS, mechanism))
+			if err != nil {
+				t.Fatalf("new transport: %v", err)
+			}
+			smtpTransport.rootCAs = pool
+
+			if err := smtpTransport.Send(context.Background(), testMessage()); err != nil {
+				t.Fatalf("send: %v", err)
+			}
+
+			deliveries := server.delivered()
+			if len(deliveries) != 1 {
+				t.Fatalf("deliveries = %d, want 1", len(deliveries))
+			}
+			got := deliveries[0]
+			if got.mechanism != strings.ToUpper(mechanism) {
+				t.Errorf("mechanism = %q, want %q", got.mechanism, strings.ToUpper(mechanism))
+			}
+			if got.from != "no-reply@bricks.local" || strings.Join(got.to, ",") != "user@example.com,other@example.com" {
+				t.Errorf("envelope = %q -> %v", got.from, got.to)
+			}
+			header, err := textproto.NewReader(bufio.NewReader(strings.NewReader(got.data))).ReadMIMEHeader()
+			if err != nil {
+				t.Fatalf("read header: %v", err)
+			}
+			if header.Get("Subject") != "Verify your email" {
+				t.Errorf("Subject = %q", header.Get("Subject"))
+			}
+			if !strings.HasPrefix(header.Get("Content-Type"), "multipart/alternative") {
+				t.Errorf("Content-Type = %q", header.Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestSMTPTransportRejectsWrongCredentials(t *testing.T) {
+	server, pool := newSMTPStandIn(t, "mailer", "secret")
+	cfg := server.config(TLSModeStartTLS, AuthPlain)
+	cfg.Password = "wrong"
+	smtpTransport, err := NewSMTPTransport(cfg)
+	if err != nil {
+		t.Fatalf("new transport: %v", err)
+	}
+	smtpTransport.rootCAs = pool
+
+	err = smtpTransport.Send(context.Background(), testMessage())
+	if err == nil {
+		t.Fatal("send succeeded with a wrong password")
+	}
+	if !IsPermanent(err) {
+		t.Errorf("error %v is not permanent", err)
+	}
+	if len(server.delivered()) != 0 {
+		t.Error("message was delivered")
+	}
+}
+
+func TestSMTPTransportRejectedRecipientIsPermanent(t *testing.T) {
+	server, pool := newSMTPStandIn(t, "mailer", "secret")
+	smtpTransport, err := NewSMTPTransport(server.config(TLSModeStartTLS, AuthLogin))
+	if err != nil {
+		t.Fatalf("new transport: %v", err)
+	}
+	smtpTransport.rootCAs = pool
+
+	msg := testMessage()
+	msg.To = []string{"unknown@example.com"}
+	err = smtpTransport.Send(context.Background(), msg)
+	if !IsPermanent(err) {
+		t.Errorf("error = %v, want a permanent one", err)
+	}
+}
+
+func TestSMTPTransportRequiresTrustedCertificate(t *testing.T) {
+	server, _ := newSMTPStandIn(t, "mailer", "secret")
+	smtpTransport, err := NewSMTPTransport(server.config(TLSModeStartTLS, AuthPlain))
+	if err != nil {
+		t.Fatalf("new transport: %v", err)
+	}
+
+	var certErr *tls.CertificateVerificationError
+	if err := smtpTransport.Send(context.Background(), testMessage()); !errors.As(err, &certErr) {
+		t.Errorf("error = %v, want a certificate verification error", err)
+	}
+	if len(server.delivered()) != 0 {
+		t.Error("message was delivered over an untrusted connection")
+	}
+}
+
+func TestSMTPTransportRejectsHeaderInjection(t *testing.T) {
+	server, pool := newSMTPStandIn(t, "mailer", "secret")
+	smtpTransport, err := NewSMTPTransport(server.config(TLSModeStartTLS, AuthPlain))
+	if err != nil {
+		t.Fatalf("new transport: %v", err)
+	}
+	smtpTransport.rootCAs = pool
+
+	msg := testMessage()
+	msg.Subject = "Hello\r\nBcc: victim@example.com"
+	if err := smtpTransport.Send(context.Background(), msg); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("error = %v, want %v", err, ErrInvalidHeader)
+	}
+	if len(server.delivered()) != 0 {
+		t.Error("message was delivered")
+	}
+}
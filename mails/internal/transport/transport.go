@@ -0,0 +1,53 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/textproto"
+
+	"github.com/yoshapihoff/bricks/mails/internal/config"
+)
+
+const (
+	KindSMTP   = "smtp"
+	KindFile   = "file"
+	KindMemory = "memory"
+)
+
+var ErrUnknownTransport = errors.New("unknown mail transport")
+
+// Message is a rendered email ready to be delivered
+type Message struct {
+	From     string
+	To       []string
+	Subject  string
+	HTMLBody string
+	TextBody string
+}
+
+type Transport interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// IsPermanent reports whether sending the message again cannot succeed, like a 5xx SMTP reply
+func IsPermanent(err error) bool {
+	if errors.Is(err, ErrInvalidHeader) {
+		return true
+	}
+	var replyErr *textproto.Error
+	return errors.As(err, &replyErr) && replyErr.Code >= 500
+}
+
+// New creates the transport selected in the configuration
+func New(cfg *config.Config) (Transport, error) {
+	switch cfg.Transport.Kind {
+	case "", KindSMTP:
+		return NewSMTPTransport(cfg.SMTP)
+	case KindFile:
+		return NewFileTransport(cfg.Transport.DropDir)
+	case KindMemory:
+		return NewMemoryTransport(), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownTransport, cfg.Transport.Kind)
+}